@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExamAbort records why an exam was aborted, and is the EXAM_ABORTED payload
+type ExamAbort struct {
+	RoomID string    `json:"room_id"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+	By     string    `json:"by"` // Admin label that aborted it
+}
+
+// AbortExamHandler ends an exam early because something went wrong. Unlike
+// Complete, an Aborted room's results do not count: the timer stops, no
+// more answers are accepted, and every observer is told why. A room that is
+// already Complete cannot be aborted.
+func AbortExamHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Reason   string `json:"reason"` // Shown to the students
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "reason is required")
+		return
+	}
+	if len([]rune(req.Reason)) > maxAnnouncementLength {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("reason must be at most %d characters", maxAnnouncementLength))
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+	if room.ActiveStatus == Aborted || !canTransition(room.ActiveStatus, Aborted) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRoomState, fmt.Sprintf("A %v room cannot be aborted", room.ActiveStatus))
+		return
+	}
+
+	abort := ExamAbort{RoomID: room.ID, Reason: req.Reason, At: now(), By: actor}
+	room.ActiveStatus = Aborted
+	room.EndTime = abort.At
+	room.Abort = &abort
+	room.audit(actor, "abort_exam", req.Reason)
+
+	broadcastUpdate(room.ID, "EXAM_ABORTED", abort)
+	broadcastUpdate(room.ID, "ROOM_UPDATE", room.publicView())
+	broadcastRoomList()
+	requestSave(room.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Exam aborted",
+		"abort":   abort,
+	})
+}
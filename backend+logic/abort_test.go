@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// abortExam posts an abort for the room
+func abortExam(roomID, adminKey, reason string) *httptest.ResponseRecorder {
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "reason": "` + reason + `"}`)
+	rr := httptest.NewRecorder()
+	AbortExamHandler(rr, httptest.NewRequest("POST", "/abort-exam", bytes.NewBuffer(body)))
+	return rr
+}
+
+// readAbort reads messages until an EXAM_ABORTED arrives
+func readAbort(t *testing.T, conn *websocket.Conn) ExamAbort {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg struct {
+			Type    string    `json:"type"`
+			Payload ExamAbort `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed waiting for EXAM_ABORTED: %v", err)
+		}
+		if msg.Type == "EXAM_ABORTED" {
+			return msg.Payload
+		}
+	}
+}
+
+func TestAbortExam(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "abort-key")
+	sessionID, token := joinTestRoom(t, roomID, "alice", "REG900")
+
+	rr := httptest.NewRecorder()
+	StartExamHandler(rr, httptest.NewRequest("POST", "/start-exam", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "abort-key"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("StartExam returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, conn, "ACK", "subscribe_room")
+
+	if rr := abortExam(roomID, "wrong", "paper leaked"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", rr.Code)
+	}
+	if rr := abortExam(roomID, "abort-key", "  "); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 without a reason, got %d", rr.Code)
+	}
+	if rr := abortExam(roomID, "abort-key", "paper leaked"); rr.Code != http.StatusOK {
+		t.Fatalf("Abort returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := readAbort(t, conn); got.Reason != "paper leaked" || got.RoomID != roomID || got.By != ownerLabel {
+		t.Fatalf("Unexpected abort notice %+v", got)
+	}
+
+	mu.RLock()
+	room := rooms[roomID]
+	status, abort, endTime := room.ActiveStatus, room.Abort, room.EndTime
+	audited := false
+	for _, e := range room.AuditLog {
+		if e.Action == "abort_exam" && e.Detail == "paper leaked" {
+			audited = true
+		}
+	}
+	mu.RUnlock()
+	if status != Aborted || abort == nil || abort.Reason != "paper leaked" {
+		t.Fatalf("Expected an Aborted room with its reason, got %v %+v", status, abort)
+	}
+	if endTime.After(now()) {
+		t.Fatalf("Expected the timer to stop, end time is %v", endTime)
+	}
+	if !audited {
+		t.Fatal("Expected the abort in the audit log")
+	}
+
+	// Answers are no longer accepted, and the room cannot be aborted twice
+	body := `{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `", "answers": {"q1": "A"}}`
+	rr = httptest.NewRecorder()
+	SubmitHandler(rr, httptest.NewRequest("POST", "/submit", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected submit to be refused after an abort, got %d", rr.Code)
+	}
+	if rr := abortExam(roomID, "abort-key", "again"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 aborting twice, got %d", rr.Code)
+	}
+}
+
+func TestCompletedRoomCannotBeAborted(t *testing.T) {
+	roomID := createTestRoom(t, "done-key")
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "done-key", "active_status": 4}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Completing the room returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = abortExam(roomID, "done-key", "too late")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 aborting a Complete room, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeInvalidRoomState {
+		t.Fatalf("Expected %s, got %+v", codeInvalidRoomState, e)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if rooms[roomID].ActiveStatus != Complete || rooms[roomID].Abort != nil {
+		t.Fatalf("Expected the room to stay Complete, got %v", rooms[roomID].ActiveStatus)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// maxAgentProcesses bounds a single agent report
+const maxAgentProcesses = 5000
+
+// agentKey reports whether key is the room's current agent key. Caller holds mu.
+func (r *Room) agentKey(key string) bool {
+	if r.AgentKeyHash == "" || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashAdminKey(key)), []byte(r.AgentKeyHash)) == 1
+}
+
+// AgentKeyHandler issues the key scan agents use to report for the room.
+// Issuing again replaces the previous key, which stops working at once.
+func AgentKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	b, err := randomBytes(2 * sessionIDBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key := fmt.Sprintf("%x", b)
+	room.AgentKeyHash = hashAdminKey(key)
+	room.audit(actor, "issue_agent_key", "")
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Agent key issued successfully",
+		"agent_key": key,
+	})
+}
+
+// agentProcesses normalizes an agent's report into processes the matchers
+// understand: either raw listing output in one of the scanParsers formats,
+// or a list of processes whose names are lowercased like a local scan's
+func agentProcesses(procs []ProcessInfo, output, format string) ([]ProcessInfo, error) {
+	if output != "" {
+		parse, ok := scanParsers[format]
+		if !ok {
+			return nil, fmt.Errorf("format must be ps, tasklist or lines")
+		}
+		procs = parse(output)
+	} else {
+		for i, p := range procs {
+			name := p.Name
+			if name == "" {
+				name = path.Base(strings.ReplaceAll(p.Cmd, `\`, "/"))
+			}
+			procs[i].Name = strings.ToLower(name)
+		}
+	}
+	if len(procs) > maxAgentProcesses {
+		return nil, fmt.Errorf("At most %d processes may be reported", maxAgentProcesses)
+	}
+	return procs, nil
+}
+
+// AgentScanHandler accepts a process list collected by a scan agent on a
+// student's machine, evaluates it with the room's scan settings as /scan
+// would, and applies the room's triggers to that student. The agent
+// authenticates with the room's agent key and names the student by session
+// or regno.
+func AgentScanHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string        `json:"room_id"`
+		AgentKey      string        `json:"agent_key"`
+		UserSessionID string        `json:"user_session_id"` // Either this or regno
+		RegNo         string        `json:"regno"`
+		Host          string        `json:"host"`      // Machine the agent ran on, for the record
+		Processes     []ProcessInfo `json:"processes"` // Either this or output
+		Output        string        `json:"output"`    // Raw listing output
+		Format        string        `json:"format"`    // ps, tasklist or lines; with output only
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.UserSessionID == "" && req.RegNo == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "user_session_id or regno is required")
+		return
+	}
+	procs, err := agentProcesses(req.Processes, req.Output, req.Format)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	mu.RLock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.RUnlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if !room.agentKey(req.AgentKey) {
+		mu.RUnlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+	sessionID := ""
+	for _, s := range room.Students {
+		if (req.UserSessionID != "" && s.ID == req.UserSessionID) || (req.UserSessionID == "" && s.RegNo == req.RegNo) {
+			sessionID = s.ID
+			break
+		}
+	}
+	mode, allowed, ignored, safe := room.scanSettings()
+	mu.RUnlock()
+	if sessionID == "" {
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	result := evaluateScan(procs, mode, allowed, ignored, safe)
+	result.UserSessionID = sessionID
+	result.Host = req.Host
+	logf(r.Context(), "Agent scan for %s in room %s from host %q: %d processes", sessionID, req.RoomID, req.Host, len(procs))
+	applyScanPolicy(req.RoomID, sessionID, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// issueAgentKey issues the room's agent key
+func issueAgentKey(t *testing.T, roomID, adminKey string) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	AgentKeyHandler(rr, httptest.NewRequest("POST", "/admin/agent-key", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "`+adminKey+`"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Issuing the agent key returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp["agent_key"]
+}
+
+// agentScan posts an agent report
+func agentScan(body map[string]interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	rr := httptest.NewRecorder()
+	AgentScanHandler(rr, httptest.NewRequest("POST", "/scan/agent", bytes.NewBuffer(b)))
+	return rr
+}
+
+func TestAgentScanAttributedToStudent(t *testing.T) {
+	roomID := createTestRoom(t, "agent-admin")
+	sessionID, _ := joinTestRoom(t, roomID, "lab-pc-1", "REG1370")
+	joinTestRoom(t, roomID, "lab-pc-2", "REG1371")
+	setFlagPolicy(t, roomID, "agent-admin", `{"forbidden_apps": true}`)
+	key := issueAgentKey(t, roomID, "agent-admin")
+
+	// Reported by session, as a process list
+	rr := agentScan(map[string]interface{}{
+		"room_id": roomID, "agent_key": key, "user_session_id": sessionID, "host": "LAB-01",
+		"processes": []map[string]interface{}{{"pid": 10, "cmd": `C:\Program Files\Discord\Discord.exe`}, {"pid": 11, "name": "explorer.exe"}},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Agent scan returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var result ScanResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if !result.ForbiddenFound || result.UserSessionID != sessionID || result.Host != "LAB-01" {
+		t.Fatalf("Expected a forbidden app attributed to the student, got %+v", result)
+	}
+	if status := studentStatus(roomID, "lab-pc-1"); status != Flagged {
+		t.Fatalf("Expected the agent's report to flag the student, got %v", status)
+	}
+	if status := studentStatus(roomID, "lab-pc-2"); status != Online {
+		t.Fatalf("Expected the other student untouched, got %v", status)
+	}
+
+	// Reported by regno, as raw ps output
+	rr = agentScan(map[string]interface{}{"room_id": roomID, "agent_key": key, "regno": "REG1371", "output": sampleVMPs, "format": "ps"})
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || len(result.RemoteAccess) != 4 {
+		t.Fatalf("Expected the raw output to be parsed, got %d %+v", rr.Code, result)
+	}
+	if status := studentStatus(roomID, "lab-pc-2"); status != Flagged {
+		t.Fatalf("Expected firefox in the raw output to flag the student, got %v", status)
+	}
+}
+
+func TestAgentScanAuthentication(t *testing.T) {
+	roomID := createTestRoom(t, "agent-auth")
+	sessionID, token := joinTestRoom(t, roomID, "lab-pc-3", "REG1372")
+	report := func(key string) int {
+		return agentScan(map[string]interface{}{"room_id": roomID, "agent_key": key, "user_session_id": sessionID, "processes": []ProcessInfo{}}).Code
+	}
+
+	// No key issued yet; a student's own token is not an agent key either
+	if code := report(""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 before a key is issued, got %d", code)
+	}
+	if code := report(token); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a session token, got %d", code)
+	}
+
+	old := issueAgentKey(t, roomID, "agent-auth")
+	fresh := issueAgentKey(t, roomID, "agent-auth")
+	if code := report(old); code != http.StatusUnauthorized {
+		t.Fatalf("Expected the replaced key to stop working, got %d", code)
+	}
+	if code := report(fresh); code != http.StatusOK {
+		t.Fatalf("Expected the current key to work, got %d", code)
+	}
+
+	rr := agentScan(map[string]interface{}{"room_id": roomID, "agent_key": fresh, "regno": "REG0000", "processes": []ProcessInfo{}})
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown student, got %d", rr.Code)
+	}
+
+	mu.RLock()
+	view := rooms[roomID].publicView()
+	mu.RUnlock()
+	if view.AgentKeyHash != "" {
+		t.Error("Expected the public view to hide the agent key hash")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAnnouncementLength bounds an announcement's text, in characters
+const maxAnnouncementLength = 500
+
+// announcementSeverities are the severities the UI knows how to style
+var announcementSeverities = map[string]bool{
+	"info":    true,
+	"warning": true,
+}
+
+// Announcement is a proctor's message to everyone in a room, and the
+// ANNOUNCEMENT payload
+type Announcement struct {
+	RoomID   string    `json:"room_id"`
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"` // "info" or "warning"
+	At       time.Time `json:"at"`
+	By       string    `json:"by"` // Admin label that sent it
+}
+
+// AnnounceHandler broadcasts a message to the room's observers and keeps it
+// as the room's LastAnnouncement, so students who join or reconnect later
+// still see it
+func AnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Message  string `json:"message"`
+		Severity string `json:"severity"` // Defaults to "info"
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "message is required")
+		return
+	}
+	if len([]rune(req.Message)) > maxAnnouncementLength {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("message must be at most %d characters", maxAnnouncementLength))
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = "info"
+	}
+	if !announcementSeverities[req.Severity] {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "severity must be info or warning")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	a := Announcement{RoomID: room.ID, Message: req.Message, Severity: req.Severity, At: now(), By: actor}
+	room.LastAnnouncement = &a
+	room.audit(actor, "announce", req.Severity+": "+req.Message)
+	broadcastUpdate(room.ID, "ANNOUNCEMENT", a)
+	requestSave(room.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Announcement sent",
+		"announcement": a,
+	})
+}
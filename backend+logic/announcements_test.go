@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// announce posts an announcement to the room
+func announce(roomID, adminKey, message, severity string) *httptest.ResponseRecorder {
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "message": "` + message + `", "severity": "` + severity + `"}`)
+	rr := httptest.NewRecorder()
+	AnnounceHandler(rr, httptest.NewRequest("POST", "/admin/announce", bytes.NewBuffer(body)))
+	return rr
+}
+
+// readAnnouncement reads messages until an ANNOUNCEMENT arrives
+func readAnnouncement(t *testing.T, conn *websocket.Conn) Announcement {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg struct {
+			Type    string       `json:"type"`
+			Payload Announcement `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed waiting for ANNOUNCEMENT: %v", err)
+		}
+		if msg.Type == "ANNOUNCEMENT" {
+			return msg.Payload
+		}
+	}
+}
+
+func TestAnnouncementReachesSubscribers(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "announce-key")
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, conn, "ACK", "subscribe_room")
+
+	if rr := announce(roomID, "announce-key", "10 minutes remaining", "warning"); rr.Code != http.StatusOK {
+		t.Fatalf("Announce returned %d: %s", rr.Code, rr.Body.String())
+	}
+	got := readAnnouncement(t, conn)
+	if got.Message != "10 minutes remaining" || got.Severity != "warning" || got.RoomID != roomID || got.By != ownerLabel {
+		t.Fatalf("Unexpected announcement %+v", got)
+	}
+
+	// A client subscribing later gets it after the snapshot
+	late := dial()
+	late.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, late, "ACK", "subscribe_room")
+	if got := readAnnouncement(t, late); got.Message != "10 minutes remaining" {
+		t.Fatalf("Expected the last announcement on subscribe, got %+v", got)
+	}
+}
+
+func TestAnnounceValidation(t *testing.T) {
+	roomID := createTestRoom(t, "announce-check")
+
+	if rr := announce(roomID, "wrong", "hello", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", rr.Code)
+	}
+	if rr := announce(roomID, "announce-check", "   ", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an empty message, got %d", rr.Code)
+	}
+	if rr := announce(roomID, "announce-check", "hello", "critical"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown severity, got %d", rr.Code)
+	}
+
+	// Severity defaults to info and the room keeps the announcement
+	if rr := announce(roomID, "announce-check", "restart your scanner", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Announce returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	last := rooms[roomID].publicView().LastAnnouncement
+	mu.RUnlock()
+	if last == nil || last.Severity != "info" || last.Message != "restart your scanner" {
+		t.Fatalf("Expected the room to keep the announcement, got %+v", last)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+)
+
+// sessionSecret signs student session tokens. It comes from
+// PROCTOR_SESSION_SECRET so tokens survive restarts; otherwise a random
+// secret is generated and students must rejoin after a restart.
+var sessionSecret = loadSessionSecret()
+
+func loadSessionSecret() []byte {
+	if secret := os.Getenv("PROCTOR_SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	b, err := randomBytes(32)
+	if err != nil {
+		panic("cannot generate session secret: " + err.Error())
+	}
+	return b
+}
+
+// masterKey comes from PROCTOR_MASTER_KEY and is accepted as an admin key on
+// every room, so provisioning scripts can manage rooms they did not create.
+// Master access is disabled when it is unset.
+var masterKey = os.Getenv("PROCTOR_MASTER_KEY")
+
+// masterLabel identifies the master key in the audit log
+const masterLabel = "master"
+
+// isMasterKey checks key against masterKey in constant time
+func isMasterKey(key string) bool {
+	return masterKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(masterKey)) == 1
+}
+
+// hashAdminKey returns the hex SHA-256 of an admin key. Rotated keys are
+// random, so a plain hash is enough to keep them out of saved state.
+func hashAdminKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// signSession returns the token a student must present for their session
+func signSession(roomID, sessionID string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(roomID + ":" + sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySessionToken checks a presented token in constant time
+func verifySessionToken(roomID, sessionID, token string) error {
+	if token == "" {
+		return errMissingToken
+	}
+	if !hmac.Equal([]byte(signSession(roomID, sessionID)), []byte(token)) {
+		return errInvalidToken
+	}
+	return nil
+}
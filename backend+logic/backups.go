@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupsDir holds earlier copies of the room files, inside dataDir, in a
+// directory per room, so an accidental change or delete can be undone
+const backupsDir = "backups"
+
+// backupTimeFormat names backup files so that they sort by age
+const backupTimeFormat = "20060102T150405.000Z"
+
+// backupKeep is how many backups are kept per room, where 0 disables them.
+// backupInterval is the least time between two backups of a room that keeps
+// changing; deletes and restores are always backed up. Set with -backup-keep
+// and -backup-interval.
+var (
+	backupKeep     = 10
+	backupInterval = 5 * time.Minute
+)
+
+var (
+	backupMu   sync.Mutex
+	lastBackup = make(map[string]time.Time) // When each room was last backed up
+)
+
+// deletedVersions keeps the Version each room had when it was deleted, which
+// may be past its last saved one, until the room is restored. Guarded by mu.
+var deletedVersions = make(map[string]uint64)
+
+// forgetRoom deletes a room, remembering its Version for a restore. Caller
+// holds mu.
+func forgetRoom(id string) {
+	if room, exists := rooms[id]; exists {
+		deletedVersions[id] = room.Version
+		delete(rooms, id)
+	}
+}
+
+// Backup is one saved copy of a room
+type Backup struct {
+	ID   string    `json:"id"` // Passed back to restore it
+	At   time.Time `json:"at"`
+	Size int64     `json:"size"`
+}
+
+func roomBackupDir(id string) string {
+	return filepath.Join(dataPath(backupsDir), id)
+}
+
+// backupRoomFile keeps the room's file as it is now before it is replaced
+// or removed, then prunes the room's backups to backupKeep. Unless force is
+// set it does nothing within backupInterval of the room's last backup. The
+// backup is a hard link, which costs nothing since the file is replaced by
+// a rename rather than rewritten.
+func backupRoomFile(id string, force bool) error {
+	if backupKeep <= 0 || inMemory {
+		return nil
+	}
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	t := now()
+	if last, ok := lastBackup[id]; ok && !force && t.Sub(last) < backupInterval {
+		return nil
+	}
+	if err := os.MkdirAll(roomBackupDir(id), 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(roomBackupDir(id), t.UTC().Format(backupTimeFormat)+".json")
+	if err := os.Link(roomFile(id), name); err != nil {
+		if os.IsNotExist(err) || os.IsExist(err) {
+			return nil // Nothing saved yet, or already backed up this instant
+		}
+		return err
+	}
+	lastBackup[id] = t
+	return pruneBackups(id)
+}
+
+// listBackups returns a room's backups, newest first
+func listBackups(id string) ([]Backup, error) {
+	entries, err := os.ReadDir(roomBackupDir(id))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, e := range entries {
+		at, err := time.Parse(backupTimeFormat, strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || e.IsDir() {
+			continue
+		}
+		b := Backup{ID: e.Name(), At: at}
+		if info, err := e.Info(); err == nil {
+			b.Size = info.Size()
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest backupKeep backups of a room
+func pruneBackups(id string) error {
+	backups, err := listBackups(id)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(backupKeep, len(backups)):] {
+		if err := os.Remove(filepath.Join(roomBackupDir(id), b.ID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// highestBackupVersion returns the highest Version among a room's backups
+func highestBackupVersion(id string) uint64 {
+	backups, _ := listBackups(id)
+	var highest uint64
+	for _, b := range backups {
+		if room, err := readRoomFile(filepath.Join(roomBackupDir(id), b.ID)); err == nil {
+			highest = max(highest, room.Version)
+		}
+	}
+	return highest
+}
+
+// readBackup loads one of a room's backups. Only IDs from listBackups are
+// accepted, so a request cannot name a file outside the room's backups.
+func readBackup(roomID, backupID string) (*Room, bool) {
+	if !validRoomFileID(roomID) {
+		return nil, false
+	}
+	backups, err := listBackups(roomID)
+	if err != nil {
+		return nil, false
+	}
+	for _, b := range backups {
+		if b.ID == backupID {
+			room, err := readRoomFile(filepath.Join(roomBackupDir(roomID), b.ID))
+			if err != nil || room.ID != roomID {
+				return nil, false
+			}
+			return room, true
+		}
+	}
+	return nil, false
+}
+
+// backupAdminLabel authenticates an admin for a room's backups: against the
+// live room, or against the backup itself once the room is gone. Caller
+// holds mu.
+func backupAdminLabel(roomID, key string, backup *Room) (string, bool) {
+	if room, exists := rooms[roomID]; exists {
+		return room.adminLabel(key)
+	}
+	if backup == nil {
+		return "", false
+	}
+	return backup.adminLabel(key)
+}
+
+// BackupsHandler lists a room's backups, newest first. A deleted room's
+// backups are listed for the keys of its newest backup.
+func BackupsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	roomID := q.Get("room_id")
+	if !validRoomFileID(roomID) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
+		return
+	}
+	backups, err := listBackups(roomID)
+	if err != nil {
+		logf(r.Context(), "Error listing backups of %s: %v", roomID, err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Backups could not be read")
+		return
+	}
+
+	var newest *Room
+	if len(backups) > 0 {
+		newest, _ = readBackup(roomID, backups[0].ID)
+	}
+	mu.RLock()
+	_, exists := rooms[roomID]
+	_, ok := backupAdminLabel(roomID, q.Get("admin_key"), newest)
+	mu.RUnlock()
+	if !exists && len(backups) == 0 {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"room_id": roomID,
+		"backups": backups,
+	})
+}
+
+// RestoreBackupHandler puts a room back as one of its backups had it,
+// bringing back a deleted room too. The state being replaced is backed up
+// first, so a restore can itself be undone. A room that still exists keeps
+// its current admin, co-proctor and agent keys.
+func RestoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Backup   string `json:"backup"` // An ID from /admin/backups
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	restored, found := readBackup(req.RoomID, req.Backup)
+	// ETags clients cached must never be issued again for other content, so
+	// the restored room carries on past every version it has had
+	seen := highestBackupVersion(req.RoomID)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	actor, ok := backupAdminLabel(req.RoomID, req.AdminKey, restored)
+	if !found {
+		if !ok {
+			writeError(w, errUnauthorized)
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, codeBackupNotFound, "Backup not found")
+		return
+	}
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+	if err := backupRoomFile(req.RoomID, true); err != nil {
+		logf(r.Context(), "Error backing up %s before a restore: %v", req.RoomID, err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "The current state could not be backed up, so nothing was restored")
+		return
+	}
+
+	prepareLoadedRoom(restored)
+	restored.Version = max(restored.Version, seen, deletedVersions[req.RoomID])
+	delete(deletedVersions, req.RoomID)
+	if current, exists := rooms[req.RoomID]; exists {
+		restored.Version = max(restored.Version, current.Version)
+		// Keys rotated or revoked since the backup stay that way
+		restored.AdminKey, restored.AdminKeyHash = current.AdminKey, current.AdminKeyHash
+		restored.CoProctors = current.CoProctors
+		restored.AgentKeyHash = current.AgentKeyHash
+	}
+	rooms[req.RoomID] = restored
+	restored.audit(actor, "restore_backup", req.Backup)
+	broadcastUpdate(restored.ID, "ROOM_UPDATE", restored.publicView())
+	broadcastRoomList()
+	requestSave(restored.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Room restored from backup",
+		"room_id": restored.ID,
+		"backup":  req.Backup,
+	})
+}
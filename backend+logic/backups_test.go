@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useBackups keeps n backups per room, taking one on every save
+func useBackups(t *testing.T, n int) {
+	t.Helper()
+	prevKeep, prevInterval := backupKeep, backupInterval
+	backupKeep, backupInterval = n, 0
+	t.Cleanup(func() { backupKeep, backupInterval = prevKeep, prevInterval })
+}
+
+// renameAndSave changes the room's session name and writes it to disk
+func renameAndSave(roomID, name string) {
+	mu.Lock()
+	rooms[roomID].SessionName = name
+	mu.Unlock()
+	requestSave(roomID)
+	flush()
+}
+
+func TestBackupsCreatedAndPruned(t *testing.T) {
+	useStore(t, "json")
+	useBackups(t, 3)
+	advance := useFakeClock(t, time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "backup-key")
+	flush()
+
+	for i := 0; i < 5; i++ {
+		advance(time.Second)
+		renameAndSave(roomID, "Take "+string(rune('A'+i)))
+	}
+	backups, err := listBackups(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("Expected backups pruned to 3, got %d", len(backups))
+	}
+	if !backups[0].At.After(backups[2].At) {
+		t.Fatalf("Expected the newest backup first, got %+v", backups)
+	}
+
+	// Within the backup interval further saves take no backup
+	backupInterval = time.Hour
+	advance(time.Second)
+	renameAndSave(roomID, "Take F")
+	if again, _ := listBackups(roomID); again[0].ID != backups[0].ID {
+		t.Fatalf("Expected no new backup within the interval, got %+v", again)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	useStore(t, "json")
+	useBackups(t, 5)
+	advance := useFakeClock(t, time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "restore-key")
+	renameAndSave(roomID, "Original")
+	advance(time.Second)
+	renameAndSave(roomID, "Mistake")
+
+	list := func(key string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		BackupsHandler(rr, httptest.NewRequest("GET", "/admin/backups?room_id="+roomID+"&admin_key="+key, nil))
+		return rr
+	}
+	if rr := list("wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 listing with a wrong key, got %d", rr.Code)
+	}
+	rr := list("restore-key")
+	var resp struct {
+		Backups []Backup `json:"backups"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Backups) == 0 {
+		t.Fatalf("Expected backups to be listed: %s", rr.Body.String())
+	}
+
+	restore := func(backup string) *httptest.ResponseRecorder {
+		body := `{"room_id": "` + roomID + `", "admin_key": "restore-key", "backup": "` + backup + `"}`
+		rr := httptest.NewRecorder()
+		RestoreBackupHandler(rr, httptest.NewRequest("POST", "/admin/restore-backup", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := restore("../" + roomID + ".json"); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a path outside the backups, got %d", rr.Code)
+	}
+
+	// The newest backup holds the room as it was before the last save
+	advance(time.Second)
+	if rr := restore(resp.Backups[0].ID); rr.Code != http.StatusOK {
+		t.Fatalf("Restore returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	name := rooms[roomID].SessionName
+	mu.RUnlock()
+	if name != "Original" {
+		t.Fatalf("Expected the session name restored to Original, got %q", name)
+	}
+
+	// A deleted room comes back with its own key, and with a version past
+	// any it had, even changes that were never saved
+	mu.Lock()
+	markChanged(roomID)
+	markChanged(roomID)
+	lastVersion := rooms[roomID].Version
+	forgetRoom(roomID)
+	mu.Unlock()
+	requestSave(roomID)
+	flush()
+	backups, _ := listBackups(roomID)
+	advance(time.Second)
+	if rr := restore(backups[0].ID); rr.Code != http.StatusOK {
+		t.Fatalf("Restoring a deleted room returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	room, exists := rooms[roomID]
+	mu.RUnlock()
+	if !exists {
+		t.Fatal("Expected the deleted room to be restored")
+	}
+	if room.Version <= lastVersion {
+		t.Fatalf("Expected the restored version past %d, got %d", lastVersion, room.Version)
+	}
+
+	// After a restart only the backups remember the versions
+	flush()
+	mu.Lock()
+	lastVersion = room.Version
+	forgetRoom(roomID)
+	delete(deletedVersions, roomID)
+	mu.Unlock()
+	requestSave(roomID)
+	flush()
+	backups, _ = listBackups(roomID)
+	advance(time.Second)
+	if rr := restore(backups[len(backups)-1].ID); rr.Code != http.StatusOK {
+		t.Fatalf("Restoring the oldest backup returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	version := rooms[roomID].Version
+	mu.RUnlock()
+	if version <= lastVersion {
+		t.Fatalf("Expected the restored version past %d, got %d", lastVersion, version)
+	}
+}
+
+func TestRestoreKeepsCurrentKeys(t *testing.T) {
+	useStore(t, "json")
+	useBackups(t, 5)
+	advance := useFakeClock(t, time.Date(2026, 5, 2, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "old-owner-key")
+	var added map[string]string
+	json.NewDecoder(postCoProctor(t, AddCoProctorHandler, roomID, "old-owner-key", "hall-x").Body).Decode(&added)
+	mu.Lock()
+	rooms[roomID].AgentKeyHash = hashAdminKey("old-agent-key")
+	mu.Unlock()
+	renameAndSave(roomID, "Before")
+	advance(time.Second)
+	renameAndSave(roomID, "Leaked")
+
+	// The leaked keys are replaced after the backup was taken
+	rr := httptest.NewRecorder()
+	RotateKeyHandler(rr, httptest.NewRequest("POST", "/admin/rotate-key", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "old-owner-key"}`)))
+	var rotated map[string]string
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	newKey := rotated["admin_key"]
+	if rr := postCoProctor(t, RevokeCoProctorHandler, roomID, newKey, "hall-x"); rr.Code != http.StatusOK {
+		t.Fatalf("Revoke returned %d", rr.Code)
+	}
+	mu.Lock()
+	rooms[roomID].AgentKeyHash = hashAdminKey("new-agent-key")
+	mu.Unlock()
+
+	backups, _ := listBackups(roomID)
+	body := `{"room_id": "` + roomID + `", "admin_key": "` + newKey + `", "backup": "` + backups[0].ID + `"}`
+	rr = httptest.NewRecorder()
+	RestoreBackupHandler(rr, httptest.NewRequest("POST", "/admin/restore-backup", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Restore returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	room := rooms[roomID]
+	if room.SessionName != "Before" {
+		t.Fatalf("Expected the backup restored, got %q", room.SessionName)
+	}
+	for _, key := range []string{"old-owner-key", added["key"]} {
+		if _, ok := room.adminLabel(key); ok {
+			t.Errorf("Restore brought back the replaced key %q", key)
+		}
+	}
+	if label, ok := room.adminLabel(newKey); !ok || label != ownerLabel {
+		t.Errorf("Expected the current key to keep working, got %q %v", label, ok)
+	}
+	if room.AgentKeyHash != hashAdminKey("new-agent-key") {
+		t.Error("Restore brought back the replaced agent key")
+	}
+}
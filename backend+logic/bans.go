@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+var errBanned = errors.New("You have been removed from this room")
+
+// Ban keeps a removed student from rejoining. Any non-empty field that
+// matches a join attempt rejects it; SessionID shuts out the kicked session
+// itself, which may have no user_id or regno to match.
+type Ban struct {
+	UserID    string    `json:"user_id,omitempty"`
+	RegNo     string    `json:"regno,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+	By        string    `json:"by"` // Admin label that issued the ban
+}
+
+// remoteHost strips the port from a RemoteAddr so bans survive reconnects
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// isBanned reports whether any ban matches the identity. Caller holds mu.
+func (r *Room) isBanned(userID, regNo, remoteAddr string) bool {
+	ip := remoteHost(remoteAddr)
+	for _, b := range r.Bans {
+		if (b.UserID != "" && b.UserID == userID) ||
+			(b.RegNo != "" && b.RegNo == regNo) ||
+			(b.IP != "" && b.IP == ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// studentBanned reports whether a ban matches the student's session or
+// identity. Caller holds mu.
+func (r *Room) studentBanned(s UserSession) bool {
+	for _, b := range r.Bans {
+		if b.SessionID != "" && b.SessionID == s.ID {
+			return true
+		}
+	}
+	return r.isBanned(s.UserID, s.RegNo, "")
+}
+
+// sessionBanned reports whether the session's student has been banned. Caller holds mu.
+func (r *Room) sessionBanned(sessionID string) bool {
+	for _, s := range r.Students {
+		if s.ID == sessionID {
+			return r.studentBanned(s)
+		}
+	}
+	return false
+}
+
+// KickHandler removes a student: they go Offline, their open websockets are
+// closed, and they are banned from rejoining by session, user_id and regno,
+// and by IP address when ban_ip is set
+func KickHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		UserID   string `json:"user_id"`
+		BanIP    bool   `json:"ban_ip"` // Off by default; lab machines often share an address
+		Reason   string `json:"reason"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		mu.Unlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	idx := -1
+	for i, s := range room.Students {
+		if s.UserID == req.UserID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		mu.Unlock()
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	student := &room.Students[idx]
+	student.ActiveStatus = Offline
+	ban := Ban{UserID: student.UserID, RegNo: student.RegNo, SessionID: student.ID, Reason: req.Reason, At: now(), By: actor}
+	if req.BanIP {
+		ban.IP = remoteHost(student.IpAddress)
+	}
+	room.Bans = append(room.Bans, ban)
+	room.audit(actor, "kick", student.UserID)
+
+	broadcastStudent(req.RoomID, *student)
+	// Lets a client watching the room notice it was removed
+	broadcastUpdate(req.RoomID, "STUDENT_KICKED", map[string]string{"room_id": req.RoomID, "user_id": student.UserID})
+	requestSave(req.RoomID)
+	sessionID := student.ID
+	mu.Unlock()
+
+	// After the broadcast, so the kicked client can still learn why
+	if wsHub != nil {
+		wsHub.closeSession(sessionID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Student removed from room",
+	})
+}
+
+// UnbanHandler lifts every ban recorded for a user_id
+func UnbanHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		UserID   string `json:"user_id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	kept := room.Bans[:0]
+	for _, b := range room.Bans {
+		if b.UserID != req.UserID {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(room.Bans) {
+		writeJSONError(w, http.StatusNotFound, codeBanNotFound, "No ban found for this user")
+		return
+	}
+	room.Bans = kept
+	room.audit(actor, "unban", req.UserID)
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Ban lifted",
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func postKick(t *testing.T, handler http.HandlerFunc, body string) int {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/admin/kick", bytes.NewBufferString(body)))
+	return rr.Code
+}
+
+func rejoin(roomID, userID, regNo, token string) int {
+	body := `{"room_id": "` + roomID + `", "user_id": "` + userID + `", "username": "` + userID + `", "regno": "` + regNo + `", "session_token": "` + token + `"}`
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(body)))
+	return rr.Code
+}
+
+func TestKickAndUnban(t *testing.T) {
+	roomID := createTestRoom(t, "kick-key")
+	sessionID, token := joinTestRoom(t, roomID, "cheater", "REG900")
+
+	if code := postKick(t, KickHandler, `{"room_id": "`+roomID+`", "admin_key": "wrong", "user_id": "cheater"}`); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", code)
+	}
+	if code := postKick(t, KickHandler, `{"room_id": "`+roomID+`", "admin_key": "kick-key", "user_id": "cheater", "reason": "phone"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if status := studentStatus(roomID, "cheater"); status != Offline {
+		t.Fatalf("Expected the kicked student to be Offline, got %v", status)
+	}
+
+	// Neither the same user_id nor the same regno under a new id gets back in
+	if code := rejoin(roomID, "cheater", "REG900", token); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 on rejoin, got %d", code)
+	}
+	if code := rejoin(roomID, "cheater-2", "REG900", ""); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a banned regno, got %d", code)
+	}
+
+	// The old session cannot ping itself back Online
+	ping := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `"}`)
+	rr := httptest.NewRecorder()
+	PingHandler(rr, httptest.NewRequest("POST", "/ping", bytes.NewBuffer(ping)))
+	if rr.Code != http.StatusForbidden || studentStatus(roomID, "cheater") != Offline {
+		t.Fatalf("Expected the kicked session's ping to be refused, got %d", rr.Code)
+	}
+
+	if code := postKick(t, UnbanHandler, `{"room_id": "`+roomID+`", "admin_key": "kick-key", "user_id": "cheater"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 on unban, got %d", code)
+	}
+	if code := rejoin(roomID, "cheater", "REG900", token); code != http.StatusOK {
+		t.Fatalf("Expected rejoin after unban to succeed, got %d", code)
+	}
+	if code := postKick(t, UnbanHandler, `{"room_id": "`+roomID+`", "admin_key": "kick-key", "user_id": "cheater"}`); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 when no ban is left, got %d", code)
+	}
+}
+
+func TestKickClosesSocketsAndBansAnonymousSession(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "kick-key")
+
+	// No user_id or regno, so only the session itself can be banned
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`"}`)))
+	var joined map[string]string
+	json.NewDecoder(rr.Body).Decode(&joined)
+	sessionID, token := joined["user_session_id"], joined["session_token"]
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, conn, "ACK", "hello")
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, conn, "ACK", "subscribe_room")
+
+	if code := postKick(t, KickHandler, `{"room_id": "`+roomID+`", "admin_key": "kick-key", "user_id": ""}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	// The socket hears why, then is closed
+	kicked := false
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg Message
+		err := conn.ReadJSON(&msg)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected the socket to be closed, got %v", err)
+		}
+		kicked = kicked || msg.Type == "STUDENT_KICKED"
+	}
+	if !kicked {
+		t.Fatal("Expected STUDENT_KICKED before the socket closed")
+	}
+
+	// Neither a ping nor a new socket brings the session back Online
+	ping := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `"}`)
+	rr = httptest.NewRecorder()
+	PingHandler(rr, httptest.NewRequest("POST", "/ping", bytes.NewBuffer(ping)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected the kicked session's ping to be refused, got %d", rr.Code)
+	}
+	again := dial()
+	again.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, again, "NACK", "hello")
+	mu.RLock()
+	status := rooms[roomID].Students[0].ActiveStatus
+	mu.RUnlock()
+	if status != Offline {
+		t.Fatalf("Expected the kicked session to stay Offline, got %v", status)
+	}
+}
+
+func TestBanByIP(t *testing.T) {
+	room := &Room{Bans: []Ban{{IP: "10.1.2.3"}}}
+	if !room.isBanned("someone", "", "10.1.2.3:51234") {
+		t.Fatal("Expected the IP ban to match regardless of port")
+	}
+	if room.isBanned("someone", "", "10.1.2.4:51234") {
+		t.Fatal("Expected a different IP to be allowed")
+	}
+}
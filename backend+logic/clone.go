@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// cloneConfig copies the room's configuration into a new Waiting room with
+// the given ID. Students, co-proctors, bans, join tokens, announcements and
+// the audit log stay behind. Caller holds mu.
+func (r *Room) cloneConfig(id string) *Room {
+	c := &Room{
+		ID:               id,
+		HostID:           r.HostID,
+		SessionName:      r.SessionName,
+		Sets:             make(map[string]string, len(r.Sets)),
+		ActiveStatus:     Waiting,
+		AdminKey:         r.AdminKey,
+		AdminKeyHash:     r.AdminKeyHash,
+		TimeAllocated:    r.TimeAllocated,
+		Students:         []UserSession{},
+		CreatedAt:        now(),
+		UpdatedAt:        now(),
+		ScanMode:         r.ScanMode,
+		AllowedApps:      append([]string(nil), r.AllowedApps...),
+		SystemApps:       append([]string(nil), r.SystemApps...),
+		ScanIgnore:       append([]string(nil), r.ScanIgnore...),
+		BlurThreshold:    r.BlurThreshold,
+		BlurWindow:       r.BlurWindow,
+		OfflineGrace:     r.OfflineGrace,
+		ScanInterval:     r.ScanInterval,
+		Rubric:           r.Rubric.clone(),
+		ShowLeaderboard:  r.ShowLeaderboard,
+		LeaderboardSize:  r.LeaderboardSize,
+		RequireJoinToken: r.RequireJoinToken,
+		RequiredFields:   append([]string(nil), r.RequiredFields...),
+		FlagPolicy:       r.FlagPolicy.clone(),
+	}
+	for k, v := range r.Sets {
+		c.Sets[k] = v
+	}
+	return c
+}
+
+// CloneRoomHandler creates a new Waiting room with the configuration of an
+// existing one, for running the same exam with another section. The clone
+// keeps the source's owner key unless new_admin_key is given.
+func CloneRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID      string  `json:"room_id"`
+		AdminKey    string  `json:"admin_key"`
+		NewAdminKey string  `json:"new_admin_key"` // Optional owner key for the clone
+		SessionName *string `json:"session_name"`  // Optional, defaults to the source's
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	src, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := src.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	roomID, err := allocateRoomID()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	clone := src.cloneConfig(roomID)
+	if req.NewAdminKey != "" {
+		clone.AdminKey = req.NewAdminKey
+		clone.AdminKeyHash = ""
+	}
+	if req.SessionName != nil {
+		clone.SessionName = *req.SessionName
+	}
+	clone.audit(actor, "clone_room", "from "+src.ID)
+	src.audit(actor, "clone_room", "to "+roomID)
+
+	rooms[roomID] = clone
+	broadcastRoomList()
+	requestSave(src.ID, roomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"room_id": roomID,
+		"message": "Room cloned successfully",
+	})
+}
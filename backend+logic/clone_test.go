@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCloneRoom(t *testing.T) {
+	srcID := createTestRoom(t, "clone-key")
+	body := []byte(`{"room_id": "` + srcID + `", "admin_key": "clone-key", "sets": {"A": "https://example.com/a", "B": "https://example.com/b"},
+		"time_allocated": "90m", "scan_mode": 1, "allowed_apps": ["code"], "offline_grace": "1m",
+		"flag_policy": {"forbidden_apps": true, "missed_heartbeats": 4}}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	joinTestRoom(t, srcID, "section-a", "REG1340")
+	mu.Lock()
+	rooms[srcID].ActiveStatus = Active
+	mu.Unlock()
+
+	clone := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		CloneRoomHandler(rr, httptest.NewRequest("POST", "/admin/clone-room", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := clone(`{"room_id": "` + srcID + `", "admin_key": "wrong"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", rr.Code)
+	}
+
+	rr = clone(`{"room_id": "` + srcID + `", "admin_key": "clone-key", "session_name": "Section B"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Clone returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	cloneID := resp["room_id"]
+	if cloneID == "" || cloneID == srcID {
+		t.Fatalf("Expected a fresh room ID, got %q", cloneID)
+	}
+
+	mu.RLock()
+	src, c := rooms[srcID], rooms[cloneID]
+	mu.RUnlock()
+	if c == nil {
+		t.Fatal("Expected the clone to be stored")
+	}
+	if !reflect.DeepEqual(c.Sets, src.Sets) || c.TimeAllocated != Duration(90*time.Minute) || c.ScanMode != Whitelist ||
+		!reflect.DeepEqual(c.AllowedApps, []string{"code"}) || c.OfflineGrace != Duration(time.Minute) {
+		t.Errorf("Expected the configuration to be copied, got %+v", c)
+	}
+	if c.FlagPolicy == nil || !reflect.DeepEqual(*c.FlagPolicy, *src.FlagPolicy) || c.FlagPolicy == src.FlagPolicy {
+		t.Errorf("Expected a copy of the flag policy, got %+v", c.FlagPolicy)
+	}
+	if len(c.Students) != 0 || c.ActiveStatus != Waiting || c.SessionName != "Section B" {
+		t.Errorf("Expected an empty Waiting room named Section B, got %d students, %v, %q", len(c.Students), c.ActiveStatus, c.SessionName)
+	}
+	if _, ok := c.adminLabel("clone-key"); !ok {
+		t.Error("Expected the clone to keep the source's owner key")
+	}
+
+	// Editing the clone leaves the source alone
+	mu.Lock()
+	c.Sets["C"] = "https://example.com/c"
+	mu.Unlock()
+	if _, ok := src.Sets["C"]; ok {
+		t.Error("Expected the clone's sets to be independent")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dataDir holds every file the server persists. It is resolved to an
+// absolute path at startup so the working directory does not matter.
+var dataDir = "."
+
+// inMemory keeps all state in memory and never writes to dataDir, for
+// ephemeral deployments that must not leave data behind
+var inMemory bool
+
+// envOr returns the environment variable or def when it is unset
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration returns the environment variable parsed as a duration, or def
+// when it is unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, v, err)
+		return def
+	}
+	return d
+}
+
+// envBool returns the environment variable parsed as a boolean, or def when
+// it is unset or invalid
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, v, err)
+		return def
+	}
+	return b
+}
+
+// envInt returns the environment variable parsed as an integer, or def when
+// it is unset or invalid
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, v, err)
+		return def
+	}
+	return n
+}
+
+// envFloat returns the environment variable parsed as a number, or def when
+// it is unset or invalid
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, v, err)
+		return def
+	}
+	return f
+}
+
+// splitList splits a comma-separated setting, dropping blank entries
+func splitList(list string) []string {
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// setDataDir resolves dir to an absolute path and creates it if needed.
+// In memory-only mode the directory is not created.
+func setDataDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolving data dir %q: %w", dir, err)
+	}
+	if inMemory {
+		dataDir = abs
+		return nil
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return fmt.Errorf("creating data dir %q: %w", abs, err)
+	}
+	dataDir = abs
+	return nil
+}
+
+// dataPath returns the location of a persisted file inside dataDir
+func dataPath(name string) string {
+	return filepath.Join(dataDir, name)
+}
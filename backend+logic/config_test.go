@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetDataDir(t *testing.T) {
+	prev := dataDir
+	t.Cleanup(func() { dataDir = prev })
+
+	base := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(base)
+	defer os.Chdir(wd)
+
+	if err := setDataDir(filepath.Join("nested", "state")); err != nil {
+		t.Fatalf("setDataDir failed: %v", err)
+	}
+	if want := filepath.Join(base, "nested", "state"); dataDir != want {
+		t.Errorf("dataDir = %q, want %q", dataDir, want)
+	}
+	if info, err := os.Stat(dataDir); err != nil || !info.IsDir() {
+		t.Errorf("Data dir was not created: %v", err)
+	}
+	if got := dataPath(dataFile); got != filepath.Join(dataDir, "rooms.json") {
+		t.Errorf("dataPath(dataFile) = %q", got)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ownerLabel identifies the room's original AdminKey in the audit log
+const ownerLabel = "owner"
+
+// maxAuditEntries bounds a room's audit log; the oldest entries are dropped first
+const maxAuditEntries = 1000
+
+// CoProctor is an additional admin key for a room, revocable on its own.
+// The key is returned once when it is added and only stored hashed; Key is
+// read from rooms saved before that and hashed on load.
+type CoProctor struct {
+	Label   string    `json:"label"`
+	Key     string    `json:"key,omitempty"`
+	KeyHash string    `json:"key_hash,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// AuditEntry records an admin action and which key performed it
+type AuditEntry struct {
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"` // ownerLabel or a co-proctor's label
+	Action string    `json:"action"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// adminLabel reports who holds key: ownerLabel for the room's AdminKey,
+// masterLabel for the master key or the co-proctor's label. ok is false for
+// any other key.
+func (r *Room) adminLabel(key string) (label string, ok bool) {
+	if r.ownerKey(key) {
+		return ownerLabel, true
+	}
+	if isMasterKey(key) {
+		log.Printf("Master key used on room %s", r.ID)
+		return masterLabel, true
+	}
+	if len(r.CoProctors) == 0 {
+		return "", false
+	}
+	hash := []byte(hashAdminKey(key))
+	for _, c := range r.CoProctors {
+		if c.KeyHash != "" && subtle.ConstantTimeCompare(hash, []byte(c.KeyHash)) == 1 {
+			return c.Label, true
+		}
+	}
+	return "", false
+}
+
+// ownerKey reports whether key is the owner's. After a rotation only the
+// hash is kept and compared in constant time.
+func (r *Room) ownerKey(key string) bool {
+	if r.AdminKeyHash != "" {
+		return subtle.ConstantTimeCompare([]byte(hashAdminKey(key)), []byte(r.AdminKeyHash)) == 1
+	}
+	return key == r.AdminKey
+}
+
+// audit appends to the room's audit log. Caller holds mu.
+func (r *Room) audit(actor, action, detail string) {
+	r.AuditLog = append(r.AuditLog, AuditEntry{At: now(), Actor: actor, Action: action, Detail: detail})
+	if extra := len(r.AuditLog) - maxAuditEntries; extra > 0 {
+		r.AuditLog = append([]AuditEntry(nil), r.AuditLog[extra:]...)
+	}
+}
+
+// AddCoProctorHandler issues a new labelled admin key. Only the owner (or the
+// master key) may add co-proctors.
+func AddCoProctorHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Label    string `json:"label"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" || req.Label == ownerLabel || req.Label == masterLabel {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "A label other than \""+ownerLabel+"\" or \""+masterLabel+"\" is required")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok || (actor != ownerLabel && actor != masterLabel) {
+		writeError(w, errUnauthorized)
+		return
+	}
+	for _, c := range room.CoProctors {
+		if c.Label == req.Label {
+			writeJSONError(w, http.StatusConflict, codeLabelInUse, "A co-proctor with this label already exists")
+			return
+		}
+	}
+
+	key, err := randomBytes(2 * sessionIDBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	coKey := fmt.Sprintf("%x", key)
+	room.CoProctors = append(room.CoProctors, CoProctor{Label: req.Label, KeyHash: hashAdminKey(coKey), AddedAt: now()})
+	room.audit(actor, "add_co_proctor", req.Label)
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Co-proctor added successfully",
+		"label":   req.Label,
+		"key":     coKey,
+	})
+}
+
+// RevokeCoProctorHandler removes a co-proctor's key. Only the owner (or the
+// master key) may revoke.
+func RevokeCoProctorHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Label    string `json:"label"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok || (actor != ownerLabel && actor != masterLabel) {
+		mu.Unlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	idx := -1
+	for i, c := range room.CoProctors {
+		if c.Label == req.Label {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		mu.Unlock()
+		writeJSONError(w, http.StatusNotFound, codeCoProctorNotFound, "Co-proctor not found")
+		return
+	}
+
+	room.CoProctors = append(room.CoProctors[:idx], room.CoProctors[idx+1:]...)
+	room.audit(actor, "revoke_co_proctor", req.Label)
+	requestSave(req.RoomID)
+	mu.Unlock()
+
+	if wsHub != nil {
+		wsHub.revokeAdmin(req.RoomID, req.Label)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Co-proctor revoked successfully",
+	})
+}
+
+// RotateKeyHandler replaces the owner's admin key with a new random one,
+// returned once in the response and only stored hashed. The old key stops
+// working at once, and websockets that said hello with it must say hello
+// again. Admin websocket commands carry the key on every message, so they
+// stop working too. Only the owner (or the master key) may rotate.
+func RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok || (actor != ownerLabel && actor != masterLabel) {
+		mu.Unlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	b, err := randomBytes(2 * sessionIDBytes)
+	if err != nil {
+		mu.Unlock()
+		writeError(w, err)
+		return
+	}
+	key := fmt.Sprintf("%x", b)
+	room.AdminKey = ""
+	room.AdminKeyHash = hashAdminKey(key)
+	room.audit(actor, "rotate_admin_key", "")
+	requestSave(req.RoomID)
+	mu.Unlock()
+
+	if wsHub != nil {
+		wsHub.revokeAdmin(req.RoomID, ownerLabel)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Admin key rotated successfully",
+		"admin_key": key,
+	})
+}
+
+// AuditLogHandler returns the room's audit log to any of its admins
+func AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mu.RLock()
+	room, exists := rooms[q.Get("room_id")]
+	if !exists {
+		mu.RUnlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if _, ok := room.adminLabel(q.Get("admin_key")); !ok {
+		mu.RUnlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+	entries := append([]AuditEntry{}, room.AuditLog...)
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
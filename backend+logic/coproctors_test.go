@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func postCoProctor(t *testing.T, handler http.HandlerFunc, roomID, adminKey, label string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "label": "` + label + `"}`)
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/admin/co-proctor", bytes.NewBuffer(body)))
+	return rr
+}
+
+func TestCoProctorKeys(t *testing.T) {
+	roomID := createTestRoom(t, "owner-key")
+	joinTestRoom(t, roomID, "quinn", "REG800")
+
+	rr := postCoProctor(t, AddCoProctorHandler, roomID, "owner-key", "hall-b")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var added map[string]string
+	json.NewDecoder(rr.Body).Decode(&added)
+	coKey := added["key"]
+	if coKey == "" {
+		t.Fatal("Expected a key for the co-proctor")
+	}
+
+	// Co-proctors can act but cannot manage other co-proctors
+	if rr := postCoProctor(t, AddCoProctorHandler, roomID, coKey, "hall-c"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 when a co-proctor adds a key, got %d", rr.Code)
+	}
+	if err := updateUserStatus(roomID, coKey, "quinn", Flagged); err != nil {
+		t.Fatalf("Co-proctor could not flag a student: %v", err)
+	}
+	if err := updateUserStatus(roomID, "owner-key", "quinn", Online); err != nil {
+		t.Fatalf("Owner could not update a student: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	AuditLogHandler(rr, httptest.NewRequest("GET", "/admin/audit-log?room_id="+roomID+"&admin_key="+coKey, nil))
+	var entries []AuditEntry
+	json.NewDecoder(rr.Body).Decode(&entries)
+	var actors []string
+	for _, e := range entries {
+		if e.Action == "update_status" {
+			actors = append(actors, e.Actor)
+		}
+	}
+	if len(actors) != 2 || actors[0] != "hall-b" || actors[1] != ownerLabel {
+		t.Fatalf("Expected update_status by hall-b then owner, got %v", actors)
+	}
+
+	// A revoked key stops working
+	if rr := postCoProctor(t, RevokeCoProctorHandler, roomID, "owner-key", "hall-b"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 on revoke, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := updateUserStatus(roomID, coKey, "quinn", Flagged); err != errUnauthorized {
+		t.Fatalf("Expected a revoked key to be rejected, got %v", err)
+	}
+
+	// Keys never reach the public view
+	rr = httptest.NewRecorder()
+	GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil))
+	if bytes.Contains(rr.Body.Bytes(), []byte("co_proctors")) || bytes.Contains(rr.Body.Bytes(), []byte("audit_log")) {
+		t.Fatalf("Public view exposed admin data: %s", rr.Body.String())
+	}
+}
+
+func TestMasterKey(t *testing.T) {
+	roomID := createTestRoom(t, "owner-key")
+	joinTestRoom(t, roomID, "rowan", "REG810")
+
+	// Unset: the master key is just another wrong key, even when empty
+	prev := masterKey
+	masterKey = ""
+	t.Cleanup(func() { masterKey = prev })
+	if err := updateUserStatus(roomID, "", "rowan", Flagged); err != errUnauthorized {
+		t.Fatalf("Expected errUnauthorized with master access disabled, got %v", err)
+	}
+
+	masterKey = "provisioning-secret"
+	if err := updateUserStatus(roomID, "provisioning-secre", "rowan", Flagged); err != errUnauthorized {
+		t.Fatalf("Expected errUnauthorized for a near-miss key, got %v", err)
+	}
+	if err := updateUserStatus(roomID, "provisioning-secret", "rowan", Flagged); err != nil {
+		t.Fatalf("Master key could not update a student: %v", err)
+	}
+
+	// The master key may manage co-proctors like the owner
+	rr := postCoProctor(t, AddCoProctorHandler, roomID, "provisioning-secret", "hall-m")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 adding a co-proctor with the master key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postCoProctor(t, AddCoProctorHandler, roomID, "owner-key", masterLabel); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for the reserved %q label, got %d", masterLabel, rr.Code)
+	}
+
+	mu.RLock()
+	var actors []string
+	for _, e := range rooms[roomID].AuditLog {
+		actors = append(actors, e.Actor)
+	}
+	mu.RUnlock()
+	if len(actors) != 2 || actors[0] != masterLabel || actors[1] != masterLabel {
+		t.Fatalf("Expected master actions in the audit log, got %v", actors)
+	}
+
+	// Disabling it again locks the master key out
+	masterKey = ""
+	if err := updateUserStatus(roomID, "provisioning-secret", "rowan", Online); err != errUnauthorized {
+		t.Fatalf("Expected errUnauthorized once master access is disabled, got %v", err)
+	}
+}
+
+func TestRotateAdminKey(t *testing.T) {
+	useStore(t, "json")
+	roomID := createTestRoom(t, "leaked-key")
+	joinTestRoom(t, roomID, "sage", "REG820")
+	coKey := func() string {
+		var added map[string]string
+		json.NewDecoder(postCoProctor(t, AddCoProctorHandler, roomID, "leaked-key", "hall-r").Body).Decode(&added)
+		return added["key"]
+	}()
+
+	rotate := func(key string) *httptest.ResponseRecorder {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + key + `"}`)
+		rr := httptest.NewRecorder()
+		RotateKeyHandler(rr, httptest.NewRequest("POST", "/admin/rotate-key", bytes.NewBuffer(body)))
+		return rr
+	}
+
+	if rr := rotate(coKey); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 when a co-proctor rotates, got %d", rr.Code)
+	}
+	rr := rotate("leaked-key")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated map[string]string
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	newKey := rotated["admin_key"]
+	if newKey == "" {
+		t.Fatal("Expected the new key in the response")
+	}
+
+	// The old key is rejected everywhere, including websocket admin commands,
+	// which authenticate through updateUserStatus
+	if err := updateUserStatus(roomID, "leaked-key", "sage", Flagged); err != errUnauthorized {
+		t.Fatalf("Expected the old key to be rejected, got %v", err)
+	}
+	if rr := rotate("leaked-key"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the old key to be unable to rotate again, got %d", rr.Code)
+	}
+	if err := updateUserStatus(roomID, "", "sage", Flagged); err != errUnauthorized {
+		t.Fatalf("Expected an empty key to be rejected after rotation, got %v", err)
+	}
+	if err := updateUserStatus(roomID, newKey, "sage", Flagged); err != nil {
+		t.Fatalf("New key could not update a student: %v", err)
+	}
+	if err := updateUserStatus(roomID, coKey, "sage", Online); err != nil {
+		t.Fatalf("Co-proctor key stopped working after rotation: %v", err)
+	}
+
+	mu.RLock()
+	log := rooms[roomID].AuditLog
+	view := rooms[roomID].publicView()
+	mu.RUnlock()
+	if log[1].Action != "rotate_admin_key" || log[1].Actor != ownerLabel {
+		t.Errorf("Expected the rotation in the audit log, got %+v", log[1])
+	}
+	if view.AdminKeyHash != "" {
+		t.Error("Expected the public view to hide the key hash")
+	}
+
+	// Only the hash reaches disk
+	flush()
+	data, err := os.ReadFile(roomFile(roomID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), newKey) || strings.Contains(string(data), "leaked-key") || strings.Contains(string(data), coKey) {
+		t.Errorf("Saved room contains a plaintext admin key: %s", data)
+	}
+}
+
+func TestLegacyCoProctorKeyHashedOnLoad(t *testing.T) {
+	room := &Room{ID: "LEGACY", CoProctors: []CoProctor{{Label: "hall-l", Key: "plain-key"}}}
+	prepareLoadedRoom(room)
+	if c := room.CoProctors[0]; c.Key != "" || c.KeyHash != hashAdminKey("plain-key") {
+		t.Fatalf("Expected the key to be hashed on load, got %+v", c)
+	}
+	if label, ok := room.adminLabel("plain-key"); !ok || label != "hall-l" {
+		t.Fatalf("Expected the legacy key to keep working, got %q %v", label, ok)
+	}
+	if _, ok := room.adminLabel(hashAdminKey("plain-key")); ok {
+		t.Fatal("Expected the hash itself to be rejected as a key")
+	}
+}
+
+func TestRevokedKeysDropAdminHellos(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "hello-owner")
+	var added map[string]string
+	json.NewDecoder(postCoProctor(t, AddCoProctorHandler, roomID, "hello-owner", "hall-h").Body).Decode(&added)
+
+	admins := func() int { return wsHub.presence(roomID).Admins }
+	for _, key := range []string{"hello-owner", added["key"]} {
+		conn := dial()
+		conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "admin_key": key})
+		readReply(t, conn, "ACK", "hello")
+	}
+	if n := admins(); n != 2 {
+		t.Fatalf("Expected 2 admins present, got %d", n)
+	}
+
+	if rr := postCoProctor(t, RevokeCoProctorHandler, roomID, "hello-owner", "hall-h"); rr.Code != http.StatusOK {
+		t.Fatalf("Revoke returned %d", rr.Code)
+	}
+	if n := admins(); n != 1 {
+		t.Fatalf("Expected the revoked co-proctor's hello dropped, got %d admins", n)
+	}
+
+	rr := httptest.NewRecorder()
+	RotateKeyHandler(rr, httptest.NewRequest("POST", "/admin/rotate-key", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "hello-owner"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Rotate returned %d", rr.Code)
+	}
+	if n := admins(); n != 0 {
+		t.Fatalf("Expected the rotated key's hello dropped, got %d admins", n)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxPendingMessages bounds the direct messages kept for one student with no
+// open socket; the oldest are dropped first
+const maxPendingMessages = 20
+
+// DirectMessage is a proctor's private message to one student, and the
+// DIRECT_MESSAGE payload
+type DirectMessage struct {
+	RoomID        string    `json:"room_id"`
+	UserSessionID string    `json:"user_session_id"`
+	Message       string    `json:"message"`
+	At            time.Time `json:"at"`
+	By            string    `json:"by"` // Admin label that sent it
+}
+
+// queueMessage keeps a direct message until the student says hello. Caller holds mu.
+func (r *Room) queueMessage(dm DirectMessage) {
+	r.PendingMessages = append(r.PendingMessages, dm)
+	n := 0
+	for _, p := range r.PendingMessages {
+		if p.UserSessionID == dm.UserSessionID {
+			n++
+		}
+	}
+	if n <= maxPendingMessages {
+		return
+	}
+	for i, p := range r.PendingMessages {
+		if p.UserSessionID == dm.UserSessionID {
+			r.PendingMessages = append(r.PendingMessages[:i], r.PendingMessages[i+1:]...)
+			return
+		}
+	}
+}
+
+// takePendingMessages removes and returns the direct messages waiting for a session
+func takePendingMessages(roomID, sessionID string) []DirectMessage {
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[roomID]
+	if !exists {
+		return nil
+	}
+	var taken []DirectMessage
+	kept := room.PendingMessages[:0]
+	for _, dm := range room.PendingMessages {
+		if dm.UserSessionID == sessionID {
+			taken = append(taken, dm)
+		} else {
+			kept = append(kept, dm)
+		}
+	}
+	if len(taken) > 0 {
+		room.PendingMessages = kept
+		requestSave(roomID)
+	}
+	return taken
+}
+
+// DirectMessageHandler sends a private message to every socket of one
+// student session. A student with no open socket gets it when they next say
+// hello over the websocket.
+func DirectMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string `json:"room_id"`
+		AdminKey      string `json:"admin_key"`
+		UserSessionID string `json:"user_session_id"`
+		Message       string `json:"message"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.UserSessionID == "" || req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "user_session_id and message are required")
+		return
+	}
+	if len([]rune(req.Message)) > maxAnnouncementLength {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("message must be at most %d characters", maxAnnouncementLength))
+		return
+	}
+
+	// Held while delivering so a hello cannot slip between the attempt and the queueing
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+	var student *UserSession
+	for i := range room.Students {
+		if room.Students[i].ID == req.UserSessionID {
+			student = &room.Students[i]
+			break
+		}
+	}
+	if student == nil {
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	dm := DirectMessage{RoomID: room.ID, UserSessionID: student.ID, Message: req.Message, At: now(), By: actor}
+	sockets := 0
+	if wsHub != nil {
+		sockets = wsHub.sendToSession(student.ID, Message{Type: "DIRECT_MESSAGE", Payload: dm, Target: room.ID})
+	}
+	status := "Direct message sent"
+	if sockets == 0 {
+		room.queueMessage(dm)
+		status = "Student is not connected; the message will be delivered when they reconnect"
+	}
+	room.audit(actor, "direct_message", student.UserID+": "+req.Message)
+	requestSave(room.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   status,
+		"delivered": sockets > 0,
+		"sockets":   sockets,
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendDM posts a direct message to the session
+func sendDM(t *testing.T, roomID, adminKey, sessionID, message string) map[string]interface{} {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "user_session_id": "` + sessionID + `", "message": "` + message + `"}`)
+	rr := httptest.NewRecorder()
+	DirectMessageHandler(rr, httptest.NewRequest("POST", "/admin/dm", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Direct message returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp
+}
+
+// readDM reads the next message and checks it is a DIRECT_MESSAGE
+func readDM(t *testing.T, conn *websocket.Conn) DirectMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Type    string        `json:"type"`
+		Payload DirectMessage `json:"payload"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Read failed waiting for DIRECT_MESSAGE: %v", err)
+	}
+	if msg.Type != "DIRECT_MESSAGE" {
+		t.Fatalf("Expected DIRECT_MESSAGE, got %s %+v", msg.Type, msg.Payload)
+	}
+	return msg.Payload
+}
+
+func TestDirectMessageReachesOnlyItsStudent(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "dm-key")
+	aliceSession, aliceToken := joinTestRoom(t, roomID, "alice", "REG1300")
+	bobSession, bobToken := joinTestRoom(t, roomID, "bob", "REG1301")
+
+	alice, bob := dial(), dial()
+	alice.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": aliceSession, "session_token": aliceToken})
+	readReply(t, alice, "ACK", "hello")
+	bob.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": bobSession, "session_token": bobToken})
+	readReply(t, bob, "ACK", "hello")
+
+	if resp := sendDM(t, roomID, "dm-key", aliceSession, "eyes on your screen"); resp["delivered"] != true {
+		t.Fatalf("Expected delivery to an open socket, got %v", resp)
+	}
+	sendDM(t, roomID, "dm-key", bobSession, "please sit up")
+
+	if got := readDM(t, alice); got.Message != "eyes on your screen" || got.UserSessionID != aliceSession || got.By != ownerLabel {
+		t.Fatalf("Unexpected message for alice %+v", got)
+	}
+	// Bob's first message is his own, not alice's
+	if got := readDM(t, bob); got.Message != "please sit up" {
+		t.Fatalf("Expected bob's own message, got %+v", got)
+	}
+}
+
+func TestDirectMessageWaitsForHello(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "dm-later")
+	sessionID, token := joinTestRoom(t, roomID, "away", "REG1310")
+
+	if resp := sendDM(t, roomID, "dm-later", sessionID, "call the invigilator"); resp["delivered"] != false {
+		t.Fatalf("Expected no delivery without a socket, got %v", resp)
+	}
+	mu.RLock()
+	pending := len(rooms[roomID].PendingMessages)
+	hidden := rooms[roomID].publicView().PendingMessages == nil
+	mu.RUnlock()
+	if pending != 1 || !hidden {
+		t.Fatalf("Expected one queued message hidden from the public view, got %d (hidden %v)", pending, hidden)
+	}
+
+	// A forged hello is refused and gets nothing
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": "forged"})
+	readReply(t, conn, "NACK", "hello")
+
+	conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, conn, "ACK", "hello")
+	if got := readDM(t, conn); got.Message != "call the invigilator" {
+		t.Fatalf("Expected the queued message after hello, got %+v", got)
+	}
+	mu.RLock()
+	pending = len(rooms[roomID].PendingMessages)
+	mu.RUnlock()
+	if pending != 0 {
+		t.Fatalf("Expected the queue to be emptied, got %d", pending)
+	}
+}
+
+func TestDirectMessageUnknownSession(t *testing.T) {
+	roomID := createTestRoom(t, "dm-missing")
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "dm-missing", "user_session_id": "nobody", "message": "hi"}`)
+	rr := httptest.NewRecorder()
+	DirectMessageHandler(rr, httptest.NewRequest("POST", "/admin/dm", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown session, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// Duration is a time.Duration that reads either a Go duration string such as
+// "90m" or "1h30m", or integer nanoseconds for older clients, and writes the
+// readable string form.
+type Duration time.Duration
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*d)}
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var nanos int64
+	if err := json.Unmarshal(data, &nanos); err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*d)}
+	}
+	*d = Duration(nanos)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want time.Duration
+	}{
+		{`"90m"`, 90 * time.Minute},
+		{`"1h30m"`, 90 * time.Minute},
+		{`3600000000000`, time.Hour},
+		{`0`, 0},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.raw), &d); err != nil {
+			t.Errorf("Unmarshal(%s) failed: %v", tt.raw, err)
+			continue
+		}
+		if d.Std() != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.raw, d.Std(), tt.want)
+		}
+	}
+
+	for _, raw := range []string{`"ninety minutes"`, `true`, `1.5`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(raw), &d); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", raw)
+		}
+	}
+
+	data, _ := json.Marshal(Room{TimeAllocated: Duration(90 * time.Minute)})
+	var room Room
+	json.Unmarshal(data, &room)
+	if room.TimeAllocated.Std() != 90*time.Minute {
+		t.Errorf("Round trip gave %v from %s", room.TimeAllocated.Std(), data)
+	}
+}
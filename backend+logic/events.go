@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Default blur threshold: this many blur events within the window flags the student
+const (
+	defaultBlurThreshold = 3
+	defaultBlurWindow    = 5 * time.Minute
+)
+
+// focusEventTypes are the browser signals a client may report
+var focusEventTypes = map[string]bool{
+	"blur":            true,
+	"focus":           true,
+	"fullscreen-exit": true,
+}
+
+// FocusEvent is a tab switch / window focus signal reported by the client
+type FocusEvent struct {
+	Type       string    `json:"type"`
+	ClientTime time.Time `json:"client_time"` // As reported; informational only
+	At         time.Time `json:"at"`          // Server receipt time, used for thresholds
+}
+
+// blurLimits returns the room's blur threshold and window, falling back to defaults
+func (r *Room) blurLimits() (int, time.Duration) {
+	threshold, window := r.BlurThreshold, r.BlurWindow.Std()
+	if threshold <= 0 {
+		threshold = defaultBlurThreshold
+	}
+	if window <= 0 {
+		window = defaultBlurWindow
+	}
+	return threshold, window
+}
+
+// recentBlurs counts the session's blur events inside the window ending at t
+func recentBlurs(events []FocusEvent, window time.Duration, t time.Time) int {
+	count := 0
+	for _, e := range events {
+		if e.Type == "blur" && t.Sub(e.At) <= window {
+			count++
+		}
+	}
+	return count
+}
+
+// FocusEventHandler records a focus/blur event for a student and applies the
+// room's tab switch trigger
+func FocusEventHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string    `json:"room_id"`
+		UserSessionID string    `json:"user_session_id"`
+		SessionToken  string    `json:"session_token"`
+		Type          string    `json:"type"`
+		Timestamp     time.Time `json:"timestamp"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !focusEventTypes[req.Type] {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "type must be blur, focus or fullscreen-exit")
+		return
+	}
+	if err := verifySessionToken(req.RoomID, req.UserSessionID, req.SessionToken); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+
+	idx := -1
+	for i, s := range room.Students {
+		if s.ID == req.UserSessionID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	student := &room.Students[idx]
+	at := now()
+	student.FocusEvents = append(student.FocusEvents, FocusEvent{Type: req.Type, ClientTime: req.Timestamp, At: at})
+
+	flagged := req.Type == "blur" && room.checkTabSwitches(idx, at)
+	if !flagged {
+		// autoFlag already broadcast the student
+		broadcastStudent(req.RoomID, room.Students[idx])
+	}
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Event recorded",
+		"flagged": flagged,
+	})
+}
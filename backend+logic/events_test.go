@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func postFocusEvent(t *testing.T, roomID, sessionID, token, eventType string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token +
+		`", "type": "` + eventType + `", "timestamp": "2026-01-01T00:00:00Z"}`)
+	req, _ := http.NewRequest("POST", "/events/focus", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(FocusEventHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestFocusEventsAutoFlag(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "focus-key")
+	sessionID, token := joinTestRoom(t, roomID, "focus-student", "REG700")
+
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "focus-key", "blur_threshold": 3, "blur_window": "1m"}`)
+	req, _ := http.NewRequest("POST", "/update-room", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(UpdateRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	// Two blurs, then a third outside the window: not enough
+	postFocusEvent(t, roomID, sessionID, token, "blur")
+	postFocusEvent(t, roomID, sessionID, token, "focus")
+	postFocusEvent(t, roomID, sessionID, token, "blur")
+	advance(2 * time.Minute)
+	postFocusEvent(t, roomID, sessionID, token, "blur")
+	postFocusEvent(t, roomID, sessionID, token, "fullscreen-exit")
+	if status := studentStatus(roomID, "focus-student"); status != Online {
+		t.Fatalf("Student flagged too early: %v", status)
+	}
+
+	advance(10 * time.Second)
+	postFocusEvent(t, roomID, sessionID, token, "blur")
+	advance(10 * time.Second)
+	rr = postFocusEvent(t, roomID, sessionID, token, "blur")
+	if rr.Code != http.StatusOK || !bytes.Contains(rr.Body.Bytes(), []byte(`"flagged":true`)) {
+		t.Errorf("Third blur in window returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if status := studentStatus(roomID, "focus-student"); status != Flagged {
+		t.Errorf("Student status %v, want Flagged", status)
+	}
+
+	mu.RLock()
+	events := len(rooms[roomID].Students[0].FocusEvents)
+	mu.RUnlock()
+	if events != 7 {
+		t.Errorf("Recorded %d events, want 7", events)
+	}
+
+	if rr := postFocusEvent(t, roomID, sessionID, token, "minimize"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Unknown event type returned %v", rr.Code)
+	}
+	if rr := postFocusEvent(t, roomID, sessionID, "bad", "blur"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Bad token returned %v", rr.Code)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// evidenceDir holds uploaded evidence as evidence/<room>/<session>/<file>, inside dataDir
+const evidenceDir = "evidence"
+
+// maxEvidenceBytes caps a single evidence upload
+const maxEvidenceBytes = 5 << 20
+
+// evidenceTypes maps accepted image content types to file extensions
+var evidenceTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+// memEvidence holds evidence by its path under dataDir in memory-only mode
+var (
+	memEvidence   = make(map[string][]byte)
+	memEvidenceMu sync.RWMutex
+)
+
+// storeEvidence saves an evidence file
+func storeEvidence(roomID, sessionID, name string, data []byte) error {
+	path := filepath.Join(evidenceDir, roomID, sessionID, name)
+	if inMemory {
+		memEvidenceMu.Lock()
+		memEvidence[path] = data
+		memEvidenceMu.Unlock()
+		return nil
+	}
+	if err := os.MkdirAll(dataPath(filepath.Dir(path)), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dataPath(path), data, 0o644)
+}
+
+// readEvidence returns a stored evidence file
+func readEvidence(roomID, sessionID, name string) ([]byte, error) {
+	path := filepath.Join(evidenceDir, roomID, sessionID, name)
+	if inMemory {
+		memEvidenceMu.RLock()
+		data, ok := memEvidence[path]
+		memEvidenceMu.RUnlock()
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+	return os.ReadFile(dataPath(path))
+}
+
+// UploadEvidenceHandler stores an image as evidence against a student session.
+// Either the student's session token or the room's admin key is accepted.
+func UploadEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	// Leave room for the other form fields around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvidenceBytes+64<<10)
+	if err := r.ParseMultipartForm(maxEvidenceBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Evidence must not exceed %d bytes", maxEvidenceBytes))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Expected a multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	roomID := r.FormValue("room_id")
+	sessionID := r.FormValue("user_session_id")
+	adminKey := r.FormValue("admin_key")
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxEvidenceBytes+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read file")
+		return
+	}
+	if len(data) > maxEvidenceBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Evidence must not exceed %d bytes", maxEvidenceBytes))
+		return
+	}
+
+	// Trust the bytes, not the client's Content-Type
+	ext, ok := evidenceTypes[http.DetectContentType(data)]
+	if !ok {
+		writeJSONError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "Evidence must be a PNG, JPEG or WebP image")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[roomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, isAdmin := room.adminLabel(adminKey)
+	isAdmin = isAdmin && adminKey != ""
+	if !isAdmin {
+		if err := verifySessionToken(roomID, sessionID, r.FormValue("session_token")); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	idx := -1
+	for i, s := range room.Students {
+		if s.ID == sessionID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	id, err := generateID()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	name := fmt.Sprintf("%d-%s%s", now().UnixNano(), id, ext)
+	if err := storeEvidence(roomID, sessionID, name, data); err != nil {
+		logf(r.Context(), "Error writing evidence: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to store evidence")
+		return
+	}
+
+	room.Students[idx].Evidence = append(room.Students[idx].Evidence, name)
+	if isAdmin {
+		room.audit(actor, "upload_evidence", room.Students[idx].UserID+": "+name)
+	}
+	broadcastStudent(roomID, room.Students[idx])
+	requestSave(roomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Evidence uploaded successfully",
+		"filename": name,
+	})
+}
+
+// GetEvidenceHandler serves a stored evidence file to the room admin
+func GetEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	roomID, sessionID, name := q.Get("room_id"), q.Get("user_session_id"), q.Get("file")
+
+	mu.RLock()
+	room, exists := rooms[roomID]
+	if !exists {
+		mu.RUnlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if _, ok := room.adminLabel(q.Get("admin_key")); !ok {
+		mu.RUnlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	// Only names recorded on the session are served, which also rules out path traversal
+	recorded := false
+	for _, s := range room.Students {
+		if s.ID != sessionID {
+			continue
+		}
+		for _, e := range s.Evidence {
+			if e == name {
+				recorded = true
+			}
+		}
+	}
+	mu.RUnlock()
+
+	if !recorded {
+		writeJSONError(w, http.StatusNotFound, codeEvidenceNotFound, "Evidence not found")
+		return
+	}
+
+	data, err := readEvidence(roomID, sessionID, name)
+	if err != nil {
+		logf(r.Context(), "Error reading evidence: %v", err)
+		writeJSONError(w, http.StatusNotFound, codeEvidenceNotFound, "Evidence not found")
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pngHeader is enough for content sniffing to report image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func uploadEvidence(t *testing.T, fields map[string]string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("file", "shot.png")
+	fw.Write(data)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/upload-evidence", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(UploadEvidenceHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestEvidenceUpload(t *testing.T) {
+	roomID := createTestRoom(t, "ev-key")
+	sessionID, token := joinTestRoom(t, roomID, "ev-student", "REG600")
+	fields := map[string]string{"room_id": roomID, "user_session_id": sessionID, "session_token": token}
+
+	rr := uploadEvidence(t, fields, pngHeader)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Upload returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	name := resp["filename"]
+
+	mu.RLock()
+	recorded := rooms[roomID].Students[0].Evidence
+	mu.RUnlock()
+	if len(recorded) != 1 || recorded[0] != name {
+		t.Errorf("Evidence not recorded on session: %v", recorded)
+	}
+
+	get := func(key, file string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/evidence?room_id="+roomID+"&user_session_id="+sessionID+"&file="+file+"&admin_key="+key, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetEvidenceHandler).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get("ev-key", name); rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), pngHeader) {
+		t.Errorf("Get returned %v with %d bytes", rr.Code, rr.Body.Len())
+	}
+	if rr := get("wrong", name); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Get with wrong key returned %v", rr.Code)
+	}
+	if rr := get("ev-key", "../../rooms.json"); rr.Code != http.StatusNotFound {
+		t.Errorf("Get of unrecorded file returned %v", rr.Code)
+	}
+
+	if rr := uploadEvidence(t, fields, []byte("#!/bin/sh\necho hi")); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Non-image upload returned %v", rr.Code)
+	}
+	if rr := uploadEvidence(t, fields, append(pngHeader, make([]byte, maxEvidenceBytes)...)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized upload returned %v", rr.Code)
+	}
+	badToken := map[string]string{"room_id": roomID, "user_session_id": sessionID, "session_token": "bad"}
+	if rr := uploadEvidence(t, badToken, pngHeader); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Upload with bad token returned %v", rr.Code)
+	}
+	asAdmin := map[string]string{"room_id": roomID, "user_session_id": sessionID, "admin_key": "ev-key"}
+	if rr := uploadEvidence(t, asAdmin, pngHeader); rr.Code != http.StatusOK {
+		t.Errorf("Upload by admin returned %v", rr.Code)
+	}
+}
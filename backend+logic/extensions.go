@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The server cannot look inside a student's browser, so /scan/extensions
+// trusts whatever the exam client reports. That makes it one-way evidence:
+// a reported forbidden extension flags the student, but a clean report
+// proves nothing, since a tampered client can simply leave entries out.
+// Reports are only accepted with the student's session token so nobody
+// else can get a student flagged.
+
+// forbiddenExtensions are matched against each reported extension's name
+// and ID, with the same pattern rules as forbiddenApps
+var forbiddenExtensions = []string{"chatgpt*", "*copilot*", "quillbot*", "grammarly*", "tampermonkey", "violentmonkey"}
+
+// getForbiddenExtensions returns a copy of the forbidden extension list
+func getForbiddenExtensions() []string {
+	patternListsMu.RLock()
+	defer patternListsMu.RUnlock()
+	return append([]string(nil), forbiddenExtensions...)
+}
+
+// maxReportedExtensions bounds a single report
+const maxReportedExtensions = 500
+
+// ExtensionInfo is one installed extension or plugin as the client reports it
+type ExtensionInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ExtensionScanResult is the /scan/extensions response
+type ExtensionScanResult struct {
+	ForbiddenFound bool            `json:"forbidden_found"`
+	Extensions     []string        `json:"extensions"` // Forbidden patterns that matched
+	Matches        []ExtensionInfo `json:"matches"`    // Every reported extension that matched
+	Flagged        bool            `json:"flagged"`    // Whether the report flagged the student
+}
+
+// matchExtensions returns the pattern names that match at least one
+// extension along with every extension that matched
+func matchExtensions(exts []ExtensionInfo, patterns []appPattern) ([]string, []ExtensionInfo) {
+	hit := func(p appPattern, e ExtensionInfo) bool {
+		return p.match(strings.ToLower(e.Name)) || p.match(strings.ToLower(e.ID))
+	}
+	found := []string{}
+	matches := []ExtensionInfo{}
+	for _, e := range exts {
+		for _, p := range patterns {
+			if hit(p, e) {
+				matches = append(matches, e)
+				break
+			}
+		}
+	}
+	for _, p := range patterns {
+		for _, e := range matches {
+			if hit(p, e) {
+				found = append(found, p.Name)
+				break
+			}
+		}
+	}
+	return found, matches
+}
+
+// checkForbiddenExtensions applies the forbidden app trigger to an
+// extension report. Caller holds mu.
+func (r *Room) checkForbiddenExtensions(idx int, found []string) bool {
+	if !r.flagPolicy().ForbiddenApps || len(found) == 0 {
+		return false
+	}
+	return r.autoFlag(idx, "forbidden_extensions", fmt.Sprintf("forbidden extensions installed: %v", found))
+}
+
+// ScanExtensionsHandler matches a student's reported browser extensions
+// against forbiddenExtensions and flags them like a process scan would
+func ScanExtensionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string          `json:"room_id"`
+		UserSessionID string          `json:"user_session_id"`
+		SessionToken  string          `json:"session_token"`
+		Extensions    []ExtensionInfo `json:"extensions"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Extensions) > maxReportedExtensions {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("At most %d extensions may be reported", maxReportedExtensions))
+		return
+	}
+	if err := verifySessionToken(req.RoomID, req.UserSessionID, req.SessionToken); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	found, matches := matchExtensions(req.Extensions, compileAppPatterns(getForbiddenExtensions()))
+	result := ExtensionScanResult{ForbiddenFound: len(found) > 0, Extensions: found, Matches: matches}
+
+	mu.Lock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	idx := -1
+	for i, s := range room.Students {
+		if s.ID == req.UserSessionID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		mu.Unlock()
+		writeError(w, errUserNotFound)
+		return
+	}
+	result.Flagged = room.checkForbiddenExtensions(idx, found)
+	mu.Unlock()
+
+	if result.Flagged {
+		requestSave(req.RoomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
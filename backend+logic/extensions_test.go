@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func postExtensions(t *testing.T, roomID, sessionID, token, extensions string) (*httptest.ResponseRecorder, ExtensionScanResult) {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `", "extensions": ` + extensions + `}`)
+	rr := httptest.NewRecorder()
+	ScanExtensionsHandler(rr, httptest.NewRequest("POST", "/scan/extensions", bytes.NewBuffer(body)))
+	var result ExtensionScanResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	return rr, result
+}
+
+func TestScanExtensionsClean(t *testing.T) {
+	roomID := createTestRoom(t, "ext-clean")
+	sessionID, token := joinTestRoom(t, roomID, "clean-ext", "REG1400")
+	setFlagPolicy(t, roomID, "ext-clean", `{"forbidden_apps": true}`)
+
+	rr, result := postExtensions(t, roomID, sessionID, token, `[{"id": "uBlock0@raymondhill.net", "name": "uBlock Origin"}]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if result.ForbiddenFound || result.Flagged || len(result.Matches) != 0 {
+		t.Fatalf("Expected a clean report, got %+v", result)
+	}
+	if status := studentStatus(roomID, "clean-ext"); status != Online {
+		t.Fatalf("Expected the student to stay Online, got %v", status)
+	}
+
+	// An empty report is clean too
+	if rr, result := postExtensions(t, roomID, sessionID, token, `[]`); rr.Code != http.StatusOK || result.ForbiddenFound {
+		t.Fatalf("Expected an empty report to be clean, got %d %+v", rr.Code, result)
+	}
+}
+
+func TestScanExtensionsFlags(t *testing.T) {
+	roomID := createTestRoom(t, "ext-flag")
+	sessionID, token := joinTestRoom(t, roomID, "ext-user", "REG1410")
+	report := `[{"id": "abc", "name": "ChatGPT for Google"}, {"id": "grammarly@grammarly.com", "name": "Helper"}, {"id": "x", "name": "Dark Reader"}]`
+
+	// Reported but not flagged while the trigger is off
+	_, result := postExtensions(t, roomID, sessionID, token, report)
+	if !result.ForbiddenFound || result.Flagged {
+		t.Fatalf("Expected matches without a flag, got %+v", result)
+	}
+	if len(result.Extensions) != 2 || result.Extensions[0] != "chatgpt*" || result.Extensions[1] != "grammarly*" {
+		t.Fatalf("Unexpected matched patterns %v", result.Extensions)
+	}
+	if len(result.Matches) != 2 || result.Matches[1].ID != "grammarly@grammarly.com" {
+		t.Fatalf("Unexpected matched extensions %+v", result.Matches)
+	}
+
+	setFlagPolicy(t, roomID, "ext-flag", `{"forbidden_apps": true}`)
+	if _, result := postExtensions(t, roomID, sessionID, token, report); !result.Flagged {
+		t.Fatalf("Expected the student to be flagged, got %+v", result)
+	}
+	if flag, ok := lastFlag(roomID, "ext-user"); !ok || flag.By != autoFlagger {
+		t.Fatalf("Expected an automatic flag record, got %+v", flag)
+	}
+
+	// Only the student can report for themselves
+	if rr, _ := postExtensions(t, roomID, sessionID, "bad", report); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a bad token, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// File name for the saved pattern lists, inside dataDir
+const forbiddenFile = "forbidden.json"
+
+// forbiddenSaveMu orders changes to the lists with their saves, so the file
+// always ends up holding the last change
+var forbiddenSaveMu sync.Mutex
+
+// forbiddenLists is the saved form of the pattern lists scans match against.
+// Once saved it takes precedence over the flags at startup; deleting the
+// file goes back to them.
+type forbiddenLists struct {
+	ForbiddenApps       []string `json:"forbidden_apps"`
+	RemoteAccessApps    []string `json:"remote_access_apps"`
+	ForbiddenExtensions []string `json:"forbidden_extensions"`
+}
+
+// loadForbiddenLists replaces the pattern lists with the saved ones, if any
+func loadForbiddenLists() {
+	if inMemory {
+		return
+	}
+	data, err := os.ReadFile(dataPath(forbiddenFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading forbidden.json:", err)
+		}
+		return
+	}
+	var saved forbiddenLists
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Println("Error decoding forbidden.json:", err)
+		return
+	}
+
+	patternListsMu.Lock()
+	saved.apply()
+	patternListsMu.Unlock()
+	log.Println("Loaded pattern lists from forbidden.json")
+}
+
+// apply replaces each list that is set. Caller holds patternListsMu.
+func (l forbiddenLists) apply() {
+	if l.ForbiddenApps != nil {
+		forbiddenApps = append([]string{}, l.ForbiddenApps...)
+	}
+	if l.RemoteAccessApps != nil {
+		remoteAccessApps = append([]string{}, l.RemoteAccessApps...)
+	}
+	if l.ForbiddenExtensions != nil {
+		forbiddenExtensions = append([]string{}, l.ForbiddenExtensions...)
+	}
+}
+
+// currentForbiddenLists returns a copy of all three lists
+func currentForbiddenLists() forbiddenLists {
+	patternListsMu.RLock()
+	defer patternListsMu.RUnlock()
+	return forbiddenLists{
+		ForbiddenApps:       append([]string{}, forbiddenApps...),
+		RemoteAccessApps:    append([]string{}, remoteAccessApps...),
+		ForbiddenExtensions: append([]string{}, forbiddenExtensions...),
+	}
+}
+
+// setForbiddenLists replaces each list that is set, leaving the others as
+// they are, and saves all three to forbidden.json
+func setForbiddenLists(lists forbiddenLists) error {
+	forbiddenSaveMu.Lock()
+	defer forbiddenSaveMu.Unlock()
+	patternListsMu.Lock()
+	lists.apply()
+	patternListsMu.Unlock()
+	return saveForbiddenLists()
+}
+
+// saveForbiddenLists rewrites forbidden.json through a temp file so a crash
+// never leaves it half written. Caller holds forbiddenSaveMu.
+func saveForbiddenLists() error {
+	if inMemory {
+		return nil
+	}
+	data, err := json.MarshalIndent(currentForbiddenLists(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dataDir, forbiddenFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dataPath(forbiddenFile))
+}
+
+// ForbiddenListsHandler returns the pattern lists. They apply to every
+// room, so only the master key may read them.
+func ForbiddenListsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isMasterKey(r.URL.Query().Get("admin_key")) {
+		writeError(w, errUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentForbiddenLists())
+}
+
+// UpdateForbiddenListsHandler replaces the pattern lists and saves them to
+// forbidden.json. Only the master key may change them; a list left out of
+// the request is kept as it is.
+func UpdateForbiddenListsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AdminKey string `json:"admin_key"`
+		forbiddenLists
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !isMasterKey(req.AdminKey) {
+		writeError(w, errUnauthorized)
+		return
+	}
+	for _, list := range [][]string{req.ForbiddenApps, req.RemoteAccessApps, req.ForbiddenExtensions} {
+		for _, entry := range list {
+			if _, err := compileAppPattern(entry); err != nil || entry == "" {
+				writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid pattern %q", entry))
+				return
+			}
+		}
+	}
+	log.Printf("Master key used to change the pattern lists")
+
+	if err := setForbiddenLists(req.forbiddenLists); err != nil {
+		logf(r.Context(), "Error saving forbidden.json: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "The lists were changed but could not be saved")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentForbiddenLists())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestForbiddenListsSurviveRestart(t *testing.T) {
+	prev := currentForbiddenLists()
+	prevMaster := masterKey
+	masterKey = "lists-master"
+	t.Cleanup(func() {
+		masterKey = prevMaster
+		setForbiddenLists(prev)
+		os.Remove(dataPath(forbiddenFile))
+	})
+
+	update := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, httptest.NewRequest("POST", "/admin/update-forbidden-lists", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := update(`{"admin_key": "room-key", "forbidden_apps": ["discord"]}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without the master key, got %d", rr.Code)
+	}
+	if rr := update(`{"admin_key": "lists-master", "forbidden_apps": ["re:("]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an invalid pattern, got %d", rr.Code)
+	}
+	if _, err := os.Stat(dataPath(forbiddenFile)); !os.IsNotExist(err) {
+		t.Fatalf("Rejected updates wrote forbidden.json: %v", err)
+	}
+
+	rr := update(`{"admin_key": "lists-master", "forbidden_apps": ["discord", "re:^obs"], "remote_access_apps": ["anydesk"], "forbidden_extensions": ["quillbot*"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(dataPath(forbiddenFile)); err != nil {
+		t.Fatalf("Expected forbidden.json to be written: %v", err)
+	}
+	// A list left out is kept
+	if rr := update(`{"admin_key": "lists-master", "forbidden_extensions": ["grammarly*"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A restart starts from the built-in lists, then loads the saved ones
+	patternListsMu.Lock()
+	forbiddenApps, remoteAccessApps, forbiddenExtensions = []string{"firefox"}, prev.RemoteAccessApps, prev.ForbiddenExtensions
+	patternListsMu.Unlock()
+	loadForbiddenLists()
+
+	want := forbiddenLists{
+		ForbiddenApps:       []string{"discord", "re:^obs"},
+		RemoteAccessApps:    []string{"anydesk"},
+		ForbiddenExtensions: []string{"grammarly*"},
+	}
+	if got := currentForbiddenLists(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lists after reload = %+v, want %+v", got, want)
+	}
+	if leftover, _ := filepath.Glob(dataPath(forbiddenFile + ".*.tmp")); len(leftover) > 0 {
+		t.Errorf("Temp files left behind: %v", leftover)
+	}
+
+	rr = httptest.NewRecorder()
+	ForbiddenListsHandler(rr, httptest.NewRequest("GET", "/admin/forbidden-lists?admin_key=lists-master", nil))
+	var listed forbiddenLists
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("Listed %+v, want %+v", listed, want)
+	}
+}
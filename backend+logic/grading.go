@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Rubric is a room's answer key. It is admin-only and never part of the public view.
+type Rubric struct {
+	Questions map[string]RubricItem `json:"questions"` // Keyed by the question ID used in submitted answers
+
+	// CaseInsensitive compares answers ignoring case; surrounding spaces are always ignored.
+	CaseInsensitive bool `json:"case_insensitive,omitempty"`
+
+	// PartialCredit awards a share of the points on multi-answer questions for
+	// each correct choice, minus one share for each wrong one.
+	PartialCredit bool `json:"partial_credit,omitempty"`
+}
+
+// RubricItem is the correct answer to one question and what it is worth
+type RubricItem struct {
+	Answer answerList `json:"answer"` // "B", or ["A", "C"] when several choices are required
+	Points float64    `json:"points"`
+}
+
+// answerList reads either a single string or a list of strings
+type answerList []string
+
+func (a *answerList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = answerList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*a)}
+	}
+	*a = many
+	return nil
+}
+
+// clone returns a deep copy; nil stays nil
+func (r *Rubric) clone() *Rubric {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Questions = make(map[string]RubricItem, len(r.Questions))
+	for id, q := range r.Questions {
+		q.Answer = append(answerList(nil), q.Answer...)
+		c.Questions[id] = q
+	}
+	return &c
+}
+
+// validate rejects rubrics that cannot be scored sensibly
+func (r *Rubric) validate() error {
+	for id, q := range r.Questions {
+		if len(q.Answer) == 0 {
+			return errors.New("rubric question " + id + " has no answer")
+		}
+		if q.Points < 0 {
+			return errors.New("rubric question " + id + " has negative points")
+		}
+	}
+	return nil
+}
+
+// score grades submitted answers, a JSON object of question ID to answer.
+// Answers that are not a string or list of strings score nothing.
+func (r *Rubric) score(answers json.RawMessage) float64 {
+	var submitted map[string]json.RawMessage
+	if err := json.Unmarshal(answers, &submitted); err != nil {
+		return 0
+	}
+
+	total := 0.0
+	for id, q := range r.Questions {
+		var given answerList
+		if raw, ok := submitted[id]; ok && json.Unmarshal(raw, &given) == nil {
+			total += r.scoreQuestion(q, given)
+		}
+	}
+	return total
+}
+
+func (r *Rubric) scoreQuestion(q RubricItem, given answerList) float64 {
+	normalize := func(s string) string {
+		s = strings.TrimSpace(s)
+		if r.CaseInsensitive {
+			s = strings.ToLower(s)
+		}
+		return s
+	}
+
+	correct := make(map[string]bool, len(q.Answer))
+	for _, a := range q.Answer {
+		correct[normalize(a)] = true
+	}
+
+	hits, misses := 0, 0
+	seen := make(map[string]bool, len(given))
+	for _, a := range given {
+		a = normalize(a)
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		if correct[a] {
+			hits++
+		} else {
+			misses++
+		}
+	}
+
+	if hits == len(correct) && misses == 0 {
+		return q.Points
+	}
+	if !r.PartialCredit || hits <= misses {
+		return 0
+	}
+	return q.Points * float64(hits-misses) / float64(len(correct))
+}
+
+// defaultLeaderboardSize is used when a room enables the leaderboard without a size
+const defaultLeaderboardSize = 10
+
+// LeaderboardEntry is one ranked student, identified only by username
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	Username string  `json:"username"`
+	Score    float64 `json:"score"`
+}
+
+// Leaderboard is the LEADERBOARD_UPDATE payload
+type Leaderboard struct {
+	RoomID  string             `json:"room_id"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// leaderboard ranks submitted students by score, best first, including
+// those flagged since. Equal scores
+// share a rank. Caller holds mu.
+func (r *Room) leaderboard() Leaderboard {
+	var ranked []UserSession
+	for _, s := range r.Students {
+		if s.SubmittedAt != nil { // Still a submission once flagged
+			ranked = append(ranked, s)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Username < ranked[j].Username
+	})
+
+	size := r.LeaderboardSize
+	if size <= 0 {
+		size = defaultLeaderboardSize
+	}
+	board := Leaderboard{RoomID: r.ID, Entries: []LeaderboardEntry{}}
+	for i, s := range ranked {
+		if i >= size {
+			break
+		}
+		rank := i + 1
+		if i > 0 && s.Score == ranked[i-1].Score {
+			rank = board.Entries[i-1].Rank
+		}
+		board.Entries = append(board.Entries, LeaderboardEntry{Rank: rank, Username: s.Username, Score: s.Score})
+	}
+	return board
+}
+
+// broadcastLeaderboard sends the room's ranking to its observers if the room
+// opted in. Caller holds mu.
+func broadcastLeaderboard(room *Room) {
+	if room.ShowLeaderboard {
+		broadcastUpdate(room.ID, "LEADERBOARD_UPDATE", room.leaderboard())
+	}
+}
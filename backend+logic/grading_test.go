@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRubricScore(t *testing.T) {
+	rubric := &Rubric{Questions: map[string]RubricItem{
+		"q1": {Answer: answerList{"B"}, Points: 1},
+		"q2": {Answer: answerList{"A", "C"}, Points: 2},
+		"q3": {Answer: answerList{"Paris"}, Points: 3},
+	}}
+
+	tests := []struct {
+		name            string
+		answers         string
+		caseInsensitive bool
+		partial         bool
+		want            float64
+	}{
+		{"all correct", `{"q1": "B", "q2": ["C", "A"], "q3": "Paris"}`, false, false, 6},
+		{"case sensitive", `{"q1": "b", "q2": ["A", "C"], "q3": " paris "}`, false, false, 2},
+		{"case insensitive", `{"q1": "b", "q2": ["a", "c"], "q3": " paris "}`, true, false, 6},
+		{"no partial credit", `{"q2": ["A"]}`, false, false, 0},
+		{"partial credit", `{"q2": ["A"]}`, false, true, 1},
+		{"wrong choice cancels a right one", `{"q2": ["A", "B"]}`, false, true, 0},
+		{"unanswered and bad types", `{"q1": 2, "q4": "x"}`, false, false, 0},
+		{"not an object", `["B"]`, false, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := *rubric
+			r.CaseInsensitive = tt.caseInsensitive
+			r.PartialCredit = tt.partial
+			if got := r.score(json.RawMessage(tt.answers)); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubmitScoresAgainstRubric(t *testing.T) {
+	roomID := createTestRoom(t, "grade-key")
+	early, earlyToken := joinTestRoom(t, roomID, "early", "REG600")
+	late, lateToken := joinTestRoom(t, roomID, "late", "REG601")
+
+	submit := func(sessionID, token, answers string) {
+		t.Helper()
+		body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `", "answers": ` + answers + `}`)
+		rr := httptest.NewRecorder()
+		SubmitHandler(rr, httptest.NewRequest("POST", "/submit", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Submit returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	score := func(sessionID string) float64 {
+		mu.RLock()
+		defer mu.RUnlock()
+		for _, s := range rooms[roomID].Students {
+			if s.ID == sessionID {
+				return s.Score
+			}
+		}
+		return -1
+	}
+
+	// Submitted before the rubric exists, so scored when it is set, even
+	// though flagged since
+	submit(early, earlyToken, `{"q1": "b"}`)
+	if err := updateUserStatus(roomID, "grade-key", "early", Flagged); err != nil {
+		t.Fatal(err)
+	}
+
+	update := []byte(`{"room_id": "` + roomID + `", "admin_key": "grade-key", "rubric": {"questions": {"q1": {"answer": "B", "points": 5}}, "case_insensitive": true}}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(update)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := score(early); got != 5 {
+		t.Fatalf("Expected the earlier submission to be rescored to 5, got %v", got)
+	}
+
+	submit(late, lateToken, `{"q1": "A"}`)
+	if got := score(late); got != 0 {
+		t.Fatalf("Expected a wrong answer to score 0, got %v", got)
+	}
+
+	// The answer key must never reach the public view
+	rr = httptest.NewRecorder()
+	GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil))
+	if bytes.Contains(rr.Body.Bytes(), []byte("rubric")) {
+		t.Fatalf("Public room view exposed the rubric: %s", rr.Body.String())
+	}
+
+	bad := []byte(`{"room_id": "` + roomID + `", "admin_key": "grade-key", "rubric": {"questions": {"q1": {"answer": [], "points": 1}}}}`)
+	rr = httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(bad)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a question without an answer, got %d", rr.Code)
+	}
+}
+
+func TestStudentCannotSetScore(t *testing.T) {
+	roomID := createTestRoom(t, "integrity")
+	sessionID, token := joinTestRoom(t, roomID, "tamperer", "REG610")
+	auth := `"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `"`
+
+	// Every student-facing endpoint rejects server-managed fields outright
+	tampered := `, "score": 1000, "active_status": "Online", "flags": []`
+	endpoints := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"join", JoinRoomHandler, `{"room_id": "` + roomID + `", "user_id": "tamperer", "regno": "REG610"` + tampered + `}`},
+		{"submit", SubmitHandler, `{` + auth + `, "answers": {"q1": "A"}` + tampered + `}`},
+		{"ping", PingHandler, `{` + auth + tampered + `}`},
+		{"focus event", FocusEventHandler, `{` + auth + `, "type": "focus"` + tampered + `}`},
+		{"extension report", ScanExtensionsHandler, `{` + auth + `, "extensions": []` + tampered + `}`},
+	}
+	for _, ep := range endpoints {
+		rr := httptest.NewRecorder()
+		ep.handler(rr, httptest.NewRequest("POST", "/", bytes.NewBufferString(ep.body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 for server-managed fields, got %d: %s", ep.name, rr.Code, rr.Body.String())
+		}
+	}
+
+	stored := func() UserSession {
+		mu.RLock()
+		defer mu.RUnlock()
+		return rooms[roomID].Students[0]
+	}
+	if s := stored(); s.Score != 0 || s.ActiveStatus != Online || len(s.Flags) != 0 {
+		t.Fatalf("Expected the session untouched, got %+v", s)
+	}
+
+	// A score hidden in the answers is just an unknown question
+	update := []byte(`{"room_id": "` + roomID + `", "admin_key": "integrity", "rubric": {"questions": {"q1": {"answer": "B", "points": 2}}}}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(update)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	SubmitHandler(rr, httptest.NewRequest("POST", "/submit", bytes.NewBufferString(`{`+auth+`, "answers": {"q1": "A", "score": 1000}}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Submit returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if s := stored(); s.Score != 0 || s.ActiveStatus != Submitted {
+		t.Fatalf("Expected a server-computed score of 0, got %+v", s)
+	}
+}
+
+func TestLeaderboardRanking(t *testing.T) {
+	at := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	room := &Room{ID: "LB0001", LeaderboardSize: 3, Students: []UserSession{
+		{Username: "dora", ActiveStatus: Submitted, SubmittedAt: &at, Score: 4},
+		{Username: "abe", ActiveStatus: Submitted, SubmittedAt: &at, Score: 9},
+		{Username: "cal", ActiveStatus: Online, Score: 10},                   // Not submitted yet
+		{Username: "bea", ActiveStatus: Flagged, SubmittedAt: &at, Score: 9}, // Flagged after submitting
+		{Username: "eli", ActiveStatus: Submitted, SubmittedAt: &at, Score: 1},
+	}}
+
+	got := room.leaderboard().Entries
+	want := []LeaderboardEntry{{1, "abe", 9}, {1, "bea", 9}, {3, "dora", 4}}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestLeaderboardBroadcastOptIn(t *testing.T) {
+	roomID := createTestRoom(t, "lb-key")
+	sessionID, token := joinTestRoom(t, roomID, "racer", "REG700")
+	mu.Lock()
+	rooms[roomID].Rubric = &Rubric{Questions: map[string]RubricItem{"q1": {Answer: answerList{"B"}, Points: 1}}}
+	mu.Unlock()
+
+	_, dial := startTestHub(t)
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, conn, "ACK", "subscribe_room")
+
+	// Disabled by default: enabling it is the first LEADERBOARD_UPDATE
+	body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `", "answers": {"q1": "B"}}`)
+	SubmitHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/submit", bytes.NewBuffer(body)))
+	update := []byte(`{"room_id": "` + roomID + `", "admin_key": "lb-key", "show_leaderboard": true}`)
+	UpdateRoomHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(update)))
+
+	roomUpdates := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg struct {
+			Type    string      `json:"type"`
+			Payload Leaderboard `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("No LEADERBOARD_UPDATE received: %v", err)
+		}
+		if msg.Type == "ROOM_UPDATE" {
+			roomUpdates++
+		}
+		if msg.Type != "LEADERBOARD_UPDATE" {
+			continue
+		}
+		// The snapshot and the update-room broadcast come first; the submit alone sent none
+		if roomUpdates != 2 {
+			t.Fatalf("Leaderboard broadcast before it was enabled (after %d ROOM_UPDATEs)", roomUpdates)
+		}
+		entries := msg.Payload.Entries
+		if len(entries) != 1 || entries[0].Username != "racer" || entries[0].Score != 1 {
+			t.Fatalf("Unexpected leaderboard %+v", entries)
+		}
+		return
+	}
+}
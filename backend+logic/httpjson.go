@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxBodyBytes caps the size of JSON request bodies
+const maxBodyBytes = 1 << 20
+
+// maxRequestBytes caps every request body, whatever the handler does with
+// it, under the per-handler limits. It must leave room for an evidence
+// upload; 0 disables it.
+var maxRequestBytes = 8 << 20
+
+// Error codes let clients branch on a failure without parsing the message.
+// They are part of the API, so existing codes must never change.
+const (
+	codeInvalidRequest    = "INVALID_REQUEST"
+	codeInvalidJSON       = "INVALID_JSON"
+	codeBodyTooLarge      = "BODY_TOO_LARGE"
+	codeUnsupportedMedia  = "UNSUPPORTED_MEDIA_TYPE"
+	codeMethodNotAllowed  = "METHOD_NOT_ALLOWED"
+	codeUnauthorized      = "UNAUTHORIZED"
+	codeMissingToken      = "MISSING_SESSION_TOKEN"
+	codeInvalidToken      = "INVALID_SESSION_TOKEN"
+	codeBanned            = "BANNED"
+	codeRoomNotFound      = "ROOM_NOT_FOUND"
+	codeUserNotFound      = "USER_NOT_FOUND"
+	codeTemplateNotFound  = "TEMPLATE_NOT_FOUND"
+	codeBackupNotFound    = "BACKUP_NOT_FOUND"
+	codeEvidenceNotFound  = "EVIDENCE_NOT_FOUND"
+	codeCoProctorNotFound = "CO_PROCTOR_NOT_FOUND"
+	codeBanNotFound       = "BAN_NOT_FOUND"
+	codeUnknownSet        = "UNKNOWN_SET"
+	codeInvalidRoomState  = "INVALID_ROOM_STATE"
+	codeAlreadySubmitted  = "ALREADY_SUBMITTED"
+	codeRegnoInUse        = "REGNO_IN_USE"
+	codeUserIDInUse       = "USER_ID_IN_USE"
+	codeLabelInUse        = "LABEL_IN_USE"
+	codeNotFlagged        = "NOT_FLAGGED"
+	codeInvalidJoinToken  = "INVALID_JOIN_TOKEN"
+	codeJoinTokenUsed     = "JOIN_TOKEN_USED"
+	codeJoinTokenMismatch = "JOIN_TOKEN_MISMATCH"
+	codeJoinTokenRequired = "JOIN_TOKEN_REQUIRED"
+	codeJoinNotOpen       = "JOIN_NOT_OPEN"
+	codeJoinClosed        = "JOIN_CLOSED"
+	codeMissingIdentity   = "MISSING_IDENTITY"
+	codeDuplicateRoom     = "DUPLICATE_ROOM"
+	codeInternal          = "INTERNAL_ERROR"
+	codeUnavailable       = "UNAVAILABLE"
+)
+
+// apiError is the body of every error response
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RoomID  string `json:"room_id,omitempty"` // The existing room, with DUPLICATE_ROOM
+}
+
+// Empty collections are sent as [] and {}, never null, because clients
+// iterate them without checking. nonNil and nonNilMap are for slices and
+// maps that may never have been allocated, such as those of a room loaded
+// from disk; every new list output should go through one of them or start
+// from an empty literal.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func nonNilMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return map[K]V{}
+	}
+	return m
+}
+
+// writeJSONError responds with {"error": {"code": code, "message": message}}
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: code, Message: message}})
+}
+
+// writeError responds with one of the shared mutation errors, picking its
+// status and code
+func writeError(w http.ResponseWriter, err error) {
+	writeJSONError(w, statusForError(err), codeForError(err), err.Error())
+}
+
+// codeForError maps the shared mutation errors to error codes
+func codeForError(err error) string {
+	switch err {
+	case errRoomNotFound:
+		return codeRoomNotFound
+	case errUserNotFound:
+		return codeUserNotFound
+	case errUnauthorized:
+		return codeUnauthorized
+	case errMissingToken:
+		return codeMissingToken
+	case errInvalidToken:
+		return codeInvalidToken
+	case errBanned:
+		return codeBanned
+	case errUnknownSet:
+		return codeUnknownSet
+	case errInvalidJoinToken:
+		return codeInvalidJoinToken
+	case errJoinTokenUsed:
+		return codeJoinTokenUsed
+	case errJoinTokenMismatch:
+		return codeJoinTokenMismatch
+	case errJoinTokenRequired:
+		return codeJoinTokenRequired
+	case errUserIDInUse:
+		return codeUserIDInUse
+	case errNoEntropy:
+		return codeInternal
+	case errNoRoomID:
+		return codeUnavailable
+	default:
+		return codeInvalidRequest
+	}
+}
+
+// decodeJSON decodes the request body into dst, rejecting oversized bodies,
+// unknown fields and trailing data. On failure it writes a JSON error
+// response and returns false; the caller only needs to return.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("trailing data")
+	}
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+	var kindErr *statusKindError
+	status, code := http.StatusBadRequest, codeInvalidJSON
+	message := "Malformed JSON body"
+	switch {
+	case errors.As(err, &maxErr):
+		status, code = http.StatusRequestEntityTooLarge, codeBodyTooLarge
+		message = fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit)
+	case errors.Is(err, io.EOF):
+		message = "Request body is empty"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		message = "Malformed JSON body"
+	case errors.As(err, &kindErr):
+		message = kindErr.Error()
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		message = fmt.Sprintf("Invalid value for field %q", typeErr.Field)
+	case errors.As(err, &typeErr):
+		// Errors from custom unmarshalers carry no field name
+		message = "Invalid value " + typeErr.Value
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		message = "Unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	writeJSONError(w, status, code, message)
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONErrors(t *testing.T) {
+	oversized := `{"session_name": "` + strings.Repeat("a", maxBodyBytes) + `"}`
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+		code    string
+		message string
+	}{
+		{"malformed", CreateRoomHandler, `{"session_name": `, http.StatusBadRequest, codeInvalidJSON, "Malformed JSON body"},
+		{"empty", StartExamHandler, ``, http.StatusBadRequest, codeInvalidJSON, "Request body is empty"},
+		{"unknown field", JoinRoomHandler, `{"room_id": "X", "is_admin": true}`, http.StatusBadRequest, codeInvalidJSON, `Unknown field "is_admin"`},
+		{"wrong type", CreateRoomHandler, `{"session_name": 5}`, http.StatusBadRequest, codeInvalidJSON, `Invalid value for field "session_name"`},
+		{"unknown enum name", AdminUpdateUserHandler, `{"status": "high"}`, http.StatusBadRequest, codeInvalidJSON, `Invalid value "high"`},
+		{"trailing data", UpdateRoomHandler, `{"room_id": "X"} {}`, http.StatusBadRequest, codeInvalidJSON, "Malformed JSON body"},
+		{"oversized", CreateRoomHandler, oversized, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Request body must not exceed 1048576 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("Got status %v, want %v", rr.Code, tt.status)
+			}
+			if got := decodeAPIError(t, rr); got.Code != tt.code || got.Message != tt.message {
+				t.Errorf("Got error %+v, want %s %q", got, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+// decodeAPIError reads an error response, failing unless it has the
+// {"error": {"code", "message"}} shape
+func decodeAPIError(t *testing.T, rr *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Error Content-Type = %q, want application/json", ct)
+	}
+	var resp map[string]apiError
+	dec := json.NewDecoder(rr.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&resp); err != nil || len(resp) != 1 {
+		t.Fatalf("Error body does not have the expected shape: %v", err)
+	}
+	got, ok := resp["error"]
+	if !ok || got.Code == "" || got.Message == "" {
+		t.Fatalf("Error body is missing its code or message: %+v", resp)
+	}
+	return got
+}
+
+func TestErrorShapeAcrossHandlers(t *testing.T) {
+	roomID := createTestRoom(t, "error-shape")
+	sessionID, _ := joinTestRoom(t, roomID, "erring", "REG1280")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"unknown room", "GET", "/get-room?room_id=NOPE", "", http.StatusNotFound, codeRoomNotFound},
+		{"missing room id", "GET", "/get-room", "", http.StatusBadRequest, codeInvalidRequest},
+		{"wrong admin key", "POST", "/start-exam", `{"room_id": "` + roomID + `", "admin_key": "wrong"}`, http.StatusUnauthorized, codeUnauthorized},
+		{"unknown user", "POST", "/admin/update-status", `{"room_id": "` + roomID + `", "admin_key": "error-shape", "user_id": "ghost", "status": "Flagged"}`, http.StatusNotFound, codeUserNotFound},
+		{"forged session token", "POST", "/ping", `{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "forged"}`, http.StatusUnauthorized, codeInvalidToken},
+		{"missing session token", "POST", "/ping", `{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `"}`, http.StatusUnauthorized, codeMissingToken},
+		{"duplicate regno", "POST", "/join-room", `{"room_id": "` + roomID + `", "user_id": "copy", "username": "copy", "regno": "REG1280"}`, http.StatusConflict, codeRegnoInUse},
+		{"unknown template", "POST", "/create-room", `{"admin_key": "k", "template_id": "missing"}`, http.StatusNotFound, codeTemplateNotFound},
+		{"wrong method", "GET", "/start-exam", "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"bad search", "GET", "/search-rooms", "", http.StatusBadRequest, codeInvalidRequest},
+	}
+
+	router := newRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rr.Code != tt.status {
+				t.Fatalf("Got status %d, want %d: %s", rr.Code, tt.status, rr.Body.String())
+			}
+			if got := decodeAPIError(t, rr); got.Code != tt.code {
+				t.Errorf("Got code %s, want %s", got.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestEmptyCollectionsAreNotNull(t *testing.T) {
+	withEmptyRooms(t)
+	// As loaded from a file written with "students": null and no sets
+	mu.Lock()
+	rooms["BARE01"] = &Room{ID: "BARE01", AdminKey: "bare-key"}
+	mu.Unlock()
+
+	for _, target := range []string{
+		"/get-room?room_id=BARE01",
+		"/admin/export-room?room_id=BARE01&admin_key=bare-key",
+		"/get-all-rooms",
+		"/set-distribution?room_id=BARE01",
+	} {
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s returned %d: %s", target, rr.Code, rr.Body.String())
+		}
+		if body := rr.Body.String(); strings.Contains(body, "null") {
+			t.Errorf("%s sent null for an empty collection: %s", target, body)
+		}
+	}
+
+	if got := nonNil([]string(nil)); got == nil || len(got) != 0 {
+		t.Errorf("nonNil(nil) = %#v", got)
+	}
+	if got := nonNilMap(map[string]int(nil)); got == nil || len(got) != 0 {
+		t.Errorf("nonNilMap(nil) = %#v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// identityFields are the join request fields a room may require. Each maps
+// to the value it names in a join request.
+var identityFields = map[string]func(*JoinRequest) string{
+	"user_id":  func(u *JoinRequest) string { return u.UserID },
+	"username": func(u *JoinRequest) string { return u.Username },
+	"regno":    func(u *JoinRequest) string { return u.RegNo },
+}
+
+// validateRequiredFields rejects unknown or repeated identity fields
+func validateRequiredFields(fields []string) error {
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if _, ok := identityFields[f]; !ok {
+			return fmt.Errorf("Unknown required field %q; use user_id, username or regno", f)
+		}
+		if seen[f] {
+			return fmt.Errorf("Required field %q is listed twice", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
+
+// missingIdentity returns the first of the room's required identity fields
+// the join request leaves blank, or "" when it gives them all. Caller holds mu.
+func (r *Room) missingIdentity(u *JoinRequest) string {
+	for _, f := range r.RequiredFields {
+		if get, ok := identityFields[f]; ok && strings.TrimSpace(get(u)) == "" {
+			return f
+		}
+	}
+	return ""
+}
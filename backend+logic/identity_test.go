@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequiredIdentityFields(t *testing.T) {
+	join := func(roomID, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", `+body+`}`)))
+		return rr
+	}
+
+	tests := []struct {
+		name     string
+		required string // JSON list
+		body     string
+		missing  string // "" when the join succeeds
+	}{
+		{"lenient by default", `null`, `"username": ""`, ""},
+		{"username only, given", `["username"]`, `"username": "alice"`, ""},
+		{"username only, blank", `["username"]`, `"username": "  ", "regno": "REG1"`, "username"},
+		{"regno only, given", `["regno"]`, `"regno": "REG1"`, ""},
+		{"regno only, missing", `["regno"]`, `"username": "alice"`, "regno"},
+		{"both, given", `["username", "regno"]`, `"username": "alice", "regno": "REG1"`, ""},
+		{"both, no regno", `["username", "regno"]`, `"username": "alice"`, "regno"},
+		{"both, no username", `["username", "regno"]`, `"regno": "REG1"`, "username"},
+		{"both, neither", `["username", "regno"]`, `"user_id": "u1"`, "username"},
+		{"user_id, missing", `["user_id"]`, `"username": "alice", "regno": "REG1"`, "user_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomID := createTestRoom(t, "identity-key")
+			rr := httptest.NewRecorder()
+			UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "identity-key", "required_fields": `+tt.required+`}`)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+			}
+
+			rr = join(roomID, tt.body)
+			if tt.missing == "" {
+				if rr.Code != http.StatusOK {
+					t.Fatalf("Expected the join to succeed, got %d: %s", rr.Code, rr.Body.String())
+				}
+				return
+			}
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if e := decodeAPIError(t, rr); e.Code != codeMissingIdentity || !strings.HasPrefix(e.Message, tt.missing+" ") {
+				t.Fatalf("Expected %s naming %s, got %+v", codeMissingIdentity, tt.missing, e)
+			}
+		})
+	}
+}
+
+func TestRequiredFieldsValidation(t *testing.T) {
+	roomID := createTestRoom(t, "identity-check")
+	for _, fields := range []string{`["email"]`, `["regno", "regno"]`} {
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "identity-check", "required_fields": `+fields+`}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d", fields, rr.Code)
+		}
+	}
+
+	// Students already in the room reconnect even if they joined before the rule
+	_, token := joinTestRoom(t, roomID, "early", "")
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "identity-check", "required_fields": ["regno"]}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	rejoinTestRoom(t, roomID, "early", "", token)
+}
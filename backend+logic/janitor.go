@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// waitingRoomTTL is how long a room may sit in Waiting with no students
+	// before it is deleted
+	waitingRoomTTL = 24 * time.Hour
+
+	// completeRoomRetention is how long a Complete or Aborted room stays live
+	// before it is moved to the archive directory
+	completeRoomRetention = 7 * 24 * time.Hour
+
+	// janitorInterval is how often the janitor looks for expired rooms
+	janitorInterval = 10 * time.Minute
+)
+
+// archiveDir holds one JSON file per archived room, inside dataDir
+const archiveDir = "archive"
+
+// runJanitor periodically cleans up expired rooms
+func runJanitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cleanupRooms()
+	}
+}
+
+// cleanupRooms deletes abandoned Waiting rooms and archives old Complete
+// and Aborted rooms, returning how many of each it handled
+func cleanupRooms() (deleted, archived int) {
+	current := now()
+	mu.Lock()
+	var toArchive []*Room
+	var removed []string
+	for id, room := range rooms {
+		switch room.ActiveStatus {
+		case Waiting:
+			if len(room.Students) == 0 && current.Sub(room.CreatedAt) > waitingRoomTTL {
+				forgetRoom(id)
+				wakeWaiters(id)
+				removed = append(removed, id)
+				deleted++
+			}
+		case Complete, Aborted:
+			ended := room.EndTime
+			if ended.IsZero() {
+				ended = room.CreatedAt
+			}
+			if current.Sub(ended) > completeRoomRetention {
+				toArchive = append(toArchive, room)
+			}
+		}
+	}
+
+	for _, room := range toArchive {
+		if err := archiveRoom(room); err != nil {
+			// Keep the room live rather than lose it
+			log.Printf("Error archiving room %s: %v", room.ID, err)
+			continue
+		}
+		forgetRoom(room.ID)
+		wakeWaiters(room.ID)
+		removed = append(removed, room.ID)
+		archived++
+	}
+	if deleted > 0 || archived > 0 {
+		broadcastRoomList()
+	}
+	mu.Unlock()
+
+	if deleted > 0 || archived > 0 {
+		log.Printf("Janitor: deleted %d abandoned rooms, archived %d completed rooms", deleted, archived)
+		requestSave(removed...)
+	}
+	return deleted, archived
+}
+
+// archiveRoom writes the room to archive/<id>.json. In memory-only mode
+// there is nowhere to archive to and the room is simply dropped. Caller must
+// hold mu.
+func archiveRoom(room *Room) error {
+	if inMemory {
+		return nil
+	}
+	dir := dataPath(archiveDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(room, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, room.ID+".json"), data, 0o644)
+}
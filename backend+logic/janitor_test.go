@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanupRooms(t *testing.T) {
+	withEmptyRooms(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	advance := useFakeClock(t, now)
+
+	mu.Lock()
+	rooms["OLDWAIT"] = &Room{ID: "OLDWAIT", ActiveStatus: Waiting, CreatedAt: now.Add(-waitingRoomTTL - time.Minute)}
+	rooms["NEWWAIT"] = &Room{ID: "NEWWAIT", ActiveStatus: Waiting, CreatedAt: now.Add(-time.Hour)}
+	rooms["OLDBUSY"] = &Room{ID: "OLDBUSY", ActiveStatus: Waiting, CreatedAt: now.Add(-waitingRoomTTL - time.Minute),
+		Students: []UserSession{{ID: "s1"}}}
+	rooms["OLDDONE"] = &Room{ID: "OLDDONE", ActiveStatus: Complete, CreatedAt: now.Add(-30 * 24 * time.Hour),
+		EndTime: now.Add(-completeRoomRetention - time.Minute)}
+	rooms["NEWDONE"] = &Room{ID: "NEWDONE", ActiveStatus: Complete, CreatedAt: now.Add(-30 * 24 * time.Hour),
+		EndTime: now.Add(-time.Hour)}
+	rooms["ACTIVE"] = &Room{ID: "ACTIVE", ActiveStatus: Active, CreatedAt: now.Add(-30 * 24 * time.Hour)}
+	mu.Unlock()
+
+	deleted, archived := cleanupRooms()
+	if deleted != 1 || archived != 1 {
+		t.Errorf("cleanupRooms() = %d deleted, %d archived; want 1, 1", deleted, archived)
+	}
+
+	mu.RLock()
+	for _, id := range []string{"NEWWAIT", "OLDBUSY", "NEWDONE", "ACTIVE"} {
+		if _, ok := rooms[id]; !ok {
+			t.Errorf("Room %s should have been kept", id)
+		}
+	}
+	for _, id := range []string{"OLDWAIT", "OLDDONE"} {
+		if _, ok := rooms[id]; ok {
+			t.Errorf("Room %s should have been removed", id)
+		}
+	}
+	mu.RUnlock()
+
+	if _, err := os.Stat(filepath.Join(dataPath(archiveDir), "OLDDONE.json")); err != nil {
+		t.Errorf("Archived room file missing: %v", err)
+	}
+
+	// Later on, the remaining Waiting room expires as well
+	advance(waitingRoomTTL)
+	deleted, _ = cleanupRooms()
+	if deleted != 1 {
+		t.Errorf("Second cleanup deleted %d rooms, want 1", deleted)
+	}
+}
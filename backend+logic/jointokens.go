@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidJoinToken  = errors.New("Invalid join token")
+	errJoinTokenUsed     = errors.New("Join token has already been used")
+	errJoinTokenMismatch = errors.New("Join token belongs to a different student")
+	errJoinTokenRequired = errors.New("This room can only be joined with a personal join link")
+)
+
+// maxJoinTokensPerRequest bounds a single issue request
+const maxJoinTokensPerRequest = 1000
+
+// JoinToken is a single-use personal join link bound to one regno. The
+// student joining with it gets the regno (and username, when set) from the
+// token, and nobody else can join with it afterwards.
+type JoinToken struct {
+	Token    string    `json:"token"`
+	RegNo    string    `json:"regno"`
+	Username string    `json:"username,omitempty"`
+	IssuedAt time.Time `json:"issued_at"`
+	IssuedBy string    `json:"issued_by"` // Admin label that issued the token
+
+	// Set once a student has joined with the token
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	SessionID string     `json:"session_id,omitempty"`
+}
+
+// resolveJoinToken checks token and fills in the identity it is bound to.
+// The student who already used a token may present it again to reconnect;
+// anyone else gets errJoinTokenUsed. Caller holds mu.
+func (r *Room) resolveJoinToken(token string, u *JoinRequest) (*JoinToken, error) {
+	var t *JoinToken
+	for i := range r.JoinTokens {
+		if r.JoinTokens[i].Token == token {
+			t = &r.JoinTokens[i]
+			break
+		}
+	}
+	if t == nil {
+		return nil, errInvalidJoinToken
+	}
+	if u.RegNo != "" && u.RegNo != t.RegNo {
+		return nil, errJoinTokenMismatch
+	}
+	u.RegNo = t.RegNo
+	if u.Username == "" {
+		u.Username = t.Username
+	}
+	if u.UserID == "" {
+		u.UserID = t.RegNo
+	}
+
+	if t.SessionID != "" {
+		for _, s := range r.Students {
+			if s.ID == t.SessionID && s.UserID == u.UserID {
+				return t, nil
+			}
+		}
+		return nil, errJoinTokenUsed
+	}
+	return t, nil
+}
+
+// IssueJoinTokensHandler creates a personal join token for each listed
+// student. Issuing again for a regno replaces its unused token, so a lost
+// link can be resent.
+func IssueJoinTokensHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Students []struct {
+			RegNo    string `json:"regno"`
+			Username string `json:"username"`
+		} `json:"students"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Students) == 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "students is required")
+		return
+	}
+	if len(req.Students) > maxJoinTokensPerRequest {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("At most %d join tokens may be issued at once", maxJoinTokensPerRequest))
+		return
+	}
+	seen := make(map[string]bool, len(req.Students))
+	for i := range req.Students {
+		regNo := strings.TrimSpace(req.Students[i].RegNo)
+		if regNo == "" {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Every student needs a regno")
+			return
+		}
+		if seen[regNo] {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Duplicate regno "+regNo)
+			return
+		}
+		seen[regNo] = true
+		req.Students[i].RegNo = regNo
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	issued := make([]JoinToken, 0, len(req.Students))
+	for _, s := range req.Students {
+		b, err := randomBytes(sessionIDBytes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		issued = append(issued, JoinToken{
+			Token:    fmt.Sprintf("%x", b),
+			RegNo:    s.RegNo,
+			Username: s.Username,
+			IssuedAt: now(),
+			IssuedBy: actor,
+		})
+	}
+
+	// Drop the unused tokens being replaced
+	kept := room.JoinTokens[:0]
+	for _, t := range room.JoinTokens {
+		if t.SessionID != "" || !seen[t.RegNo] {
+			kept = append(kept, t)
+		}
+	}
+	room.JoinTokens = append(kept, issued...)
+	room.audit(actor, "issue_join_tokens", fmt.Sprintf("%d students", len(issued)))
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Join tokens issued successfully",
+		"tokens":  issued,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// issueJoinTokens issues tokens for the regnos and returns them by regno
+func issueJoinTokens(t *testing.T, roomID, adminKey string, regNos ...string) map[string]string {
+	t.Helper()
+	var students []map[string]string
+	for _, regNo := range regNos {
+		students = append(students, map[string]string{"regno": regNo, "username": "Student " + regNo})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"room_id": roomID, "admin_key": adminKey, "students": students})
+	rr := httptest.NewRecorder()
+	IssueJoinTokensHandler(rr, httptest.NewRequest("POST", "/admin/join-tokens", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Issuing join tokens returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Tokens []JoinToken `json:"tokens"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	tokens := make(map[string]string)
+	for _, tok := range resp.Tokens {
+		tokens[tok.RegNo] = tok.Token
+	}
+	return tokens
+}
+
+// joinWithToken posts a join with the token and any extra identity fields
+func joinWithToken(roomID, token, extra string) *httptest.ResponseRecorder {
+	body := `{"room_id": "` + roomID + `", "join_token": "` + token + `"` + extra + `}`
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(body)))
+	return rr
+}
+
+func TestJoinTokenSingleUse(t *testing.T) {
+	roomID := createTestRoom(t, "links")
+	tokens := issueJoinTokens(t, roomID, "links", "REG900", "REG901")
+
+	rr := joinWithToken(roomID, tokens["REG900"], "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Join with a fresh token returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var joined map[string]string
+	json.NewDecoder(rr.Body).Decode(&joined)
+
+	// The identity comes from the token
+	mu.RLock()
+	student := rooms[roomID].Students[0]
+	mu.RUnlock()
+	if student.RegNo != "REG900" || student.UserID != "REG900" || student.Username != "Student REG900" {
+		t.Errorf("Expected the token's identity, got %+v", student)
+	}
+
+	// Someone else cannot reuse it
+	rr = joinWithToken(roomID, tokens["REG900"], `, "user_id": "friend"`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 reusing a token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeAPIError(t, rr); got.Code != codeJoinTokenUsed {
+		t.Errorf("Got code %s, want %s", got.Code, codeJoinTokenUsed)
+	}
+
+	// The student who used it can present it again to reconnect
+	rr = joinWithToken(roomID, tokens["REG900"], "")
+	var again map[string]string
+	json.NewDecoder(rr.Body).Decode(&again)
+	if rr.Code != http.StatusOK || again["user_session_id"] != joined["user_session_id"] {
+		t.Fatalf("Expected the same session on reconnect, got %d: %v", rr.Code, again)
+	}
+
+	if rr := joinWithToken(roomID, "made-up", ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for an unknown token, got %d", rr.Code)
+	}
+}
+
+func TestJoinTokenBoundToRegno(t *testing.T) {
+	roomID := createTestRoom(t, "bound")
+	tokens := issueJoinTokens(t, roomID, "bound", "REG910", "REG911")
+	joinWithToken(roomID, tokens["REG910"], "")
+
+	// A token cannot be used to claim another regno
+	rr := joinWithToken(roomID, tokens["REG911"], `, "regno": "REG999"`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a mismatched regno, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeAPIError(t, rr); got.Code != codeJoinTokenMismatch {
+		t.Errorf("Got code %s, want %s", got.Code, codeJoinTokenMismatch)
+	}
+
+	// Nor to take over another student's session through their user_id
+	if rr := joinWithToken(roomID, tokens["REG911"], `, "user_id": "REG910"`); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 taking over another session, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Reissuing replaces the unused token
+	fresh := issueJoinTokens(t, roomID, "bound", "REG911")
+	if rr := joinWithToken(roomID, tokens["REG911"], ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected the replaced token to be rejected, got %d", rr.Code)
+	}
+	if rr := joinWithToken(roomID, fresh["REG911"], ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the reissued token to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Tokens never reach students watching the room
+	mu.RLock()
+	view := rooms[roomID].publicView()
+	mu.RUnlock()
+	if view.JoinTokens != nil {
+		t.Error("Expected the public view to hide join tokens")
+	}
+}
+
+func TestRequireJoinToken(t *testing.T) {
+	roomID := createTestRoom(t, "links-only")
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "links-only", "require_join_token": true}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "user_id": "walk-in", "regno": "REG920"}`)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 joining with only the room code, got %d", rr.Code)
+	}
+
+	tokens := issueJoinTokens(t, roomID, "links-only", "REG920")
+	if rr := joinWithToken(roomID, tokens["REG920"], ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected a personal link to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// checkJoinWindow reports whether a new student may join at t. Outside the
+// room's join window it returns the error code and a message saying when
+// joining opens or when it closed. Caller holds mu.
+func (r *Room) checkJoinWindow(t time.Time) (code, message string, ok bool) {
+	if r.JoinOpensAt != nil && t.Before(*r.JoinOpensAt) {
+		wait := r.JoinOpensAt.Sub(t).Round(time.Second)
+		return codeJoinNotOpen, fmt.Sprintf("Joining opens at %s, in %v", r.JoinOpensAt.UTC().Format(time.RFC3339), wait), false
+	}
+	if r.JoinClosesAt != nil && !t.Before(*r.JoinClosesAt) {
+		return codeJoinClosed, fmt.Sprintf("Joining closed at %s", r.JoinClosesAt.UTC().Format(time.RFC3339)), false
+	}
+	return "", "", true
+}
+
+// setJoinWindow applies an update to the join window, where a zero time
+// clears that end. It rejects a window that closes before it opens. Caller
+// holds mu.
+func (r *Room) setJoinWindow(opensAt, closesAt *time.Time) error {
+	opens, closes := r.JoinOpensAt, r.JoinClosesAt
+	if opensAt != nil {
+		opens = opensAt
+		if opensAt.IsZero() {
+			opens = nil
+		}
+	}
+	if closesAt != nil {
+		closes = closesAt
+		if closesAt.IsZero() {
+			closes = nil
+		}
+	}
+	if opens != nil && closes != nil && !opens.Before(*closes) {
+		return fmt.Errorf("join_opens_at must be before join_closes_at")
+	}
+	r.JoinOpensAt, r.JoinClosesAt = opens, closes
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinWindow(t *testing.T) {
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	advance := useFakeClock(t, start)
+	roomID := createTestRoom(t, "window-admin")
+
+	update := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "window-admin", `+body+`}`)))
+		return rr
+	}
+	join := func(userID, regNo string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "user_id": "`+userID+`", "regno": "`+regNo+`"}`)))
+		return rr
+	}
+
+	if rr := update(`"join_opens_at": "2026-10-16T10:00:00Z", "join_closes_at": "2026-10-16T09:30:00Z"`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a window that closes before it opens to be rejected, got %d", rr.Code)
+	}
+	if rr := update(`"join_opens_at": "2026-10-16T09:45:00Z", "join_closes_at": "2026-10-16T10:15:00Z"`); rr.Code != http.StatusOK {
+		t.Fatalf("Setting the join window returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Before the window
+	rr := join("early", "REG1375")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected an early join to be rejected, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeJoinNotOpen || !strings.Contains(e.Message, "2026-10-16T09:45:00Z, in 45m0s") {
+		t.Errorf("Expected a hint of when joining opens, got %+v", e)
+	}
+
+	// In the window
+	advance(50 * time.Minute)
+	sessionID, token := joinTestRoom(t, roomID, "early", "REG1375")
+
+	// After the window, only students already in the room get back in
+	advance(30 * time.Minute)
+	rr = join("late", "REG1376")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected a late join to be rejected, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeJoinClosed || !strings.Contains(e.Message, "2026-10-16T10:15:00Z") {
+		t.Errorf("Expected the closing time in the error, got %+v", e)
+	}
+	if again := rejoinTestRoom(t, roomID, "early", "REG1375", token); again != sessionID {
+		t.Errorf("Expected the joined student to reconnect to %s, got %s", sessionID, again)
+	}
+
+	// A zero time clears that end of the window
+	if rr := update(`"join_closes_at": "0001-01-01T00:00:00Z"`); rr.Code != http.StatusOK {
+		t.Fatalf("Clearing join_closes_at returned %d: %s", rr.Code, rr.Body.String())
+	}
+	joinTestRoom(t, roomID, "late", "REG1376")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longPollTimeout is how long /get-room-longpoll waits for a change before
+// answering 304. Kept under the 30s idle timeout common in proxies.
+var longPollTimeout = 25 * time.Second
+
+// roomWaiters holds, per room, a channel closed at the room's next change.
+// Guarded by mu.
+var roomWaiters = make(map[string]chan struct{})
+
+// markChanged bumps the room's version and UpdatedAt and wakes its
+// long-pollers. Caller holds mu.
+func markChanged(roomID string) {
+	room, exists := rooms[roomID]
+	if !exists {
+		return
+	}
+	room.Version++
+	room.UpdatedAt = now()
+	wakeWaiters(roomID)
+}
+
+// wakeWaiters releases the room's long-pollers, including when the room is
+// deleted. Caller holds mu.
+func wakeWaiters(roomID string) {
+	if ch, ok := roomWaiters[roomID]; ok {
+		close(ch)
+		delete(roomWaiters, roomID)
+	}
+}
+
+// waitForChange returns a channel closed at the room's next change. Caller holds mu.
+func waitForChange(roomID string) <-chan struct{} {
+	ch, ok := roomWaiters[roomID]
+	if !ok {
+		ch = make(chan struct{})
+		roomWaiters[roomID] = ch
+	}
+	return ch
+}
+
+// roomETag is the ETag for a room version. It is weak because heartbeat
+// timestamps can differ between responses with the same version.
+func roomETag(version uint64) string {
+	return `W/"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header names etag, using the
+// weak comparison conditional GETs call for
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// LongPollRoomHandler returns the room once its version is newer than ?since=,
+// waiting up to longPollTimeout for a change. On timeout it answers 304 and
+// the client polls again with the same version. Without since it answers at once.
+func LongPollRoomHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	roomID := q.Get("room_id")
+	if roomID == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
+		return
+	}
+	var since uint64
+	if s := q.Get("since"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "since must be a room version")
+			return
+		}
+		since = v
+	}
+	waitForNewer := q.Has("since")
+
+	timer := time.NewTimer(longPollTimeout)
+	defer timer.Stop()
+	for {
+		// A write lock, since registering a waiter may add to roomWaiters
+		mu.Lock()
+		room, exists := rooms[roomID]
+		if !exists {
+			mu.Unlock()
+			writeError(w, errRoomNotFound)
+			return
+		}
+		if !waitForNewer || room.Version > since {
+			view := room.publicView()
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", roomETag(view.Version))
+			json.NewEncoder(w).Encode(timedView(view))
+			return
+		}
+		changed := waitForChange(roomID)
+		mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func roomVersion(roomID string) uint64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	return rooms[roomID].Version
+}
+
+func TestLongPollReturnsOnChange(t *testing.T) {
+	roomID := createTestRoom(t, "poll-key")
+	since := roomVersion(roomID)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		url := "/get-room-longpoll?room_id=" + roomID + "&since=" + strconv.FormatUint(since, 10)
+		LongPollRoomHandler(rr, httptest.NewRequest("GET", url, nil))
+		done <- rr
+	}()
+
+	select {
+	case rr := <-done:
+		t.Fatalf("Expected the poll to wait for a change, got %d", rr.Code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	joinTestRoom(t, roomID, "polled", "REG1260")
+
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var room Room
+		json.NewDecoder(rr.Body).Decode(&room)
+		if room.Version <= since || len(room.Students) != 1 {
+			t.Fatalf("Expected the joined student at a newer version than %d, got version %d with %d students", since, room.Version, len(room.Students))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Long poll did not return after the room changed")
+	}
+}
+
+func TestLongPollTimesOut(t *testing.T) {
+	roomID := createTestRoom(t, "poll-key")
+	prev := longPollTimeout
+	longPollTimeout = 20 * time.Millisecond
+	defer func() { longPollTimeout = prev }()
+
+	rr := httptest.NewRecorder()
+	url := "/get-room-longpoll?room_id=" + roomID + "&since=" + strconv.FormatUint(roomVersion(roomID), 10)
+	LongPollRoomHandler(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 when nothing changed, got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Fatalf("Expected an empty body with 304, got %q", rr.Body.String())
+	}
+
+	// An older version is answered at once
+	rr = httptest.NewRecorder()
+	joinTestRoom(t, roomID, "late", "REG1261")
+	LongPollRoomHandler(rr, httptest.NewRequest("GET", "/get-room-longpoll?room_id="+roomID+"&since=0", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a stale version, got %d", rr.Code)
+	}
+}
@@ -1,20 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
-	"os/exec"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
-type ScanResult struct {
-	ForbiddenFound bool     `json:"forbidden_found"`
-	Processes      []string `json:"processes"`
-}
-
-var forbiddenApps = []string{"firefox", "hotspotshield", "discord", "slack", "spotify", "zen"}
 var wsHub *Hub
 
 func serveWsHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,75 +35,108 @@ func GetLocalIP() string {
 	return ""
 }
 
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type")
-}
-
-func checkProcessesHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
-		return
+func main() {
+	dir := flag.String("data-dir", envOr("PROCTOR_DATA_DIR", "."), "directory for persisted state (env PROCTOR_DATA_DIR)")
+	flag.DurationVar(&waitingRoomTTL, "waiting-ttl", envDuration("PROCTOR_WAITING_TTL", waitingRoomTTL), "delete empty Waiting rooms after this long (env PROCTOR_WAITING_TTL)")
+	flag.DurationVar(&completeRoomRetention, "complete-retention", envDuration("PROCTOR_COMPLETE_RETENTION", completeRoomRetention), "archive Complete rooms after this long (env PROCTOR_COMPLETE_RETENTION)")
+	flag.DurationVar(&scanTimeout, "scan-timeout", envDuration("PROCTOR_SCAN_TIMEOUT", scanTimeout), "kill a process scan after this long (env PROCTOR_SCAN_TIMEOUT)")
+	flag.Float64Var(&networkLossThreshold, "network-loss-threshold", envFloat("PROCTOR_NETWORK_LOSS_THRESHOLD", networkLossThreshold), "share of a room's online students that must stop pinging at once to mark it NetworkLoss; 0 disables (env PROCTOR_NETWORK_LOSS_THRESHOLD)")
+	flag.DurationVar(&defaultOfflineGrace, "offline-grace", envDuration("PROCTOR_OFFLINE_GRACE", defaultOfflineGrace), "mark a student Offline after this long without a ping; rooms may override it (env PROCTOR_OFFLINE_GRACE)")
+	flag.DurationVar(&defaultScanInterval, "scan-interval", envDuration("PROCTOR_SCAN_INTERVAL", defaultScanInterval), "how often clients are told to scan; rooms may override it (env PROCTOR_SCAN_INTERVAL)")
+	flag.DurationVar(&missedScanGrace, "missed-scan-grace", envDuration("PROCTOR_MISSED_SCAN_GRACE", missedScanGrace), "how long past a room's scan interval a student's scan may be before the missed_scans trigger acts (env PROCTOR_MISSED_SCAN_GRACE)")
+	flag.IntVar(&backupKeep, "backup-keep", envInt("PROCTOR_BACKUP_KEEP", backupKeep), "backups kept of each room's file; 0 disables backups (env PROCTOR_BACKUP_KEEP)")
+	flag.DurationVar(&backupInterval, "backup-interval", envDuration("PROCTOR_BACKUP_INTERVAL", backupInterval), "least time between backups of a room that keeps changing (env PROCTOR_BACKUP_INTERVAL)")
+	flag.BoolVar(&reconnectRestoresOnline, "reconnect-online", envBool("PROCTOR_RECONNECT_ONLINE", reconnectRestoresOnline), "bring an Offline student back Online when they rejoin instead of at their next heartbeat (env PROCTOR_RECONNECT_ONLINE)")
+	flag.BoolVar(&inMemory, "in-memory", envBool("PROCTOR_IN_MEMORY", false), "keep all state in memory and write nothing to disk (env PROCTOR_IN_MEMORY)")
+	flag.IntVar(&sessionIDBytes, "session-id-bytes", envInt("PROCTOR_SESSION_ID_BYTES", sessionIDBytes), "random bytes in session and other generated IDs, at least 8 (env PROCTOR_SESSION_ID_BYTES)")
+	flag.IntVar(&roomIDLength, "room-id-length", envInt("PROCTOR_ROOM_ID_LENGTH", roomIDLength), "characters in a room code, 4 to 16 (env PROCTOR_ROOM_ID_LENGTH)")
+	flag.IntVar(&maxRequestBytes, "max-request-bytes", envInt("PROCTOR_MAX_REQUEST_BYTES", maxRequestBytes), "largest request body the server accepts on any endpoint; 0 disables (env PROCTOR_MAX_REQUEST_BYTES)")
+	flag.StringVar(&scanCommand, "scan-command", envOr("PROCTOR_SCAN_COMMAND", scanCommand), "process listing command line; {default} picks ps or tasklist by OS (env PROCTOR_SCAN_COMMAND)")
+	flag.StringVar(&scanParser, "scan-parser", envOr("PROCTOR_SCAN_PARSER", scanParser), "how to read the scan command's output: ps, tasklist or lines; {default} picks by OS (env PROCTOR_SCAN_PARSER)")
+	extensions := flag.String("forbidden-extensions", envOr("PROCTOR_FORBIDDEN_EXTENSIONS", strings.Join(forbiddenExtensions, ",")), "comma-separated browser extension patterns flagged by /scan/extensions (env PROCTOR_FORBIDDEN_EXTENSIONS)")
+	ignore := flag.String("scan-ignore", envOr("PROCTOR_SCAN_IGNORE", strings.Join(scanIgnore, ",")), "comma-separated known safe command line substrings (or re:patterns) whose scan matches are dropped; rooms may override it (env PROCTOR_SCAN_IGNORE)")
+	remote := flag.String("remote-access-apps", envOr("PROCTOR_REMOTE_ACCESS_APPS", strings.Join(remoteAccessApps, ",")), "comma-separated VM and remote desktop process patterns reported as remote_access by /scan (env PROCTOR_REMOTE_ACCESS_APPS)")
+	duplicates := flag.String("duplicate-room-names", envOr("PROCTOR_DUPLICATE_ROOM_NAMES", duplicateRoomNames), "when a host reuses the session name of an open room: off, warn or reject (env PROCTOR_DUPLICATE_ROOM_NAMES)")
+	format := flag.String("store-format", envOr("PROCTOR_STORE_FORMAT", "json"), "serializer for saved room state, json or gob; either is read back (env PROCTOR_STORE_FORMAT)")
+	origins := flag.String("allowed-origins", envOr("PROCTOR_ALLOWED_ORIGINS", strings.Join(allowedOrigins, ",")), "comma-separated browser origins allowed for CORS and websockets; * allows any (env PROCTOR_ALLOWED_ORIGINS)")
+	flag.Parse()
+	allowedOrigins = parseOrigins(*origins)
+	forbiddenExtensions = splitList(*extensions)
+	remoteAccessApps = splitList(*remote)
+	scanIgnore = splitList(*ignore)
+
+	if err := checkIDConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	// Run ps command to list all processes
-	// Using "-e" for standard syntax to select all processes
-	cmd := exec.Command("ps", "-e")
-	output, err := cmd.Output()
-	if err != nil {
-		// Fallback or error handling
-		fmt.Println("Error running ps:", err)
-		http.Error(w, "Failed to scan processes", http.StatusInternalServerError)
-		return
+	if err := checkScanConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	outStr := strings.ToLower(string(output))
-	found := []string{}
-
-	for _, app := range forbiddenApps {
-		if strings.Contains(outStr, app) {
-			found = append(found, app)
-		}
+	if err := setDuplicateRoomNames(*duplicates); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	result := ScanResult{
-		ForbiddenFound: len(found) > 0,
-		Processes:      found,
+	if err := setStoreFormat(*format); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	if err := setDataDir(*dir); err != nil {
+		fmt.Println("Error preparing data dir:", err)
+		os.Exit(1)
+	}
+	if err := loadRooms(); err != nil {
+		fmt.Println("Error loading rooms:", err)
+		fmt.Println("Refusing to start, as the next save could overwrite them")
+		os.Exit(1)
+	}
+	loadTemplates()
+	loadForbiddenLists()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
-}
-
-func main() {
 	ip := GetLocalIP()
 	fmt.Printf("Starting Proctor Process Shield on :8080...\n")
 	if ip != "" {
 		fmt.Printf("Admin: Share this IP with students: %s\n", ip)
 	}
+	fmt.Printf("Build: %s\n", versionInfo())
+	if inMemory {
+		fmt.Println("Keeping data in memory only; nothing is written to disk")
+	} else {
+		fmt.Printf("Storing data in %s\n", dataDir)
+	}
 
 	// Initialize WebSocket Hub
-	wsHub = newHub()
+	wsHub = newHub(defaultHubConfig())
 	go wsHub.run()
 
-	http.HandleFunc("/ws", serveWsHandler)
-	http.HandleFunc("/scan", checkProcessesHandler)
-	http.HandleFunc("/create-room", CreateRoomHandler)
-	http.HandleFunc("/join-room", JoinRoomHandler)
-	http.HandleFunc("/start-exam", StartExamHandler)
-	http.HandleFunc("/admin/update-status", AdminUpdateUserHandler)
-	http.HandleFunc("/get-room", GetRoomHandler)
-	http.HandleFunc("/get-all-rooms", GetAllRoomsHandler)
-	http.HandleFunc("/update-room", UpdateRoomHandler)
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		enableCors(&w)
-		fmt.Fprintf(w, "Proctor Backend Active. Use /scan to check processes.")
-	})
-
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
+	go runJanitor()
+	go runHeartbeatMonitor()
+
+	server := &http.Server{Addr: ":8080", Handler: newRouter()}
+	// Closed once in-flight requests have finished and the hub has stopped
+	shutdown := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		fmt.Println("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		// Hijacked websocket connections are not closed by Shutdown
+		wsHub.stop()
+		close(shutdown)
+	}()
+
+	// ListenAndServe returns as soon as Shutdown starts, so wait for it to
+	// finish before the last save
+	if err := server.ListenAndServe(); err == http.ErrServerClosed {
+		<-shutdown
+	} else if err != nil {
 		fmt.Println("Error starting server:", err)
 	}
+
+	// Make sure the last changes reach disk
+	flush()
 }
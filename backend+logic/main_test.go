@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain keeps persisted test state out of the source tree
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "proctor-test-")
+	if err != nil {
+		fmt.Println("Error creating test data dir:", err)
+		os.Exit(1)
+	}
+	if err := setDataDir(dir); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// useFakeClock pins now() to start for the duration of the test and returns
+// a function that moves the clock forward
+func useFakeClock(t *testing.T, start time.Time) func(time.Duration) {
+	t.Helper()
+	var clockMu sync.Mutex
+	current := start
+
+	prev := now
+	now = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return current
+	}
+	t.Cleanup(func() {
+		flush() // The saver reads the clock when it backs a room up
+		now = prev
+	})
+
+	return func(d time.Duration) {
+		clockMu.Lock()
+		current = current.Add(d)
+		clockMu.Unlock()
+	}
+}
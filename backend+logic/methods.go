@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// methodGuard wraps h so it only runs for the given methods. OPTIONS is
+// always let through (the cors middleware normally answers it first); any
+// other method gets a 405. Every response carries an Allow header
+// listing the accepted verbs.
+func methodGuard(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allowed := append(append([]string{}, methods...), http.MethodOptions)
+	allow := strings.Join(allowed, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		for _, m := range allowed {
+			if r.Method == m {
+				h(w, r)
+				return
+			}
+		}
+		writeJSONError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
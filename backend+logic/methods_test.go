@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodGuard(t *testing.T) {
+	called := false
+	h := methodGuard(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}, http.MethodGet)
+
+	cases := []struct {
+		method string
+		status int
+		called bool
+	}{
+		{"GET", http.StatusOK, true},
+		{"OPTIONS", http.StatusOK, true},
+		{"POST", http.StatusMethodNotAllowed, false},
+		{"DELETE", http.StatusMethodNotAllowed, false},
+	}
+	for _, c := range cases {
+		called = false
+		req := httptest.NewRequest(c.method, "/get-room", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.method, rr.Code, c.status)
+		}
+		if called != c.called {
+			t.Errorf("%s: handler called = %v, want %v", c.method, called, c.called)
+		}
+		if got := rr.Header().Get("Allow"); got != "GET, OPTIONS" {
+			t.Errorf("%s: got Allow %q, want %q", c.method, got, "GET, OPTIONS")
+		}
+	}
+}
+
+func TestMethodGuardRejectsWrongVerbOnRealHandlers(t *testing.T) {
+	cases := []struct {
+		handler http.HandlerFunc
+		allowed string
+		wrong   string
+	}{
+		{GetRoomHandler, http.MethodGet, http.MethodPost},
+		{GetAllRoomsHandler, http.MethodGet, http.MethodPost},
+		{CreateRoomHandler, http.MethodPost, http.MethodGet},
+		{JoinRoomHandler, http.MethodPost, http.MethodPut},
+	}
+	for _, c := range cases {
+		h := methodGuard(c.handler, c.allowed)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(c.wrong, "/", nil))
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s to %s handler: got status %d, want 405", c.wrong, c.allowed, rr.Code)
+		}
+		want := c.allowed + ", OPTIONS"
+		if got := rr.Header().Get("Allow"); got != want {
+			t.Errorf("%s to %s handler: got Allow %q, want %q", c.wrong, c.allowed, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowedOrigins lists the browser origins that may call the API and open
+// websockets: the Tauri webview on each platform and the Vite dev server.
+// A "*" entry allows any origin. Requests without an Origin header come
+// from non-browser clients and are not restricted.
+var allowedOrigins = []string{
+	"tauri://localhost",
+	"http://tauri.localhost",
+	"https://tauri.localhost",
+	"http://localhost:5173",
+}
+
+// parseOrigins splits a comma-separated origin list, dropping blanks and
+// trailing slashes
+func parseOrigins(list string) []string {
+	out := splitList(list)
+	for i, o := range out {
+		out[i] = strings.TrimSuffix(o, "/")
+	}
+	return out
+}
+
+// originAllowed reports whether origin is on the allowlist. Scheme and host
+// are case-insensitive.
+func originAllowed(origin string) bool {
+	for _, o := range allowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWsOrigin is the websocket upgrader's CheckOrigin. Without it any
+// website could open a socket to the backend and watch room updates.
+func checkWsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || originAllowed(origin)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseOrigins(t *testing.T) {
+	got := strings.Join(parseOrigins(" https://admin.example/, ,tauri://localhost"), " ")
+	if got != "https://admin.example tauri://localhost" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCORSOriginAllowlist(t *testing.T) {
+	captureLog(t)
+	router := newRouter()
+
+	cases := []struct {
+		origin string
+		want   string
+	}{
+		{"tauri://localhost", "tauri://localhost"},
+		{"HTTP://Tauri.Localhost", "HTTP://Tauri.Localhost"},
+		{"https://evil.example", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("OPTIONS", "/get-room", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != c.want {
+			t.Errorf("Origin %q: got Allow-Origin %q, want %q", c.origin, got, c.want)
+		}
+	}
+}
+
+func TestWebsocketOriginAllowlist(t *testing.T) {
+	hub := newHub(defaultHubConfig())
+	go hub.run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(hub, w, r)
+	}))
+	t.Cleanup(func() {
+		hub.stop()
+		server.Close()
+	})
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dial := func(origin string) (int, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return 0, err
+		}
+		return resp.StatusCode, err
+	}
+
+	for _, origin := range []string{"tauri://localhost", "http://localhost:5173", ""} {
+		if status, err := dial(origin); err != nil {
+			t.Errorf("Origin %q: expected upgrade, got %d: %v", origin, status, err)
+		}
+	}
+	for _, origin := range []string{"https://evil.example", "http://localhost:5174"} {
+		if status, err := dial(origin); err == nil || status != http.StatusForbidden {
+			t.Errorf("Origin %q: expected 403, got %d: %v", origin, status, err)
+		}
+	}
+
+	// A wildcard entry turns the check off
+	prev := allowedOrigins
+	allowedOrigins = []string{"*"}
+	t.Cleanup(func() { allowedOrigins = prev })
+	if status, err := dial("https://evil.example"); err != nil {
+		t.Errorf("Wildcard allowlist: expected upgrade, got %d: %v", status, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// FlaggedStudent is a flagged student as the overview lists them
+type FlaggedStudent struct {
+	UserSessionID string     `json:"user_session_id"`
+	UserID        string     `json:"user_id"`
+	Username      string     `json:"username"`
+	RegNo         string     `json:"regno"`
+	RiskScore     int        `json:"risk_score"`
+	RiskLevel     string     `json:"risk_level,omitempty"`
+	LastFlag      FlagRecord `json:"last_flag"`
+}
+
+// RoomOverview is one room on the admin dashboard: its list entry, how its
+// students stand, and who is flagged, riskiest first
+type RoomOverview struct {
+	RoomSummary
+	As             string           `json:"as"` // The admin label the key holds in this room
+	Remaining      *Duration        `json:"remaining,omitempty"`
+	OfflineCount   int              `json:"offline_count"`
+	SubmittedCount int              `json:"submitted_count"`
+	FlaggedCount   int              `json:"flagged_count"`
+	RiskLevels     map[string]int   `json:"risk_levels"` // Students at each risk level, flagged or not
+	Flagged        []FlaggedStudent `json:"flagged"`
+}
+
+// Overview is the /admin/overview payload
+type Overview struct {
+	ServerTime   time.Time      `json:"server_time"`
+	Rooms        []RoomOverview `json:"rooms"`
+	RoomCount    int            `json:"room_count"`
+	StudentCount int            `json:"student_count"`
+	OnlineCount  int            `json:"online_count"`
+	FlaggedCount int            `json:"flagged_count"`
+}
+
+// overview summarizes a room for an admin at t. Caller holds mu.
+func (r *Room) overview(as string, t time.Time) RoomOverview {
+	o := RoomOverview{
+		RoomSummary: r.summary(),
+		As:          as,
+		Remaining:   r.remaining(t),
+		RiskLevels:  map[string]int{},
+		Flagged:     []FlaggedStudent{},
+	}
+	for _, s := range r.Students {
+		if s.RiskLevel != "" {
+			o.RiskLevels[s.RiskLevel]++
+		}
+		switch s.ActiveStatus {
+		case Offline:
+			o.OfflineCount++
+		case Submitted:
+			o.SubmittedCount++
+		case Flagged:
+			o.FlaggedCount++
+			f := FlaggedStudent{UserSessionID: s.ID, UserID: s.UserID, Username: s.Username, RegNo: s.RegNo, RiskScore: s.RiskScore, RiskLevel: s.RiskLevel}
+			if len(s.Flags) > 0 {
+				f.LastFlag = s.Flags[len(s.Flags)-1]
+			}
+			o.Flagged = append(o.Flagged, f)
+		}
+	}
+	sort.SliceStable(o.Flagged, func(i, j int) bool { return o.Flagged[i].RiskScore > o.Flagged[j].RiskScore })
+	return o
+}
+
+// OverviewHandler returns, in one call, every room the admin key opens with
+// its counts and flagged students, for the main admin screen. The master key
+// opens every room. An optional host_id narrows it to one host's rooms.
+func OverviewHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, hostID := q.Get("admin_key"), q.Get("host_id")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "admin_key is required")
+		return
+	}
+	master := isMasterKey(key)
+	if master {
+		log.Printf("Master key used for the overview")
+	}
+
+	mu.RLock()
+	out := Overview{ServerTime: now(), Rooms: []RoomOverview{}}
+	for _, room := range rooms {
+		if hostID != "" && room.HostID != hostID {
+			continue
+		}
+		as := masterLabel
+		if !master {
+			var ok bool
+			if as, ok = room.adminLabel(key); !ok {
+				continue
+			}
+		}
+		o := room.overview(as, out.ServerTime)
+		out.Rooms = append(out.Rooms, o)
+		out.StudentCount += o.StudentCount
+		out.OnlineCount += o.OnlineCount
+		out.FlaggedCount += o.FlaggedCount
+	}
+	mu.RUnlock()
+
+	out.RoomCount = len(out.Rooms)
+	sort.Slice(out.Rooms, func(i, j int) bool { return out.Rooms[i].ID < out.Rooms[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
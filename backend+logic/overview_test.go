@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminOverview(t *testing.T) {
+	withEmptyRooms(t)
+	prev := masterKey
+	masterKey = "overview-master"
+	t.Cleanup(func() { masterKey = prev })
+
+	first := createTestRoom(t, "dash-key")
+	second := createTestRoom(t, "dash-key")
+	other := createTestRoom(t, "someone-else")
+	for _, id := range []string{"ann", "bob", "cy"} {
+		joinTestRoom(t, first, id, "")
+	}
+	joinTestRoom(t, second, "dee", "")
+	joinTestRoom(t, other, "eve", "")
+	for _, flag := range []struct{ room, user string }{{first, "ann"}, {first, "bob"}, {second, "dee"}} {
+		if err := updateUserStatus(flag.room, "dash-key", flag.user, Flagged); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updateUserStatus(first, "dash-key", "cy", Submitted); err != nil {
+		t.Fatal(err)
+	}
+	// Manual flags carry no risk; a trigger on top of one does
+	fireTrigger(first, "bob", "forbidden_apps")
+
+	get := func(query string) (*httptest.ResponseRecorder, Overview) {
+		rr := httptest.NewRecorder()
+		OverviewHandler(rr, httptest.NewRequest("GET", "/admin/overview?"+query, nil))
+		var o Overview
+		json.Unmarshal(rr.Body.Bytes(), &o)
+		return rr, o
+	}
+
+	rr, o := get("admin_key=dash-key")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Overview returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if o.RoomCount != 2 || len(o.Rooms) != 2 || o.StudentCount != 4 || o.FlaggedCount != 3 {
+		t.Fatalf("Expected 2 rooms, 4 students and 3 flagged, got %+v", o)
+	}
+	byID := map[string]RoomOverview{}
+	for _, r := range o.Rooms {
+		byID[r.ID] = r
+	}
+	f := byID[first]
+	if f.StudentCount != 3 || f.FlaggedCount != 2 || f.SubmittedCount != 1 || len(f.Flagged) != 2 || f.As != ownerLabel {
+		t.Fatalf("Unexpected overview of the first room: %+v", f)
+	}
+	if f.Flagged[1].LastFlag.By != ownerLabel || f.Flagged[1].UserSessionID == "" {
+		t.Fatalf("Expected flagged students with their last flag, got %+v", f.Flagged)
+	}
+	if f.RiskLevels["high"] != 1 || f.Flagged[0].UserID != "bob" || f.Flagged[0].RiskLevel != "high" || f.Flagged[1].RiskScore != 0 {
+		t.Fatalf("Expected bob's risk first and counted as high, got %v %+v", f.RiskLevels, f.Flagged)
+	}
+	if _, ok := byID[other]; ok {
+		t.Fatal("The overview listed a room the key does not open")
+	}
+	for _, secret := range []string{"dash-key", "answers", "session_token"} {
+		if bytes.Contains(rr.Body.Bytes(), []byte(secret)) {
+			t.Fatalf("The overview leaked %q: %s", secret, rr.Body.String())
+		}
+	}
+
+	if _, o := get("admin_key=overview-master"); o.RoomCount != 3 {
+		t.Fatalf("Expected the master key to see all 3 rooms, got %d", o.RoomCount)
+	}
+	if _, o := get("admin_key=overview-master&host_id=nobody"); o.RoomCount != 0 || o.Rooms == nil {
+		t.Fatalf("Expected an empty room list for an unknown host, got %+v", o)
+	}
+	if rr, _ := get(""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 without admin_key, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// roomsDir holds one file per room, inside dataDir, so a change to one room
+// only rewrites that room. Files keep the .json name whichever serializer
+// writes them.
+const roomsDir = "rooms"
+
+// dataFile is the single-file store used before roomsDir. It is migrated
+// on first load and kept as rooms.json.migrated.
+const dataFile = "rooms.json"
+
+// roomFile returns the path of a room's file
+func roomFile(id string) string {
+	return filepath.Join(dataPath(roomsDir), id+".json")
+}
+
+// validRoomFileID rejects IDs that would escape roomsDir
+func validRoomFileID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// prepareLoadedRoom fills in what older or gob-encoded files leave out
+func prepareLoadedRoom(room *Room) {
+	// Rooms saved before CreatedAt existed get a full TTL from now
+	if room.CreatedAt.IsZero() {
+		room.CreatedAt = now()
+	}
+	if room.UpdatedAt.IsZero() {
+		room.UpdatedAt = room.CreatedAt
+	}
+	// gob drops empty slices and maps; handlers expect them to exist
+	if room.Students == nil {
+		room.Students = []UserSession{}
+	}
+	if room.Sets == nil {
+		room.Sets = make(map[string]string)
+	}
+	// Co-proctor keys saved before they were hashed
+	for i := range room.CoProctors {
+		if c := &room.CoProctors[i]; c.Key != "" {
+			c.KeyHash, c.Key = hashAdminKey(c.Key), ""
+		}
+	}
+	// Submissions recorded before SubmittedAt existed
+	for i := range room.Students {
+		if s := &room.Students[i]; s.SubmittedAt == nil && s.ActiveStatus == Submitted {
+			at := s.LastPing
+			s.SubmittedAt = &at
+		}
+	}
+	// Students who joined before seeds existed get one now, kept from then on
+	for i := range room.Students {
+		if room.Students[i].Seed == 0 {
+			room.Students[i].Seed, _ = generateSeed()
+		}
+	}
+}
+
+// loadRooms replaces rooms with the saved ones. A file that cannot be
+// decoded is set aside with a warning; a file or dir that cannot be read at
+// all is returned as an error, and the server must not start.
+func loadRooms() error {
+	if inMemory {
+		return nil
+	}
+	loaded := make(map[string]*Room)
+
+	// Starting empty when the saved rooms cannot be read would let the next
+	// save discard them
+	entries, err := os.ReadDir(dataPath(roomsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading rooms dir: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dataPath(roomsDir), e.Name())
+		room, err := readRoomFile(path)
+		if errors.Is(err, errCorruptFile) {
+			// Set the damaged room aside rather than lose the others
+			if err := quarantine(path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		loaded[room.ID] = room
+	}
+
+	migrated, err := migrateLegacyRooms(loaded)
+	if errors.Is(err, errCorruptFile) {
+		err = quarantine(dataPath(dataFile), err)
+	}
+	if err != nil {
+		return fmt.Errorf("migrating %s: %w", dataFile, err)
+	}
+
+	for _, room := range loaded {
+		prepareLoadedRoom(room)
+	}
+
+	mu.Lock()
+	rooms = loaded
+	mu.Unlock()
+
+	if migrated > 0 {
+		log.Printf("Migrated %d rooms from rooms.json to %s/", migrated, roomsDir)
+	}
+	return nil
+}
+
+// errCorruptFile marks a saved file that was read but could not be decoded
+var errCorruptFile = errors.New("corrupt file")
+
+// quarantine renames a corrupt file out of the way, keeping it for
+// inspection, so later saves neither load nor remove it
+func quarantine(path string, cause error) error {
+	backup := path + ".corrupt-" + now().UTC().Format("20060102T150405")
+	if err := os.Rename(path, backup); err != nil {
+		return fmt.Errorf("setting aside %s: %w", path, err)
+	}
+	log.Printf("WARNING: %s could not be loaded (%v); moved it to %s", path, cause, backup)
+	return nil
+}
+
+func readRoomFile(path string) (*Room, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var room Room
+	if err := decodeDetected(file, &room); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptFile, err)
+	}
+	if !validRoomFileID(room.ID) {
+		return nil, fmt.Errorf("%w: invalid room ID %q", errCorruptFile, room.ID)
+	}
+	return &room, nil
+}
+
+// migrateLegacyRooms moves the rooms in a legacy rooms.json into loaded and
+// roomsDir, then renames rooms.json so it is not migrated twice. Rooms
+// already in roomsDir win over their legacy copy.
+func migrateLegacyRooms(loaded map[string]*Room) (int, error) {
+	info, err := os.Stat(dataPath(dataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, fmt.Errorf("%s is a directory", dataPath(dataFile))
+	}
+	file, err := os.Open(dataPath(dataFile))
+	if err != nil {
+		return 0, err
+	}
+	var legacy map[string]*Room
+	err = decodeDetected(file, &legacy)
+	file.Close()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errCorruptFile, err)
+	}
+
+	if err := os.MkdirAll(dataPath(roomsDir), 0o755); err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for id, room := range legacy {
+		if _, exists := loaded[id]; exists || !validRoomFileID(id) {
+			continue
+		}
+		room.ID = id
+		if err := writeRoomFile(room); err != nil {
+			return migrated, err
+		}
+		loaded[id] = room
+		migrated++
+	}
+	return migrated, os.Rename(dataPath(dataFile), dataPath(dataFile)+".migrated")
+}
+
+// writeRoomFile saves one room through a temp file so a crash never leaves
+// it half written
+func writeRoomFile(room *Room) error {
+	tmp, err := os.CreateTemp(dataPath(roomsDir), room.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := storeSerializer.encode(tmp, room); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := backupRoomFile(room.ID, false); err != nil {
+		log.Printf("Error backing up room %s: %v", room.ID, err)
+	}
+	roomFileWrites.Add(1)
+	return os.Rename(tmp.Name(), roomFile(room.ID))
+}
+
+// saveRooms writes the rooms marked dirty by requestSave and removes the
+// files of dirty rooms that no longer exist. A full save also removes
+// files for any room no longer in memory. In memory-only mode it only
+// clears the dirty set.
+func saveRooms() {
+	dirtyMu.Lock()
+	ids, all := dirtyRooms, dirtyAll
+	dirtyRooms, dirtyAll = make(map[string]bool), false
+	dirtyMu.Unlock()
+	if (len(ids) == 0 && !all) || inMemory {
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	roomWrites.Add(1)
+
+	if err := os.MkdirAll(dataPath(roomsDir), 0o755); err != nil {
+		log.Println("Error saving rooms:", err)
+		return
+	}
+	if all {
+		ids = make(map[string]bool, len(rooms))
+		for id := range rooms {
+			ids[id] = true
+		}
+		// Files of rooms deleted since the last save
+		entries, _ := os.ReadDir(dataPath(roomsDir))
+		for _, e := range entries {
+			if id := strings.TrimSuffix(e.Name(), ".json"); id != e.Name() {
+				ids[id] = true
+			}
+		}
+	}
+
+	for id := range ids {
+		if !validRoomFileID(id) {
+			continue
+		}
+		room, exists := rooms[id]
+		if !exists {
+			if err := backupRoomFile(id, true); err != nil {
+				log.Printf("Error backing up room %s: %v", id, err)
+			}
+			if err := os.Remove(roomFile(id)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing room %s: %v", id, err)
+			}
+			continue
+		}
+		if err := writeRoomFile(room); err != nil {
+			log.Printf("Error saving room %s: %v", id, err)
+		}
+	}
+}
+
+// saveDelay is how long save requests are coalesced before rooms are written
+var saveDelay = 200 * time.Millisecond
+
+var (
+	// saveRequests and flushRequests feed the single saver goroutine, which
+	// is the only code that ever writes room files
+	saveRequests  = make(chan struct{}, 1)
+	flushRequests = make(chan chan struct{})
+	saverOnce     sync.Once
+
+	// dirtyRooms are the rooms changed since the last save; dirtyAll asks
+	// for every room
+	dirtyMu    sync.Mutex
+	dirtyRooms = make(map[string]bool)
+	dirtyAll   bool
+
+	// roomWrites counts save passes and roomFileWrites the room files they wrote
+	roomWrites     atomic.Int64
+	roomFileWrites atomic.Int64
+)
+
+// runSaver owns all writes of room files. Save requests arriving within
+// saveDelay of each other are coalesced, and the last request is always
+// followed by a write.
+func runSaver() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-saveRequests:
+			if timer == nil {
+				timer = time.After(saveDelay)
+			}
+		case <-timer:
+			timer = nil
+			saveRooms()
+		case done := <-flushRequests:
+			pending := timer != nil
+			// A request may still be sitting in the buffer
+			select {
+			case <-saveRequests:
+				pending = true
+			default:
+			}
+			if pending {
+				timer = nil
+				saveRooms()
+			}
+			close(done)
+		}
+	}
+}
+
+// requestSave schedules a write of the given rooms without blocking; with
+// no IDs every room is written. Deleted rooms are passed too so their
+// files are removed.
+func requestSave(roomIDs ...string) {
+	dirtyMu.Lock()
+	if len(roomIDs) == 0 {
+		dirtyAll = true
+	}
+	for _, id := range roomIDs {
+		dirtyRooms[id] = true
+	}
+	dirtyMu.Unlock()
+
+	saverOnce.Do(func() { go runSaver() })
+	select {
+	case saveRequests <- struct{}{}:
+	default:
+		// A request is already queued; it covers this one
+	}
+}
+
+// flush blocks until any pending save has been written. Used on shutdown.
+func flush() {
+	saverOnce.Do(func() { go runSaver() })
+	done := make(chan struct{})
+	flushRequests <- done
+	<-done
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDebouncedSaves(t *testing.T) {
+	prevDelay := saveDelay
+	saveDelay = 50 * time.Millisecond
+	t.Cleanup(func() { saveDelay = prevDelay })
+
+	roomID := createTestRoom(t, "save-key")
+	flush()
+	before := roomWrites.Load()
+
+	for i := 0; i < 25; i++ {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "save-key", "session_name": "Burst"}`)
+		req, _ := http.NewRequest("POST", "/update-room", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(UpdateRoomHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("UpdateRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	if writes := roomWrites.Load() - before; writes != 0 {
+		t.Errorf("Expected writes to be deferred, got %d", writes)
+	}
+
+	waitFor(t, "debounced write", func() bool { return roomWrites.Load()-before == 1 })
+	time.Sleep(2 * saveDelay)
+	if writes := roomWrites.Load() - before; writes != 1 {
+		t.Errorf("Burst of 25 updates produced %d writes, want 1", writes)
+	}
+
+	data, err := os.ReadFile(roomFile(roomID))
+	if err != nil || !bytes.Contains(data, []byte(roomID)) {
+		t.Errorf("The room file does not contain the room: %v", err)
+	}
+
+	// flush writes a pending save immediately and only once
+	requestSave()
+	flush()
+	flush()
+	if writes := roomWrites.Load() - before; writes != 2 {
+		t.Errorf("Flush produced %d total writes, want 2", writes)
+	}
+}
+
+func TestConcurrentSavesSingleWriter(t *testing.T) {
+	roomID := createTestRoom(t, "race-key")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			requestSave()
+			if i%5 == 0 {
+				flush()
+			}
+		}(i)
+	}
+	wg.Wait()
+	flush()
+
+	data, err := os.ReadFile(roomFile(roomID))
+	if err != nil {
+		t.Fatalf("Reading the room file: %v", err)
+	}
+	var loaded Room
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Errorf("The room file is corrupt after concurrent saves: %v", err)
+	}
+}
+
+func TestPerRoomSaves(t *testing.T) {
+	withEmptyRooms(t)
+	useStore(t, "json")
+
+	first := createTestRoom(t, "file-key")
+	second := createTestRoom(t, "file-key")
+	joinTestRoom(t, first, "ivy", "REG900")
+	flush()
+
+	before := roomFileWrites.Load()
+	secondData, _ := os.ReadFile(roomFile(second))
+	if err := updateUserStatus(first, "file-key", "ivy", Flagged); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	if writes := roomFileWrites.Load() - before; writes != 1 {
+		t.Errorf("Updating one room wrote %d files, want 1", writes)
+	}
+	if data, _ := os.ReadFile(roomFile(second)); !bytes.Equal(data, secondData) {
+		t.Error("The untouched room's file changed")
+	}
+
+	// A deleted room's file goes with it
+	mu.Lock()
+	delete(rooms, second)
+	mu.Unlock()
+	requestSave(second)
+	flush()
+	if _, err := os.Stat(roomFile(second)); !os.IsNotExist(err) {
+		t.Errorf("Expected the deleted room's file to be removed, got %v", err)
+	}
+
+	mu.Lock()
+	rooms = map[string]*Room{}
+	mu.Unlock()
+	if err := loadRooms(); err != nil {
+		t.Fatal(err)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(rooms) != 1 || rooms[first] == nil {
+		t.Fatalf("Expected only %s after reload, got %d rooms", first, len(rooms))
+	}
+	if s := rooms[first].Students; len(s) != 1 || s[0].ActiveStatus != Flagged {
+		t.Fatalf("Student state did not survive the reload: %+v", s)
+	}
+}
+
+func TestLegacyRoomsMigrated(t *testing.T) {
+	withEmptyRooms(t)
+	useStore(t, "json")
+
+	legacy := sampleRooms(2, 1)
+	data, _ := json.Marshal(legacy)
+	if err := os.WriteFile(dataPath(dataFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A room already migrated wins over its legacy copy
+	os.MkdirAll(dataPath(roomsDir), 0o755)
+	newer := *legacy["R0001"]
+	newer.SessionName = "Renamed"
+	newerData, _ := json.Marshal(&newer)
+	os.WriteFile(roomFile("R0001"), newerData, 0o644)
+
+	if err := loadRooms(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.RLock()
+	n, name := len(rooms), rooms["R0001"].SessionName
+	mu.RUnlock()
+	if n != 2 || name != "Renamed" {
+		t.Fatalf("Expected 2 rooms with R0001 renamed, got %d rooms, R0001 %q", n, name)
+	}
+	if _, err := os.Stat(roomFile("R0000")); err != nil {
+		t.Errorf("Expected R0000 to get its own file: %v", err)
+	}
+	if _, err := os.Stat(dataPath(dataFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected rooms.json to be moved aside, got %v", err)
+	}
+	if _, err := os.Stat(dataPath(dataFile) + ".migrated"); err != nil {
+		t.Errorf("Expected a rooms.json.migrated backup: %v", err)
+	}
+}
+
+func TestInMemoryMode(t *testing.T) {
+	flush()
+	prevDir, prevInMemory := dataDir, inMemory
+	inMemory = true
+	t.Cleanup(func() { dataDir, inMemory = prevDir, prevInMemory })
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := setDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	withEmptyRooms(t)
+
+	roomID := createTestRoom(t, "mem-key")
+	sessionID, token := joinTestRoom(t, roomID, "mem-student", "REG1300")
+	if err := updateUserStatus(roomID, "mem-key", "mem-student", Flagged); err != nil {
+		t.Fatal(err)
+	}
+
+	// Evidence lives in memory and can still be fetched
+	rr := uploadEvidence(t, map[string]string{"room_id": roomID, "user_session_id": sessionID, "session_token": token}, pngHeader)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Upload returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var uploaded map[string]string
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+	rr = httptest.NewRecorder()
+	GetEvidenceHandler(rr, httptest.NewRequest("GET", "/evidence?room_id="+roomID+"&user_session_id="+sessionID+"&file="+uploaded["filename"]+"&admin_key=mem-key", nil))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), pngHeader) {
+		t.Fatalf("Get evidence returned %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	rr = httptest.NewRecorder()
+	SaveTemplateHandler(rr, httptest.NewRequest("POST", "/save-template",
+		bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "mem-key", "name": "Memory quiz"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SaveTemplate returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var saved map[string]string
+	json.NewDecoder(rr.Body).Decode(&saved)
+	t.Cleanup(func() {
+		templatesMu.Lock()
+		delete(templates, saved["template_id"])
+		templatesMu.Unlock()
+	})
+
+	// Archiving just drops the room
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Complete
+	rooms[roomID].EndTime = now().Add(-2 * completeRoomRetention)
+	mu.Unlock()
+	if _, archived := cleanupRooms(); archived != 1 {
+		t.Fatalf("Expected the completed room to be archived, got %d", archived)
+	}
+	flush()
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing on disk in memory-only mode, got %v", err)
+	}
+}
+
+func TestCorruptRoomFileSetAside(t *testing.T) {
+	withEmptyRooms(t)
+	useStore(t, "json")
+
+	good := sampleRooms(1, 1)["R0000"]
+	data, _ := json.Marshal(good)
+	os.MkdirAll(dataPath(roomsDir), 0o755)
+	os.WriteFile(roomFile("R0000"), data, 0o644)
+	os.WriteFile(roomFile("BROKEN"), []byte(`{"id": "BROKEN", "students": [`), 0o644)
+
+	if err := loadRooms(); err != nil {
+		t.Fatalf("A corrupt room file should not stop the load: %v", err)
+	}
+	mu.RLock()
+	n := len(rooms)
+	mu.RUnlock()
+	if n != 1 {
+		t.Fatalf("Expected the good room to load, got %d rooms", n)
+	}
+	if _, err := os.Stat(roomFile("BROKEN")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the corrupt file to be moved aside, got %v", err)
+	}
+	backups, _ := filepath.Glob(roomFile("BROKEN") + ".corrupt-*")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup of the corrupt file, got %v", backups)
+	}
+
+	// A full save must leave the backup alone
+	requestSave()
+	flush()
+	if _, err := os.Stat(backups[0]); err != nil {
+		t.Fatalf("Expected the backup to survive a save: %v", err)
+	}
+}
+
+func TestCorruptLegacyRoomsSetAside(t *testing.T) {
+	withEmptyRooms(t)
+	useStore(t, "json")
+
+	os.WriteFile(dataPath(dataFile), []byte(`{"R0000": {"id": `), 0o644)
+	if err := loadRooms(); err != nil {
+		t.Fatalf("A corrupt rooms.json should not stop the load: %v", err)
+	}
+	if backups, _ := filepath.Glob(dataPath(dataFile) + ".corrupt-*"); len(backups) != 1 {
+		t.Fatalf("Expected one backup of rooms.json, got %v", backups)
+	}
+}
+
+func TestUnreadableRoomsRefuseToLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func()
+	}{
+		{"rooms dir is a file", func() {
+			os.WriteFile(dataPath(roomsDir), []byte("not a dir"), 0o644)
+		}},
+		{"rooms.json is a directory", func() {
+			os.MkdirAll(dataPath(dataFile), 0o755)
+		}},
+		{"room file cannot be opened", func() {
+			os.MkdirAll(dataPath(roomsDir), 0o755)
+			os.Symlink(filepath.Join(dataDir, "missing"), roomFile("R0000"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEmptyRooms(t)
+			useStore(t, "json")
+			tt.setup()
+
+			// Whatever was loaded before is kept rather than replaced by nothing
+			mu.Lock()
+			rooms["KEEP"] = &Room{ID: "KEEP"}
+			mu.Unlock()
+			if err := loadRooms(); err == nil {
+				t.Fatal("Expected loadRooms to fail")
+			}
+			mu.RLock()
+			defer mu.RUnlock()
+			if rooms["KEEP"] == nil {
+				t.Fatal("A failed load replaced the rooms")
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval is how often clients are expected to call /ping. The
+// heartbeat monitor also runs at this period.
+var heartbeatInterval = 15 * time.Second
+
+// FlagPolicy controls which signals flag a student automatically. Each
+// trigger is independent; a room without a policy uses defaultFlagPolicy.
+type FlagPolicy struct {
+	// ForbiddenApps flags a student whose own scan finds a forbidden app.
+	ForbiddenApps bool `json:"forbidden_apps"`
+
+	// RemoteAccess flags a student whose own scan finds VM guest tools or a remote desktop agent.
+	RemoteAccess bool `json:"remote_access"`
+
+	// SharedIP flags a student who joins from an address another student in the room already uses.
+	SharedIP bool `json:"shared_ip"`
+
+	// TabSwitches flags a student after BlurThreshold blurs within BlurWindow.
+	TabSwitches bool `json:"tab_switches"`
+
+	// MissedHeartbeats flags an Online student after this many heartbeat
+	// intervals without a ping. Zero disables the trigger.
+	MissedHeartbeats int `json:"missed_heartbeats"`
+
+	// MissedScans acts on an Online student of an Active room whose last
+	// scan is older than the room's scan interval plus missedScanGrace:
+	// "notify" marks them ScanOverdue for the proctor, "flag" also flags
+	// them. Empty disables the trigger.
+	MissedScans string `json:"missed_scans,omitempty"`
+
+	// Severities overrides how serious a trigger is, keyed like
+	// defaultSeverities, so that a room can weigh its signals differently
+	Severities map[string]string `json:"severities,omitempty"`
+
+	// RiskThreshold is the risk score at which a student becomes Flagged.
+	// Zero means 1, so that any trigger flags, as it did before risk scores.
+	RiskThreshold int `json:"risk_threshold,omitempty"`
+}
+
+// clone returns a deep copy; nil stays nil
+func (p *FlagPolicy) clone() *FlagPolicy {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	if p.Severities != nil {
+		c.Severities = make(map[string]string, len(p.Severities))
+		for k, v := range p.Severities {
+			c.Severities[k] = v
+		}
+	}
+	return &c
+}
+
+// severityPoints weighs each flag severity in a student's risk score
+var severityPoints = map[string]int{"low": 1, "medium": 3, "high": 10}
+
+// defaultSeverities is how serious each trigger is unless the room's policy
+// overrides it
+var defaultSeverities = map[string]string{
+	"tab_switches":         "low",
+	"missed_heartbeats":    "low",
+	"missed_scans":         "low",
+	"shared_ip":            "medium",
+	"forbidden_extensions": "medium",
+	"forbidden_apps":       "high",
+	"remote_access":        "high",
+}
+
+// validateSeverities rejects overrides for unknown triggers or severities
+func validateSeverities(severities map[string]string) error {
+	for trigger, severity := range severities {
+		if _, ok := defaultSeverities[trigger]; !ok {
+			return fmt.Errorf("Unknown trigger %q in flag_policy.severities", trigger)
+		}
+		if _, ok := severityPoints[severity]; !ok {
+			return fmt.Errorf("flag_policy.severities.%s must be low, medium or high", trigger)
+		}
+	}
+	return nil
+}
+
+// severity returns how serious a trigger is in this policy
+func (p FlagPolicy) severity(trigger string) string {
+	if s, ok := p.Severities[trigger]; ok {
+		return s
+	}
+	return defaultSeverities[trigger]
+}
+
+// riskThreshold returns the risk score at which a student is Flagged
+func (p FlagPolicy) riskThreshold() int {
+	return max(p.RiskThreshold, 1)
+}
+
+// riskLevel names a risk score by the most serious severity it reaches
+func riskLevel(score int) string {
+	switch {
+	case score <= 0:
+		return ""
+	case score >= severityPoints["high"]:
+		return "high"
+	case score >= severityPoints["medium"]:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// missedScansActions are the accepted FlagPolicy.MissedScans values
+var missedScansActions = map[string]bool{"": true, "notify": true, "flag": true}
+
+// missedScanGrace is how long past its scan interval a student's scan may
+// be before the missed scan trigger acts. Set with -missed-scan-grace.
+var missedScanGrace = 30 * time.Second
+
+// defaultFlagPolicy keeps the behaviour rooms had before policies existed:
+// only repeated tab switches flag automatically
+var defaultFlagPolicy = FlagPolicy{TabSwitches: true}
+
+// FlagRecord is one entry in a student's flag history
+type FlagRecord struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+	By     string    `json:"by"` // "auto" for policy triggers, otherwise the admin label
+
+	// Set for policy triggers: which one fired, and what it added to the
+	// student's risk score
+	Trigger  string `json:"trigger,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Points   int    `json:"points,omitempty"`
+
+	// Set once a proctor has reviewed and cleared the flag
+	ClearedAt *time.Time `json:"cleared_at,omitempty"`
+	ClearedBy string     `json:"cleared_by,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+// autoFlagger is the FlagRecord.By value for policy triggers
+const autoFlagger = "auto"
+
+// flagPolicy returns the room's policy or the default
+func (r *Room) flagPolicy() FlagPolicy {
+	if r.FlagPolicy != nil {
+		return *r.FlagPolicy
+	}
+	return defaultFlagPolicy
+}
+
+// autoFlag records a trigger against a student, adds its severity's points
+// to their risk score and broadcasts the change. An Online or Offline
+// student whose score reaches the policy's threshold becomes Flagged.
+// Submitted students are left alone, and a trigger counts once until its
+// flag is cleared. It reports whether a flag was recorded. Caller holds mu.
+func (r *Room) autoFlag(idx int, trigger, reason string) bool {
+	s := &r.Students[idx]
+	if s.ActiveStatus == Submitted {
+		return false
+	}
+	for _, f := range s.Flags {
+		if f.Trigger == trigger && f.ClearedAt == nil {
+			return false
+		}
+	}
+	policy := r.flagPolicy()
+	severity := policy.severity(trigger)
+	s.Flags = append(s.Flags, FlagRecord{
+		At:       now(),
+		Reason:   reason,
+		By:       autoFlagger,
+		Trigger:  trigger,
+		Severity: severity,
+		Points:   severityPoints[severity],
+	})
+	s.updateRisk()
+	if (s.ActiveStatus == Online || s.ActiveStatus == Offline) && s.RiskScore >= policy.riskThreshold() {
+		s.ActiveStatus = Flagged
+	}
+	broadcastStudent(r.ID, *s)
+	return true
+}
+
+// updateRisk sums the points of the student's uncleared flags
+func (s *UserSession) updateRisk() {
+	s.RiskScore = 0
+	for _, f := range s.Flags {
+		if f.ClearedAt == nil {
+			s.RiskScore += f.Points
+		}
+	}
+	s.RiskLevel = riskLevel(s.RiskScore)
+}
+
+// setStatusBy applies an admin's status change, recording manual flags in
+// the flag history and when the student was submitted. Flagging keeps a
+// submission; setting Online or Offline reopens it.
+func (s *UserSession) setStatusBy(status UStatusEnum, actor string) {
+	if status == Flagged && s.ActiveStatus != Flagged {
+		s.Flags = append(s.Flags, FlagRecord{At: now(), Reason: "flagged by admin", By: actor})
+	}
+	switch {
+	case status == Submitted && s.SubmittedAt == nil:
+		at := now()
+		s.SubmittedAt = &at
+	case status == Online || status == Offline:
+		s.SubmittedAt = nil
+	}
+	s.ActiveStatus = status
+}
+
+// checkTabSwitches applies the tab switch trigger after a blur. Caller holds mu.
+func (r *Room) checkTabSwitches(idx int, at time.Time) bool {
+	if !r.flagPolicy().TabSwitches {
+		return false
+	}
+	threshold, window := r.blurLimits()
+	if recentBlurs(r.Students[idx].FocusEvents, window, at) < threshold {
+		return false
+	}
+	return r.autoFlag(idx, "tab_switches", fmt.Sprintf("%d tab switches within %v", threshold, window))
+}
+
+// checkSharedIP applies the shared IP trigger to a student who just joined. Caller holds mu.
+func (r *Room) checkSharedIP(idx int) bool {
+	if !r.flagPolicy().SharedIP {
+		return false
+	}
+	ip := remoteHost(r.Students[idx].IpAddress)
+	for i, s := range r.Students {
+		if i != idx && remoteHost(s.IpAddress) == ip {
+			return r.autoFlag(idx, "shared_ip", "shares IP address "+ip+" with "+s.UserID)
+		}
+	}
+	return false
+}
+
+// checkForbiddenApps applies the forbidden app trigger to a student's scan. Caller holds mu.
+func (r *Room) checkForbiddenApps(idx int, result ScanResult) bool {
+	if !r.flagPolicy().ForbiddenApps || !result.ForbiddenFound {
+		return false
+	}
+	return r.autoFlag(idx, "forbidden_apps", fmt.Sprintf("forbidden apps running: %v", result.Processes))
+}
+
+// checkMissedScans applies the missed scan trigger to an Active room's
+// Online students. A student is measured from their last scan, or from the
+// later of the exam start and their join before the first one, and is acted
+// on once until their next scan clears ScanOverdue. It returns how many
+// students were flagged and whether any changed. Caller holds mu.
+func (r *Room) checkMissedScans(t time.Time) (int, bool) {
+	action := r.flagPolicy().MissedScans
+	if action == "" {
+		return 0, false
+	}
+	limit := r.scanInterval() + missedScanGrace
+	flagged, changed := 0, false
+	for i := range r.Students {
+		s := &r.Students[i]
+		if s.ActiveStatus != Online || s.ScanOverdue {
+			continue
+		}
+		since := r.StartTime
+		if s.JoinedAt.After(since) {
+			since = s.JoinedAt
+		}
+		if s.LastScan != nil {
+			since = *s.LastScan
+		}
+		if t.Sub(since) <= limit {
+			continue
+		}
+		s.ScanOverdue = true
+		changed = true
+		if action == "flag" && r.autoFlag(i, "missed_scans", fmt.Sprintf("no scan for %v", t.Sub(since).Round(time.Second))) {
+			flagged++
+			continue
+		}
+		broadcastStudent(r.ID, *s)
+	}
+	return flagged, changed
+}
+
+// networkLossThreshold is the share of an Active room's Online students
+// that must go silent together for the room to move to NetworkLoss instead
+// of each student being flagged. Zero disables outage detection.
+var networkLossThreshold = 0.5
+
+// networkLossMinStudents keeps a couple of dropouts in a small room from
+// looking like a venue-wide outage
+const networkLossMinStudents = 3
+
+// defaultOfflineGrace is how long an Online student of an Active room may go
+// without a ping before being marked Offline. A room's OfflineGrace
+// overrides it.
+var defaultOfflineGrace = 3 * heartbeatInterval
+
+// offlineGrace returns the room's grace period, or the global default when unset
+func (r *Room) offlineGrace() time.Duration {
+	if r.OfflineGrace > 0 {
+		return r.OfflineGrace.Std()
+	}
+	return defaultOfflineGrace
+}
+
+// reachable reports whether a student is still expected to ping: Online, or
+// marked Offline by the heartbeat monitor rather than removed by an admin.
+// Caller holds mu.
+func (r *Room) reachable(s UserSession) bool {
+	return s.ActiveStatus == Online || (s.ActiveStatus == Offline && !r.studentBanned(s))
+}
+
+// checkNetworkLoss moves an Active room to NetworkLoss when enough of its
+// Online students stop pinging at once, and back to Active once they resume.
+// Rooms put in NetworkLoss by an admin are left alone. It reports whether
+// the room changed. Caller holds mu.
+func (r *Room) checkNetworkLoss(t time.Time) bool {
+	online, silent := 0, 0
+	for _, s := range r.Students {
+		if !r.reachable(s) {
+			continue
+		}
+		online++
+		if t.Sub(s.LastPing) > 2*heartbeatInterval {
+			silent++
+		}
+	}
+	outage := networkLossThreshold > 0 && online >= networkLossMinStudents &&
+		float64(silent) >= networkLossThreshold*float64(online)
+
+	switch {
+	case r.ActiveStatus == Active && outage:
+		r.ActiveStatus = NetworkLoss
+		r.NetworkLossSince = &t
+		log.Printf("Room %s: %d of %d students stopped pinging, marking NetworkLoss", r.ID, silent, online)
+	case r.ActiveStatus == NetworkLoss && r.NetworkLossSince != nil && !outage:
+		r.ActiveStatus = Active
+		r.NetworkLossSince = nil
+		log.Printf("Room %s: pings resumed, back to Active", r.ID)
+	default:
+		return false
+	}
+	broadcastUpdate(r.ID, "ROOM_UPDATE", r.publicView())
+	broadcastRoomList()
+	return true
+}
+
+// refreshConnected counts a student's open socket as a heartbeat, so either
+// the socket or HTTP pings keep them Online. Caller holds mu.
+func (r *Room) refreshConnected(connected map[string]bool) {
+	for _, s := range r.Students {
+		if connected[s.ID] {
+			r.recordPing(s.ID)
+		}
+	}
+}
+
+// checkMissedHeartbeats detects venue-wide outages, then flags every student
+// of an Active room who has missed the policy's number of heartbeats, marks
+// Offline those silent for longer than the room's offline grace and applies
+// the missed scan trigger. Students with an open socket count as
+// heartbeating. It returns how many students were flagged.
+func checkMissedHeartbeats() int {
+	var connected map[string]bool
+	if wsHub != nil {
+		connected = wsHub.connectedSessions()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	flagged := 0
+	var changed []string
+	t := now()
+	for _, room := range rooms {
+		if room.ActiveStatus != Active && room.ActiveStatus != NetworkLoss {
+			continue
+		}
+		room.refreshConnected(connected)
+		roomChanged := room.checkNetworkLoss(t)
+		// Nobody is flagged or marked Offline for an outage that is not their fault
+		if room.ActiveStatus != Active {
+			if roomChanged {
+				changed = append(changed, room.ID)
+			}
+			continue
+		}
+		if missed := room.flagPolicy().MissedHeartbeats; missed > 0 {
+			limit := time.Duration(missed) * heartbeatInterval
+			for i, s := range room.Students {
+				if room.reachable(s) && t.Sub(s.LastPing) > limit {
+					if room.autoFlag(i, "missed_heartbeats", fmt.Sprintf("missed %d heartbeats", missed)) {
+						flagged++
+						roomChanged = true
+					}
+				}
+			}
+		}
+		grace := room.offlineGrace()
+		for i, s := range room.Students {
+			if s.ActiveStatus == Online && t.Sub(s.LastPing) > grace {
+				room.Students[i].ActiveStatus = Offline
+				broadcastStudent(room.ID, room.Students[i])
+				roomChanged = true
+			}
+		}
+		if n, scansChanged := room.checkMissedScans(t); scansChanged {
+			flagged += n
+			roomChanged = true
+		}
+		if roomChanged {
+			changed = append(changed, room.ID)
+		}
+	}
+	if len(changed) > 0 {
+		requestSave(changed...)
+	}
+	return flagged
+}
+
+// runHeartbeatMonitor periodically applies the missed heartbeat trigger
+func runHeartbeatMonitor() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkMissedHeartbeats()
+	}
+}
+
+// ClearFlagHandler returns a reviewed student from Flagged to Online, or to
+// Submitted when they already handed in answers. The flag history is kept
+// and marked as cleared.
+func ClearFlagHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		UserID   string `json:"user_id"`
+		Note     string `json:"note"` // Optional, e.g. "false positive: screen reader"
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	idx := -1
+	for i, s := range room.Students {
+		if s.UserID == req.UserID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		writeError(w, errUserNotFound)
+		return
+	}
+
+	student := &room.Students[idx]
+	if student.ActiveStatus != Flagged {
+		writeJSONError(w, http.StatusConflict, codeNotFlagged, "Student is not flagged")
+		return
+	}
+
+	student.ActiveStatus = Online
+	if student.SubmittedAt != nil {
+		student.ActiveStatus = Submitted
+	}
+	cleared := now()
+	for i := range student.Flags {
+		if student.Flags[i].ClearedAt == nil {
+			student.Flags[i].ClearedAt = &cleared
+			student.Flags[i].ClearedBy = actor
+			student.Flags[i].Note = req.Note
+		}
+	}
+	student.updateRisk()
+	room.audit(actor, "clear_flag", req.UserID)
+
+	broadcastStudent(req.RoomID, *student)
+	requestSave(req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Flag cleared",
+		"status":  student.ActiveStatus.String(),
+	})
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setFlagPolicy replaces the room's auto-flag policy
+func setFlagPolicy(t *testing.T, roomID, adminKey, policy string) {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "flag_policy": ` + policy + `}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// lastFlag returns the student's most recent flag record
+func lastFlag(roomID, userID string) (FlagRecord, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range rooms[roomID].Students {
+		if s.UserID == userID && len(s.Flags) > 0 {
+			return s.Flags[len(s.Flags)-1], true
+		}
+	}
+	return FlagRecord{}, false
+}
+
+func TestTabSwitchTriggerCanBeDisabled(t *testing.T) {
+	roomID := createTestRoom(t, "policy-tab")
+	sessionID, token := joinTestRoom(t, roomID, "tabber", "REG1200")
+	setFlagPolicy(t, roomID, "policy-tab", `{"tab_switches": false}`)
+
+	for i := 0; i < defaultBlurThreshold+2; i++ {
+		postFocusEvent(t, roomID, sessionID, token, "blur")
+	}
+	if status := studentStatus(roomID, "tabber"); status != Online {
+		t.Fatalf("Expected no flag with tab_switches disabled, got %v", status)
+	}
+
+	setFlagPolicy(t, roomID, "policy-tab", `{"tab_switches": true}`)
+	postFocusEvent(t, roomID, sessionID, token, "blur")
+	if status := studentStatus(roomID, "tabber"); status != Flagged {
+		t.Fatalf("Expected Flagged once enabled, got %v", status)
+	}
+	if flag, ok := lastFlag(roomID, "tabber"); !ok || flag.By != autoFlagger {
+		t.Fatalf("Expected an automatic flag record, got %+v", flag)
+	}
+}
+
+func TestSharedIPTrigger(t *testing.T) {
+	roomID := createTestRoom(t, "policy-ip")
+	// httptest requests all come from the same address
+	joinTestRoom(t, roomID, "first", "REG1210")
+	joinTestRoom(t, roomID, "second", "REG1211")
+	if status := studentStatus(roomID, "second"); status != Online {
+		t.Fatalf("Expected the trigger to be off by default, got %v", status)
+	}
+
+	setFlagPolicy(t, roomID, "policy-ip", `{"shared_ip": true}`)
+	joinTestRoom(t, roomID, "third", "REG1212")
+	if status := studentStatus(roomID, "third"); status != Flagged {
+		t.Fatalf("Expected a shared IP to flag the new student, got %v", status)
+	}
+	if status := studentStatus(roomID, "first"); status != Online {
+		t.Fatalf("Expected the earlier student to stay Online, got %v", status)
+	}
+}
+
+func TestForbiddenAppTrigger(t *testing.T) {
+	prev := runCommand
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte(samplePs), nil
+	}
+	t.Cleanup(func() { runCommand = prev })
+
+	roomID := createTestRoom(t, "policy-scan")
+	sessionID, token := joinTestRoom(t, roomID, "scanned", "REG1220")
+	scan := func() {
+		rr := httptest.NewRecorder()
+		checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan?room_id="+roomID+"&user_session_id="+sessionID+"&session_token="+token, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Scan returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	scan()
+	if status := studentStatus(roomID, "scanned"); status != Online {
+		t.Fatalf("Expected the trigger to be off by default, got %v", status)
+	}
+
+	setFlagPolicy(t, roomID, "policy-scan", `{"forbidden_apps": true}`)
+	scan()
+	if status := studentStatus(roomID, "scanned"); status != Flagged {
+		t.Fatalf("Expected forbidden apps to flag the student, got %v", status)
+	}
+
+	rr := httptest.NewRecorder()
+	checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan?room_id="+roomID+"&user_session_id="+sessionID+"&session_token=forged", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a forged token, got %d", rr.Code)
+	}
+}
+
+func TestMissedHeartbeatTrigger(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "policy-hb")
+	joinTestRoom(t, roomID, "silent", "REG1230")
+	setFlagPolicy(t, roomID, "policy-hb", `{"missed_heartbeats": 3}`)
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Active
+	mu.Unlock()
+
+	advance(3 * heartbeatInterval)
+	checkMissedHeartbeats()
+	if status := studentStatus(roomID, "silent"); status != Online {
+		t.Fatalf("Expected no flag at exactly the limit, got %v", status)
+	}
+
+	advance(time.Second)
+	checkMissedHeartbeats()
+	if status := studentStatus(roomID, "silent"); status != Flagged {
+		t.Fatalf("Expected missed heartbeats to flag the student, got %v", status)
+	}
+}
+
+func TestClearFlag(t *testing.T) {
+	roomID := createTestRoom(t, "clear-key")
+	joinTestRoom(t, roomID, "reviewed", "REG1240")
+	clear := func() *httptest.ResponseRecorder {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "clear-key", "user_id": "reviewed", "note": "false positive"}`)
+		rr := httptest.NewRecorder()
+		ClearFlagHandler(rr, httptest.NewRequest("POST", "/admin/clear-flag", bytes.NewBuffer(body)))
+		return rr
+	}
+
+	if rr := clear(); rr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 for a student who is not flagged, got %d", rr.Code)
+	}
+
+	if err := updateUserStatus(roomID, "clear-key", "reviewed", Flagged); err != nil {
+		t.Fatalf("Flagging failed: %v", err)
+	}
+	if rr := clear(); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if status := studentStatus(roomID, "reviewed"); status != Online {
+		t.Fatalf("Expected Online after clearing, got %v", status)
+	}
+
+	// The history survives, marked as reviewed
+	flag, ok := lastFlag(roomID, "reviewed")
+	if !ok || flag.By != ownerLabel || flag.ClearedAt == nil || flag.ClearedBy != ownerLabel || flag.Note != "false positive" {
+		t.Fatalf("Expected a cleared flag record, got %+v", flag)
+	}
+}
+
+func TestClearFlagRestoresSubmission(t *testing.T) {
+	roomID := createTestRoom(t, "clear-submit")
+	sessionID, token := joinTestRoom(t, roomID, "blank", "REG1241")
+	joinTestRoom(t, roomID, "forced", "REG1242")
+
+	// Submitted with no answers at all
+	submit := func() int {
+		body := `{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `", "answers": null}`
+		rr := httptest.NewRecorder()
+		SubmitHandler(rr, httptest.NewRequest("POST", "/submit", bytes.NewBufferString(body)))
+		return rr.Code
+	}
+	if code := submit(); code != http.StatusOK {
+		t.Fatalf("Submit returned %d", code)
+	}
+	// And handed in by an admin, without answers either
+	if err := updateUserStatus(roomID, "clear-submit", "forced", Submitted); err != nil {
+		t.Fatalf("Force submit failed: %v", err)
+	}
+
+	for _, user := range []string{"blank", "forced"} {
+		if err := updateUserStatus(roomID, "clear-submit", user, Flagged); err != nil {
+			t.Fatalf("Flagging %s failed: %v", user, err)
+		}
+		rr := httptest.NewRecorder()
+		ClearFlagHandler(rr, httptest.NewRequest("POST", "/admin/clear-flag", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "clear-submit", "user_id": "`+user+`"}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Clearing %s returned %d: %s", user, rr.Code, rr.Body.String())
+		}
+		if status := studentStatus(roomID, user); status != Submitted {
+			t.Fatalf("Expected %s back to Submitted, got %v", user, status)
+		}
+	}
+
+	// Flagging a submitted student does not let them submit again
+	if err := updateUserStatus(roomID, "clear-submit", "blank", Flagged); err != nil {
+		t.Fatalf("Flagging failed: %v", err)
+	}
+	if code := submit(); code != http.StatusConflict {
+		t.Fatalf("Expected 409 resubmitting while flagged, got %d", code)
+	}
+}
+
+// pingTestRoom sends a heartbeat for the session
+func pingTestRoom(t *testing.T, roomID, sessionID, token string) {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + sessionID + `", "session_token": "` + token + `"}`)
+	rr := httptest.NewRecorder()
+	PingHandler(rr, httptest.NewRequest("POST", "/ping", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Ping returned %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMassDisconnectMarksNetworkLoss(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "policy-outage")
+	var sessions [][2]string
+	for i, user := range []string{"venue1", "venue2", "venue3", "venue4"} {
+		sessionID, token := joinTestRoom(t, roomID, user, []string{"REG1280", "REG1281", "REG1282", "REG1283"}[i])
+		sessions = append(sessions, [2]string{sessionID, token})
+	}
+	setFlagPolicy(t, roomID, "policy-outage", `{"missed_heartbeats": 1}`)
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Active
+	mu.Unlock()
+	roomStatus := func() StatusEnum {
+		mu.RLock()
+		defer mu.RUnlock()
+		return rooms[roomID].ActiveStatus
+	}
+
+	// Three of four stop pinging together
+	advance(2*heartbeatInterval + time.Second)
+	pingTestRoom(t, roomID, sessions[0][0], sessions[0][1])
+	if flagged := checkMissedHeartbeats(); flagged != 0 {
+		t.Fatalf("Expected nobody flagged during an outage, got %d", flagged)
+	}
+	if status := roomStatus(); status != NetworkLoss {
+		t.Fatalf("Expected NetworkLoss, got %v", status)
+	}
+	for _, user := range []string{"venue2", "venue3", "venue4"} {
+		if status := studentStatus(roomID, user); status != Online {
+			t.Fatalf("Expected %s to stay Online, got %v", user, status)
+		}
+	}
+
+	// Connectivity returns
+	for _, s := range sessions {
+		pingTestRoom(t, roomID, s[0], s[1])
+	}
+	checkMissedHeartbeats()
+	if status := roomStatus(); status != Active {
+		t.Fatalf("Expected Active once pings resume, got %v", status)
+	}
+}
+
+func TestOfflineGracePerRoom(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	strict := createTestRoom(t, "grace-strict")
+	lenient := createTestRoom(t, "grace-lenient")
+	joinTestRoom(t, strict, "quick", "REG1290")
+	joinTestRoom(t, lenient, "patient", "REG1291")
+
+	body := []byte(`{"room_id": "` + strict + `", "admin_key": "grace-strict", "offline_grace": "20s"}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.Lock()
+	rooms[strict].ActiveStatus = Active
+	rooms[lenient].ActiveStatus = Active
+	mu.Unlock()
+
+	// Past the room's own grace but inside the global default
+	advance(21 * time.Second)
+	checkMissedHeartbeats()
+	if status := studentStatus(strict, "quick"); status != Offline {
+		t.Fatalf("Expected the room's grace to apply, got %v", status)
+	}
+	if status := studentStatus(lenient, "patient"); status != Online {
+		t.Fatalf("Expected the global default to apply, got %v", status)
+	}
+
+	advance(defaultOfflineGrace)
+	checkMissedHeartbeats()
+	if status := studentStatus(lenient, "patient"); status != Offline {
+		t.Fatalf("Expected Offline past the global default, got %v", status)
+	}
+}
+
+// scanOverdue reports whether the student is marked ScanOverdue
+func scanOverdue(roomID, userID string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range rooms[roomID].Students {
+		if s.UserID == userID {
+			return s.ScanOverdue
+		}
+	}
+	return false
+}
+
+func TestMissedScans(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC))
+	notify := createTestRoom(t, "scans-notify")
+	strict := createTestRoom(t, "scans-flag")
+	scanned, _ := joinTestRoom(t, notify, "scanned", "REG1380")
+	silent, _ := joinTestRoom(t, notify, "silent", "REG1381")
+	joinTestRoom(t, strict, "disabled", "REG1382")
+	setFlagPolicy(t, notify, "scans-notify", `{"missed_scans": "notify"}`)
+	setFlagPolicy(t, strict, "scans-flag", `{"missed_scans": "flag"}`)
+	mu.Lock()
+	for _, id := range []string{notify, strict} {
+		rooms[id].ActiveStatus = Active
+		rooms[id].StartTime = now()
+		rooms[id].OfflineGrace = Duration(time.Hour) // Keep the students Online without pings
+	}
+	mu.Unlock()
+	limit := defaultScanInterval + missedScanGrace
+	// Nobody pings here, which would otherwise look like an outage
+	prev := networkLossThreshold
+	networkLossThreshold = 0
+	t.Cleanup(func() { networkLossThreshold = prev })
+
+	advance(20 * time.Second)
+	applyScanPolicy(notify, scanned, ScanResult{})
+
+	// Past the limit since the start, but not since the first student's scan
+	advance(limit - 19*time.Second)
+	late, _ := joinTestRoom(t, notify, "late", "REG1383")
+	checkMissedHeartbeats()
+	if !scanOverdue(notify, "silent") || studentStatus(notify, "silent") != Online {
+		t.Fatalf("Expected the silent student marked overdue but left Online")
+	}
+	if scanOverdue(notify, "scanned") || scanOverdue(notify, "late") {
+		t.Fatalf("Expected students with a recent scan or join left alone")
+	}
+	if studentStatus(strict, "disabled") != Flagged {
+		t.Fatalf("Expected the flag policy to flag the silent student, got %v", studentStatus(strict, "disabled"))
+	}
+	if f, _ := lastFlag(strict, "disabled"); f.By != autoFlagger {
+		t.Errorf("Unexpected flag record %+v", f)
+	}
+
+	// The next scan clears the mark, and the scanned student falls overdue in turn
+	applyScanPolicy(notify, silent, ScanResult{})
+	advance(20 * time.Second)
+	checkMissedHeartbeats()
+	if scanOverdue(notify, "silent") || !scanOverdue(notify, "scanned") || scanOverdue(notify, "late") {
+		t.Errorf("Unexpected overdue marks: silent %v, scanned %v, late %v",
+			scanOverdue(notify, "silent"), scanOverdue(notify, "scanned"), scanOverdue(notify, "late"))
+	}
+	applyScanPolicy(notify, late, ScanResult{})
+
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+notify+`", "admin_key": "scans-notify", "flag_policy": {"missed_scans": "kick"}}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown missed_scans action to be rejected, got %d", rr.Code)
+	}
+}
+
+// fireTrigger records a trigger against the student and returns their risk
+func fireTrigger(roomID, userID, trigger string) (recorded bool, s UserSession) {
+	mu.Lock()
+	defer mu.Unlock()
+	room := rooms[roomID]
+	for i := range room.Students {
+		if room.Students[i].UserID == userID {
+			recorded = room.autoFlag(i, trigger, trigger+" fired")
+			return recorded, room.Students[i]
+		}
+	}
+	return false, UserSession{}
+}
+
+func TestRiskScoreAccumulates(t *testing.T) {
+	roomID := createTestRoom(t, "risk-key")
+	joinTestRoom(t, roomID, "rita", "REG1300")
+	setFlagPolicy(t, roomID, "risk-key", `{"risk_threshold": 5}`)
+
+	steps := []struct {
+		trigger  string
+		recorded bool
+		score    int
+		level    string
+		status   UStatusEnum
+	}{
+		{"tab_switches", true, 1, "low", Online},
+		{"tab_switches", false, 1, "low", Online}, // Counts once until cleared
+		{"shared_ip", true, 4, "medium", Online},
+		{"forbidden_apps", true, 14, "high", Flagged},
+		{"remote_access", true, 24, "high", Flagged}, // Still adds up once Flagged
+	}
+	for _, step := range steps {
+		recorded, s := fireTrigger(roomID, "rita", step.trigger)
+		if recorded != step.recorded || s.RiskScore != step.score || s.RiskLevel != step.level || s.ActiveStatus != step.status {
+			t.Fatalf("After %s expected recorded=%v score %d %q %v, got %v %d %q %v",
+				step.trigger, step.recorded, step.score, step.level, step.status, recorded, s.RiskScore, s.RiskLevel, s.ActiveStatus)
+		}
+	}
+	if f, _ := lastFlag(roomID, "rita"); f.Trigger != "remote_access" || f.Severity != "high" || f.Points != 10 {
+		t.Fatalf("Expected the flag to carry its trigger and severity, got %+v", f)
+	}
+
+	// Clearing the flag clears the risk, and triggers count again
+	rr := httptest.NewRecorder()
+	ClearFlagHandler(rr, httptest.NewRequest("POST", "/admin/clear-flag", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "risk-key", "user_id": "rita"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ClearFlag returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if recorded, s := fireTrigger(roomID, "rita", "tab_switches"); !recorded || s.RiskScore != 1 || s.ActiveStatus != Online {
+		t.Fatalf("Expected a fresh score of 1 after clearing, got %v %d %v", recorded, s.RiskScore, s.ActiveStatus)
+	}
+}
+
+func TestRiskThresholdFlipsFlagged(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		trigger string
+		flagged bool
+	}{
+		{"default threshold flags on any trigger", `{}`, "tab_switches", true},
+		{"low stays under a raised threshold", `{"risk_threshold": 3}`, "tab_switches", false},
+		{"medium reaches it", `{"risk_threshold": 3}`, "shared_ip", true},
+		{"a room can raise a trigger's severity", `{"risk_threshold": 10, "severities": {"tab_switches": "high"}}`, "tab_switches", true},
+		{"or lower it", `{"risk_threshold": 10, "severities": {"forbidden_apps": "medium"}}`, "forbidden_apps", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomID := createTestRoom(t, "threshold-key")
+			joinTestRoom(t, roomID, "tess", "REG1310")
+			setFlagPolicy(t, roomID, "threshold-key", tt.policy)
+			if _, s := fireTrigger(roomID, "tess", tt.trigger); (s.ActiveStatus == Flagged) != tt.flagged {
+				t.Fatalf("Expected flagged=%v, got %v with score %d", tt.flagged, s.ActiveStatus, s.RiskScore)
+			}
+		})
+	}
+
+	roomID := createTestRoom(t, "threshold-check")
+	for _, policy := range []string{`{"risk_threshold": -1}`, `{"severities": {"tab_switches": "extreme"}}`, `{"severities": {"dancing": "low"}}`} {
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "threshold-check", "flag_policy": `+policy+`}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d", policy, rr.Code)
+		}
+	}
+}
+
+func TestRiskHiddenFromPublicViews(t *testing.T) {
+	roomID := createTestRoom(t, "hidden-risk")
+	_, token := joinTestRoom(t, roomID, "hana", "REG1320")
+	_, dial := startTestHub(t)
+	watcher := dial()
+	watcher.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, watcher, "ACK", "subscribe_room")
+	watcher.ReadMessage() // The snapshot
+
+	fireTrigger(roomID, "hana", "forbidden_apps")
+	mu.RLock()
+	broadcastStudent(roomID, rooms[roomID].Students[0])
+	mu.RUnlock()
+	watcher.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, delta, err := watcher.ReadMessage()
+	if err != nil || !bytes.Contains(delta, []byte("ROOM_DELTA")) {
+		t.Fatalf("Expected a ROOM_DELTA, got %s (err %v)", delta, err)
+	}
+
+	getRoom := func(query string) []byte {
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID+query, nil))
+		return rr.Body.Bytes()
+	}
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", "user_id": "hana", "session_token": "`+token+`"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Rejoin returned %d: %s", rr.Code, rr.Body.String())
+	}
+	for name, body := range map[string][]byte{"ROOM_DELTA": delta, "/get-room": getRoom(""), "the rejoin": rr.Body.Bytes()} {
+		for _, field := range []string{"risk_score", "risk_level", `"points"`, `"severity"`} {
+			if bytes.Contains(body, []byte(field)) {
+				t.Errorf("%s exposes %s: %s", name, field, body)
+			}
+		}
+	}
+	if body := getRoom("&admin_key=hidden-risk"); !bytes.Contains(body, []byte(`"risk_score":10`)) {
+		t.Errorf("Expected the admin to see the risk score: %s", body)
+	}
+}
@@ -2,129 +2,859 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	// "sync"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-const (
+// HubConfig tunes websocket timing and sizes
+type HubConfig struct {
 	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
+	WriteWait time.Duration
 
 	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
+	PongWait time.Duration
 
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	// Send pings to peer with this period. Must be less than PongWait.
+	PingPeriod time.Duration
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
-)
+	// Maximum message size allowed from peer. Admin commands carry the admin
+	// key, room and user IDs, so this must comfortably exceed a few hundred
+	// bytes; every connection may buffer up to this much, so keep it bounded.
+	MaxMessageSize int64
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	// Allow all origins for this demo
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	// Upgrader I/O buffer sizes.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Negotiate permessage-deflate with clients that support it.
+	EnableCompression bool
+
+	// Outbound messages queued per client before it is considered too slow.
+	SendBufferSize int
+
+	// Rooms a single client may watch at once; "all" does not count.
+	MaxRoomSubscriptions int
+
+	// Recent broadcasts kept per target so a reconnecting client can resume.
+	ReplayBufferSize int
+
+	// How long a broadcast waits on a full send buffer before dropping the
+	// client. Zero drops immediately; the wait stalls every other client of
+	// the hub, so keep it short. Handlers never wait on it, see publish.
+	SlowClientGrace time.Duration
+}
+
+// defaultHubConfig returns the settings used in production
+func defaultHubConfig() HubConfig {
+	pongWait := 60 * time.Second
+	return HubConfig{
+		WriteWait:            10 * time.Second,
+		PongWait:             pongWait,
+		PingPeriod:           (pongWait * 9) / 10,
+		MaxMessageSize:       8192,
+		ReadBufferSize:       1024,
+		WriteBufferSize:      1024,
+		EnableCompression:    true,
+		SendBufferSize:       256,
+		MaxRoomSubscriptions: 20,
+		ReplayBufferSize:     100,
+		SlowClientGrace:      0,
+	}
+}
+
+// adminStatusActions maps admin websocket commands to the student status they set.
+// "update_status" takes the status from the command itself and is refused
+// without one, so its entry here is never used.
+var adminStatusActions = map[string]UStatusEnum{
+	"flag_student":  Flagged,
+	"force_submit":  Submitted,
+	"update_status": Online,
 }
 
+var (
+	errRoomIDRequired = errors.New("room_id is required")
+	errStatusRequired = errors.New("status is required and must be a student status")
+)
+
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub *Hub
 
-	// The websocket connection.
+	// The websocket connection; nil for SSE clients.
 	conn *websocket.Conn
 
 	// Buffered channel of outbound messages.
 	send chan []byte
 
-	// Active subscriptions
+	// Active subscriptions, owned by the hub goroutine
 	subs map[string]bool // "all" or "room_ID"
+
+	// Who the client proved to be with a hello, owned by the hub goroutine
+	identity clientIdentity
+}
+
+// clientIdentity is the student session or room admin behind a client.
+// Exactly one of SessionID and Admin is set once a hello succeeds.
+type clientIdentity struct {
+	RoomID    string
+	SessionID string // Students
+	Admin     string // Admin label, for admins
+}
+
+// subscription asks the hub to add or remove one of a client's targets.
+// An unsubscribe with an empty target removes every subscription.
+type subscription struct {
+	client     *Client
+	action     string // The command being acknowledged
+	target     string
+	add        bool
+	resumeFrom uint64    // Replay buffered broadcasts after this sequence number
+	applied    chan bool // Optional; told whether the change was accepted
+}
+
+// directMessage is delivered to a single client rather than a target
+type directMessage struct {
+	client  *Client
+	message Message
+}
+
+// identification binds a client to the identity it proved with a hello
+type identification struct {
+	client   *Client
+	identity clientIdentity
+	applied  chan bool // Told whether the client was still connected
+}
+
+// Presence lists who has a socket open to a room
+type Presence struct {
+	Students []string `json:"students"` // User session IDs
+	Admins   int      `json:"admins"`
+}
+
+// presenceQuery asks the hub who is connected to a room
+type presenceQuery struct {
+	roomID string
+	reply  chan Presence
+}
+
+// sessionMessage is delivered to every client of one student session
+type sessionMessage struct {
+	session   string
+	message   Message
+	delivered chan int // Told how many clients it was queued for
+}
+
+// sessionClosure disconnects every client of one student session
+type sessionClosure struct {
+	session string
+	done    chan struct{}
+}
+
+// adminRevocation forgets the admin identities made with one label's key
+type adminRevocation struct {
+	roomID string
+	label  string
+	done   chan struct{}
+}
+
+// CommandResult is the payload of the ACK or NACK sent for every websocket command
+type CommandResult struct {
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// commandReply builds the ACK, or the NACK when err is set, for a command
+func commandReply(action, target string, err error) Message {
+	if err != nil {
+		return Message{Type: "NACK", Payload: CommandResult{Action: action, Error: err.Error()}, Target: target}
+	}
+	return Message{Type: "ACK", Payload: CommandResult{Action: action}, Target: target}
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
 type Hub struct {
+	config   HubConfig
+	upgrader websocket.Upgrader
+
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients.
-	broadcast chan Message
+	// Subscribed clients by target ("all" or roomID), so a broadcast only
+	// visits the clients that want it.
+	targets map[string]map[*Client]bool
+
+	// Broadcasts waiting for run(). publish only appends to the queue and
+	// signals ready, so handlers broadcasting under mu never wait on the hub
+	// or a slow client.
+	outboxMu sync.Mutex
+	outbox   []Message
+	ready    chan struct{}
 
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Subscribe and unsubscribe requests from clients.
+	subscriptions chan subscription
+
+	// Replies to a single client.
+	direct chan directMessage
+
+	// Clients that said hello, by user session ID, and the requests that
+	// bind them, message them and disconnect them.
+	sessions        map[string]map[*Client]bool
+	identities      chan identification
+	sessionMessages chan sessionMessage
+	sessionClosures chan sessionClosure
+
+	// Requests to forget admin identities whose key no longer works.
+	adminRevocations chan adminRevocation
+
+	// Requests for who is connected to a room, and for every connected
+	// student session.
+	presenceQueries chan presenceQuery
+	sessionQueries  chan chan map[string]bool
+
+	// Sequence number of the last broadcast, and recent broadcasts per target.
+	seq     uint64
+	history map[string]*replayBuffer
+
+	// Snapshot requests for the number of clients subscribed to each target.
+	observerQueries chan chan map[string]int
+
+	// Closed by stop() to make run() return.
+	done chan struct{}
+
+	// Closed by run() once every client has been released.
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// Clients dropped because they could not keep up.
+	dropped atomic.Int64
 }
 
 type Message struct {
-	Type    string      `json:"type"`    // "ROOM_LIST_UPDATE", "ROOM_UPDATE"
-	Payload interface{} `json:"payload"` // The data
-	Target  string      `json:"target"`  // "all" or specific roomID
+	Type    string      `json:"type"`          // "ROOM_LIST_UPDATE", "ROOM_UPDATE"
+	Payload interface{} `json:"payload"`       // The data
+	Target  string      `json:"target"`        // "all" or specific roomID
+	Seq     uint64      `json:"seq,omitempty"` // Set on broadcasts, increasing across all targets
+}
+
+// replayBuffer is a bounded ring of a target's recent broadcasts
+type replayBuffer struct {
+	entries [][]byte
+	seqs    []uint64
+	next    int    // Slot the next broadcast overwrites once full
+	evicted uint64 // Highest sequence number no longer buffered
+}
+
+func (b *replayBuffer) add(seq uint64, msg []byte, size int) {
+	if len(b.entries) < size {
+		b.entries = append(b.entries, msg)
+		b.seqs = append(b.seqs, seq)
+		return
+	}
+	b.evicted = b.seqs[b.next]
+	b.entries[b.next] = msg
+	b.seqs[b.next] = seq
+	b.next = (b.next + 1) % size
+}
+
+// since returns buffered broadcasts after seq, oldest first, and whether any
+// were already evicted
+func (b *replayBuffer) since(seq uint64) ([][]byte, bool) {
+	var out [][]byte
+	for i := range b.entries {
+		j := (b.next + i) % len(b.entries)
+		if b.seqs[j] > seq {
+			out = append(out, b.entries[j])
+		}
+	}
+	return out, seq < b.evicted
 }
 
-func newHub() *Hub {
+func newHub(config HubConfig) *Hub {
 	return &Hub{
-		broadcast:  make(chan Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		config: config,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			EnableCompression: config.EnableCompression,
+			CheckOrigin:       checkWsOrigin,
+		},
+		ready:            make(chan struct{}, 1),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		subscriptions:    make(chan subscription),
+		direct:           make(chan directMessage),
+		identities:       make(chan identification),
+		presenceQueries:  make(chan presenceQuery),
+		sessionQueries:   make(chan chan map[string]bool),
+		sessionMessages:  make(chan sessionMessage),
+		sessionClosures:  make(chan sessionClosure),
+		adminRevocations: make(chan adminRevocation),
+		sessions:         make(map[string]map[*Client]bool),
+		observerQueries:  make(chan chan map[string]int),
+		history:          make(map[string]*replayBuffer),
+		clients:          make(map[*Client]bool),
+		targets:          make(map[string]map[*Client]bool),
+		done:             make(chan struct{}),
+		stopped:          make(chan struct{}),
 	}
 }
 
 func (h *Hub) run() {
+	defer close(h.stopped)
 	for {
 		select {
+		case <-h.done:
+			// Closing send makes each writePump send a close frame and drop the connection
+			for client := range h.clients {
+				h.removeClient(client)
+			}
+			return
 		case client := <-h.register:
 			h.clients[client] = true
+			for target := range client.subs {
+				h.subscribe(client, target)
+			}
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.removeClient(client)
 			}
-		case message := <-h.broadcast:
-			msgBytes, err := json.Marshal(message)
+		case sub := <-h.subscriptions:
+			h.flushOutbox()
+			if _, ok := h.clients[sub.client]; !ok {
+				continue
+			}
+			var err error
+			switch {
+			case sub.add && !h.canSubscribe(sub.client, sub.target):
+				err = fmt.Errorf("cannot watch more than %d rooms at once", h.config.MaxRoomSubscriptions)
+			case sub.add:
+				h.subscribe(sub.client, sub.target)
+			case sub.target == "":
+				for target := range sub.client.subs {
+					h.unsubscribe(sub.client, target)
+				}
+			default:
+				h.unsubscribe(sub.client, sub.target)
+			}
+			h.sendTo(sub.client, commandReply(sub.action, sub.target, err))
+			if sub.applied != nil {
+				sub.applied <- err == nil
+			}
+			if err == nil && sub.add && sub.resumeFrom > 0 {
+				h.replay(sub.client, sub.target, sub.resumeFrom)
+			}
+		case reply := <-h.observerQueries:
+			counts := make(map[string]int, len(h.targets))
+			for target, set := range h.targets {
+				counts[target] = len(set)
+			}
+			reply <- counts
+		case dm := <-h.direct:
+			h.flushOutbox()
+			if _, ok := h.clients[dm.client]; ok {
+				h.sendTo(dm.client, dm.message)
+			}
+		case id := <-h.identities:
+			h.flushOutbox()
+			_, ok := h.clients[id.client]
+			if ok {
+				h.identify(id.client, id.identity)
+				h.sendTo(id.client, commandReply("hello", id.identity.RoomID, nil))
+			}
+			id.applied <- ok
+		case q := <-h.presenceQueries:
+			p := Presence{Students: []string{}}
+			for client := range h.clients {
+				switch who := client.identity; {
+				case who.RoomID != q.roomID:
+				case who.SessionID != "":
+					p.Students = append(p.Students, who.SessionID)
+				case who.Admin != "":
+					p.Admins++
+				}
+			}
+			sort.Strings(p.Students)
+			q.reply <- p
+		case reply := <-h.sessionQueries:
+			connected := make(map[string]bool, len(h.sessions))
+			for session := range h.sessions {
+				connected[session] = true
+			}
+			reply <- connected
+		case sm := <-h.sessionMessages:
+			h.flushOutbox()
+			msgBytes, err := json.Marshal(sm.message)
 			if err != nil {
 				log.Printf("json marshal error: %v", err)
+				sm.delivered <- 0
 				continue
 			}
-
-			for client := range h.clients {
-				shouldSend := false
-				if message.Target == "all" {
-					if client.subs["all"] {
-						shouldSend = true
-					}
+			n := 0
+			for client := range h.sessions[sm.session] {
+				if h.deliver(client, msgBytes) {
+					n++
 				} else {
-					if client.subs[message.Target] {
-						shouldSend = true
-					}
+					h.dropSlowClient(client)
 				}
-
-				// Always send to "subscribe_all" admin clients for list updates?
-				// Actually, if Target is a roomID, admin watching list might not care unless it changes list metadata?
-				// For simplicity:
-				// - "all" targets clients subscribed to "all"
-				// - "roomID" targets clients subscribed to "roomID"
-				// - If a room updates, we might desire to update the list too? Handled by caller sending two messages if needed.
-
-				if shouldSend {
-					select {
-					case client.send <- msgBytes:
-					default:
-						close(client.send)
-						delete(h.clients, client)
-					}
+			}
+			sm.delivered <- n
+		case sc := <-h.sessionClosures:
+			h.flushOutbox()
+			for client := range h.sessions[sc.session] {
+				h.removeClient(client)
+			}
+			close(sc.done)
+		case ar := <-h.adminRevocations:
+			for client := range h.clients {
+				if who := client.identity; who.RoomID == ar.roomID && who.Admin == ar.label {
+					h.forgetIdentity(client)
 				}
 			}
+			close(ar.done)
+		case <-h.ready:
+			h.flushOutbox()
+		}
+	}
+}
+
+// flushOutbox sends every queued broadcast. Anything sent to a single client
+// flushes first, so it never overtakes a broadcast published before it.
+func (h *Hub) flushOutbox() {
+	h.outboxMu.Lock()
+	queued := h.outbox
+	h.outbox = nil
+	h.outboxMu.Unlock()
+	for _, message := range queued {
+		h.broadcastMessage(message)
+	}
+}
+
+// broadcastMessage numbers a message and delivers it to its target
+func (h *Hub) broadcastMessage(message Message) {
+	h.seq++
+	message.Seq = h.seq
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("json marshal error: %v", err)
+		return
+	}
+	h.remember(message.Target, message.Seq, msgBytes)
+
+	// "all" reaches clients subscribed to "all", a roomID reaches clients
+	// subscribed to that room. Callers send two messages when both care.
+	for client := range h.targets[message.Target] {
+		if !h.deliver(client, msgBytes) {
+			h.dropSlowClient(client)
+		}
+	}
+}
+
+// remember buffers a broadcast for clients that resume later
+func (h *Hub) remember(target string, seq uint64, msg []byte) {
+	if h.config.ReplayBufferSize <= 0 {
+		return
+	}
+	buf, ok := h.history[target]
+	if !ok {
+		buf = &replayBuffer{}
+		h.history[target] = buf
+	}
+	buf.add(seq, msg, h.config.ReplayBufferSize)
+}
+
+// replay sends a client the broadcasts for target it missed after seq. When
+// some are no longer buffered it is told to refetch instead.
+func (h *Hub) replay(client *Client, target string, seq uint64) {
+	buf, ok := h.history[target]
+	if !ok {
+		return
+	}
+	missed, gap := buf.since(seq)
+	if gap {
+		h.sendTo(client, Message{Type: "RESYNC_REQUIRED", Target: target})
+		return
+	}
+	for _, msg := range missed {
+		if !h.deliver(client, msg) {
+			h.dropSlowClient(client)
+			return
+		}
+	}
+}
+
+// canSubscribe reports whether target fits within the client's room limit
+func (h *Hub) canSubscribe(client *Client, target string) bool {
+	if target == "all" || client.subs[target] || h.config.MaxRoomSubscriptions <= 0 {
+		return true
+	}
+	rooms := len(client.subs)
+	if client.subs["all"] {
+		rooms--
+	}
+	return rooms < h.config.MaxRoomSubscriptions
+}
+
+func (h *Hub) subscribe(client *Client, target string) {
+	client.subs[target] = true
+	set, ok := h.targets[target]
+	if !ok {
+		set = make(map[*Client]bool)
+		h.targets[target] = set
+	}
+	set[client] = true
+}
+
+func (h *Hub) unsubscribe(client *Client, target string) {
+	delete(client.subs, target)
+	if set, ok := h.targets[target]; ok {
+		delete(set, client)
+		if len(set) == 0 {
+			delete(h.targets, target)
+		}
+	}
+}
+
+// identify records who the client is, replacing any identity from an
+// earlier hello
+func (h *Hub) identify(client *Client, who clientIdentity) {
+	h.forgetIdentity(client)
+	client.identity = who
+	if who.SessionID == "" {
+		return
+	}
+	set, ok := h.sessions[who.SessionID]
+	if !ok {
+		set = make(map[*Client]bool)
+		h.sessions[who.SessionID] = set
+	}
+	set[client] = true
+}
+
+// forgetIdentity removes the client from its session's clients
+func (h *Hub) forgetIdentity(client *Client) {
+	session := client.identity.SessionID
+	if set, ok := h.sessions[session]; ok {
+		delete(set, client)
+		if len(set) == 0 {
+			delete(h.sessions, session)
+		}
+	}
+	client.identity = clientIdentity{}
+}
+
+// removeClient forgets a client and closes its send channel
+func (h *Hub) removeClient(client *Client) {
+	for target := range client.subs {
+		if set, ok := h.targets[target]; ok {
+			delete(set, client)
+			if len(set) == 0 {
+				delete(h.targets, target)
+			}
+		}
+	}
+	h.forgetIdentity(client)
+	delete(h.clients, client)
+	close(client.send)
+}
+
+// deliver queues msg for the client, waiting up to SlowClientGrace if its
+// buffer is full. It reports whether the message was queued.
+func (h *Hub) deliver(client *Client, msg []byte) bool {
+	select {
+	case client.send <- msg:
+		return true
+	default:
+	}
+	if h.config.SlowClientGrace <= 0 {
+		return false
+	}
+	timer := time.NewTimer(h.config.SlowClientGrace)
+	defer timer.Stop()
+	select {
+	case client.send <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// sendTo queues a message for a single client
+func (h *Hub) sendTo(client *Client, message Message) {
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("json marshal error: %v", err)
+		return
+	}
+	if !h.deliver(client, msgBytes) {
+		h.dropSlowClient(client)
+	}
+}
+
+// dropSlowClient disconnects a client whose send buffer stayed full
+func (h *Hub) dropSlowClient(client *Client) {
+	subs := make([]string, 0, len(client.subs))
+	for sub := range client.subs {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+
+	h.removeClient(client)
+	total := h.dropped.Add(1)
+	log.Printf("dropping slow websocket client (subs %v, %d dropped so far)", subs, total)
+}
+
+// stop makes run() return after closing every client, and waits for it to do so.
+// It is safe to call more than once.
+func (h *Hub) stop() {
+	h.stopOnce.Do(func() { close(h.done) })
+	<-h.stopped
+}
+
+// observerCounts returns how many clients are subscribed to each target,
+// or nil once the hub has stopped
+func (h *Hub) observerCounts() map[string]int {
+	reply := make(chan map[string]int, 1)
+	select {
+	case h.observerQueries <- reply:
+		return <-reply
+	case <-h.done:
+		return nil
+	}
+}
+
+// publish queues a message for the hub without waiting for it, dropping
+// it if the hub has stopped. Callers may hold mu.
+func (h *Hub) publish(msg Message) {
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	h.outboxMu.Lock()
+	h.outbox = append(h.outbox, msg)
+	h.outboxMu.Unlock()
+	select {
+	case h.ready <- struct{}{}:
+	default: // A signal is already pending
+	}
+}
+
+// sendToSession queues a message for every client that said hello as the
+// student session. It returns how many there were, none once the hub has stopped.
+func (h *Hub) sendToSession(session string, message Message) int {
+	delivered := make(chan int, 1)
+	select {
+	case h.sessionMessages <- sessionMessage{session: session, message: message, delivered: delivered}:
+		return <-delivered
+	case <-h.done:
+		return 0
+	}
+}
+
+// closeSession disconnects every client that said hello as the student
+// session, after sending what was already published. Call it without mu.
+func (h *Hub) closeSession(session string) {
+	done := make(chan struct{})
+	select {
+	case h.sessionClosures <- sessionClosure{session: session, done: done}:
+		<-done
+	case <-h.done:
+	}
+}
+
+// revokeAdmin forgets the identity of every client that said hello to the
+// room with the admin label's key, once that key stops working. The clients
+// stay connected but must say hello again. Call it without mu.
+func (h *Hub) revokeAdmin(roomID, label string) {
+	done := make(chan struct{})
+	select {
+	case h.adminRevocations <- adminRevocation{roomID: roomID, label: label, done: done}:
+		<-done
+	case <-h.done:
+	}
+}
+
+// presence returns who has said hello to the room, or nothing once the hub has stopped
+func (h *Hub) presence(roomID string) Presence {
+	reply := make(chan Presence, 1)
+	select {
+	case h.presenceQueries <- presenceQuery{roomID: roomID, reply: reply}:
+		return <-reply
+	case <-h.done:
+		return Presence{Students: []string{}}
+	}
+}
+
+// connectedSessions returns the student sessions with a socket that said
+// hello, or nil once the hub has stopped
+func (h *Hub) connectedSessions() map[string]bool {
+	reply := make(chan map[string]bool, 1)
+	select {
+	case h.sessionQueries <- reply:
+		return <-reply
+	case <-h.done:
+		return nil
+	}
+}
+
+// updateSubscription forwards a subscribe or unsubscribe to the hub, which
+// replies once it has been applied. It reports whether the hub accepted it.
+func (c *Client) updateSubscription(action, target string, add bool, resumeFrom uint64) bool {
+	applied := make(chan bool, 1)
+	select {
+	case c.hub.subscriptions <- subscription{client: c, action: action, target: target, add: add, resumeFrom: resumeFrom, applied: applied}:
+	case <-c.hub.done:
+		return false
+	}
+	select {
+	case ok := <-applied:
+		return ok
+	case <-c.hub.done:
+		return false
+	}
+}
+
+// identify asks the hub to record who this client is. It reports whether
+// the hub accepted it.
+func (c *Client) identify(who clientIdentity) bool {
+	applied := make(chan bool, 1)
+	select {
+	case c.hub.identities <- identification{client: c, identity: who, applied: applied}:
+	case <-c.hub.done:
+		return false
+	}
+	select {
+	case ok := <-applied:
+		return ok
+	case <-c.hub.done:
+		return false
+	}
+}
+
+// hello checks the credentials a client presents and records who it is:
+// a student by session token, or an admin by admin key. A student then gets
+// any direct messages that were waiting. It returns the identity, which is
+// empty when the hello was refused.
+func (c *Client) hello(roomID, sessionID, token, adminKey string) clientIdentity {
+	who, err := authenticateHello(roomID, sessionID, token, adminKey)
+	if err != nil {
+		c.reply(commandReply("hello", roomID, err))
+		return clientIdentity{}
+	}
+	if !c.identify(who) {
+		return clientIdentity{}
+	}
+	if who.SessionID != "" {
+		touchSession(who)
+		for _, dm := range takePendingMessages(roomID, sessionID) {
+			c.reply(Message{Type: "DIRECT_MESSAGE", Payload: dm, Target: roomID})
 		}
 	}
+	return who
+}
+
+// authenticateHello resolves a hello's credentials to an identity
+func authenticateHello(roomID, sessionID, token, adminKey string) (clientIdentity, error) {
+	if adminKey == "" {
+		if err := verifySessionToken(roomID, sessionID, token); err != nil {
+			return clientIdentity{}, err
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	room, exists := rooms[roomID]
+	if !exists {
+		return clientIdentity{}, errRoomNotFound
+	}
+	if adminKey != "" {
+		label, ok := room.adminLabel(adminKey)
+		if !ok {
+			return clientIdentity{}, errUnauthorized
+		}
+		return clientIdentity{RoomID: roomID, Admin: label}, nil
+	}
+	if room.sessionBanned(sessionID) {
+		return clientIdentity{}, errBanned
+	}
+	return clientIdentity{RoomID: roomID, SessionID: sessionID}, nil
+}
+
+// touchSession counts traffic on a student's socket as a heartbeat. It
+// returns errBanned once the student has been kicked, and the socket should
+// then be closed.
+func touchSession(who clientIdentity) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if room, exists := rooms[who.RoomID]; exists {
+		if err := room.recordPing(who.SessionID); err == errBanned {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseSession refreshes the LastPing of a student whose socket went away,
+// so the offline grace runs from then. Unlike touchSession it never changes
+// their status: a dropped socket must not bring anyone back Online.
+func releaseSession(who clientIdentity) {
+	mu.Lock()
+	defer mu.Unlock()
+	room, exists := rooms[who.RoomID]
+	if !exists {
+		return
+	}
+	for i, s := range room.Students {
+		if s.ID == who.SessionID {
+			room.Students[i].LastPing = now()
+			return
+		}
+	}
+}
+
+// sendSnapshot sends the client the full room so later ROOM_DELTA messages
+// have something to apply to, followed by the room's last announcement.
+// Called after subscribing, so no delta is missed.
+func (c *Client) sendSnapshot(roomID string) {
+	mu.RLock()
+	room, exists := rooms[roomID]
+	var view Room
+	if exists {
+		view = room.publicView()
+	}
+	mu.RUnlock()
+
+	if !exists {
+		return
+	}
+	c.reply(Message{Type: "ROOM_UPDATE", Payload: view, Target: roomID})
+	if view.LastAnnouncement != nil {
+		c.reply(Message{Type: "ANNOUNCEMENT", Payload: *view.LastAnnouncement, Target: roomID})
+	}
+}
+
+// reply sends a message to this client only. The hub owns the send channel,
+// so the message goes through it.
+func (c *Client) reply(message Message) {
+	select {
+	case c.hub.direct <- directMessage{client: c, message: message}:
+	case <-c.hub.done:
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -132,13 +862,31 @@ func (h *Hub) run() {
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
 func (c *Client) readPump() {
+	// Who the last successful hello identified; the hub keeps its own copy
+	var who clientIdentity
+
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.conn.Close()
+		// The offline grace runs from the moment the socket went away
+		if who.SessionID != "" {
+			releaseSession(who)
+		}
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+
+	cfg := c.hub.config
+	c.conn.SetReadLimit(cfg.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+		if who.SessionID != "" {
+			return touchSession(who) // A kicked student's socket is closed
+		}
+		return nil
+	})
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
@@ -147,20 +895,66 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		
+		// Any traffic from a student's socket shows they are still there,
+		// unless they were kicked
+		if who.SessionID != "" && touchSession(who) != nil {
+			break
+		}
+
 		// Handle Subscription Messages
 		var cmd struct {
-			Action string `json:"action"` // "subscribe_all", "subscribe_room"
-			RoomID string `json:"room_id"`
+			Action   string       `json:"action"` // "subscribe_all", "subscribe_room", "flag_student", ...
+			RoomID   string       `json:"room_id"`
+			AdminKey string       `json:"admin_key"` // Admin commands only
+			UserID   string       `json:"user_id"`   // Admin commands only
+			Status   *UStatusEnum `json:"status"`    // "update_status" only
+
+			// "hello" only: students send their session, admins their
+			// admin_key and room_id
+			UserSessionID string `json:"user_session_id"`
+			SessionToken  string `json:"session_token"`
+
+			// Subscribes only: the last seq the client saw before reconnecting
+			ResumeFrom uint64 `json:"resume_from"`
+		}
+		if err := json.Unmarshal(message, &cmd); err != nil {
+			c.reply(commandReply("", "", fmt.Errorf("malformed command: %v", err)))
+			continue
 		}
-		if err := json.Unmarshal(message, &cmd); err == nil {
-			if cmd.Action == "subscribe_all" {
-				c.subs["all"] = true
-			} else if cmd.Action == "subscribe_room" && cmd.RoomID != "" {
-				c.subs[cmd.RoomID] = true
-			} else if cmd.Action == "unsubscribe_room" && cmd.RoomID != "" {
-				delete(c.subs, cmd.RoomID)
+
+		switch status, isAdmin := adminStatusActions[cmd.Action]; {
+		case cmd.Action == "subscribe_all":
+			c.updateSubscription(cmd.Action, "all", true, cmd.ResumeFrom)
+		case cmd.Action == "subscribe_room" && cmd.RoomID != "":
+			// A resuming client gets the deltas it missed instead
+			if c.updateSubscription(cmd.Action, cmd.RoomID, true, cmd.ResumeFrom) && cmd.ResumeFrom == 0 {
+				c.sendSnapshot(cmd.RoomID)
+			}
+		case cmd.Action == "unsubscribe_room" && cmd.RoomID != "":
+			c.updateSubscription(cmd.Action, cmd.RoomID, false, 0)
+		case cmd.Action == "subscribe_room" || cmd.Action == "unsubscribe_room":
+			c.reply(commandReply(cmd.Action, "", errRoomIDRequired))
+		case cmd.Action == "unsubscribe_all":
+			c.updateSubscription(cmd.Action, "", false, 0)
+		case cmd.Action == "hello":
+			who = c.hello(cmd.RoomID, cmd.UserSessionID, cmd.SessionToken, cmd.AdminKey)
+		case isAdmin:
+			if cmd.Action == "update_status" {
+				// A missing status would decode as Online
+				if cmd.Status == nil || !cmd.Status.Valid() {
+					c.reply(commandReply(cmd.Action, cmd.RoomID, errStatusRequired))
+					continue
+				}
+				status = *cmd.Status
+			}
+			// Goes through the same path as /admin/update-status, which broadcasts the result
+			err := updateUserStatus(cmd.RoomID, cmd.AdminKey, cmd.UserID, status)
+			if err != nil {
+				log.Printf("admin command %s failed: %v", cmd.Action, err)
 			}
+			c.reply(commandReply(cmd.Action, cmd.RoomID, err))
+		default:
+			c.reply(commandReply(cmd.Action, cmd.RoomID, fmt.Errorf("unknown action %q", cmd.Action)))
 		}
 	}
 }
@@ -170,7 +964,8 @@ func (c *Client) readPump() {
 // application ensures that there is at most one writer to a connection by
 // executing all writes from this goroutine.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	cfg := c.hub.config
+	ticker := time.NewTicker(cfg.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -178,30 +973,20 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
 			if !ok {
 				// The hub closed the channel.
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// One frame per message: clients parse each frame as a single JSON
+			// document, so queued messages must not be concatenated.
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -211,16 +996,54 @@ func (c *Client) writePump() {
 
 // serveWs handles websocket requests from the peer.
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), subs: make(map[string]bool)}
-	client.hub.register <- client
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, hub.config.SendBufferSize), subs: make(map[string]bool)}
+	select {
+	case client.hub.register <- client:
+	case <-hub.done:
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
 	go client.readPump()
 }
+
+// RoomObserversHandler reports how many websocket clients are watching a
+// room, and which students and how many admins have said hello to it
+func RoomObserversHandler(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
+		return
+	}
+
+	mu.RLock()
+	_, exists := rooms[roomID]
+	mu.RUnlock()
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+
+	observers := 0
+	presence := Presence{Students: []string{}}
+	if wsHub != nil {
+		observers = wsHub.observerCounts()[roomID]
+		presence = wsHub.presence(roomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"room_id":   roomID,
+		"observers": observers,
+		"presence":  presence,
+	})
+}
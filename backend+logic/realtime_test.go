@@ -0,0 +1,691 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTestHub runs a hub behind a test server and returns a dial function
+func startTestHub(t *testing.T) (*Hub, func() *websocket.Conn) {
+	t.Helper()
+	return startTestHubWithConfig(t, defaultHubConfig())
+}
+
+func startTestHubWithConfig(t *testing.T, config HubConfig) (*Hub, func() *websocket.Conn) {
+	t.Helper()
+	hub := newHub(config)
+	go hub.run()
+
+	prev := wsHub
+	wsHub = hub
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(hub, w, r)
+	}))
+	t.Cleanup(func() {
+		hub.stop()
+		server.Close()
+		wsHub = prev
+	})
+
+	dial := func() *websocket.Conn {
+		t.Helper()
+		url := "ws" + strings.TrimPrefix(server.URL, "http")
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	return hub, dial
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// readReply reads the next message and checks it is the expected ACK or NACK
+func readReply(t *testing.T, conn *websocket.Conn, wantType, action string) CommandResult {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Type    string        `json:"type"`
+		Payload CommandResult `json:"payload"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Read failed waiting for %s of %q: %v", wantType, action, err)
+	}
+	if msg.Type != wantType || msg.Payload.Action != action {
+		t.Fatalf("Expected %s for %q, got %s %+v", wantType, action, msg.Type, msg.Payload)
+	}
+	return msg.Payload
+}
+
+func studentStatus(roomID, userID string) UStatusEnum {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range rooms[roomID].Students {
+		if s.UserID == userID {
+			return s.ActiveStatus
+		}
+	}
+	return -1
+}
+
+func TestAdminWebsocketCommands(t *testing.T) {
+	roomID := createTestRoom(t, "ws-key")
+	joinTestRoom(t, roomID, "ws-student", "REG200")
+	_, dial := startTestHub(t)
+
+	watcher := dial()
+	watcher.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, watcher, "ACK", "subscribe_room")
+	var snapshot Message
+	if err := watcher.ReadJSON(&snapshot); err != nil || snapshot.Type != "ROOM_UPDATE" {
+		t.Fatalf("Expected a ROOM_UPDATE snapshot on subscribe, got %+v (err %v)", snapshot, err)
+	}
+	admin := dial()
+
+	// A wrong key must not change anything
+	admin.WriteJSON(map[string]string{"action": "flag_student", "room_id": roomID, "admin_key": "nope", "user_id": "ws-student"})
+	if reply := readReply(t, admin, "NACK", "flag_student"); reply.Error == "" {
+		t.Fatal("Expected the NACK to explain the failure")
+	}
+	if status := studentStatus(roomID, "ws-student"); status != Online {
+		t.Fatalf("Command with a wrong key changed status to %v", status)
+	}
+
+	admin.WriteJSON(map[string]string{"action": "flag_student", "room_id": roomID, "admin_key": "ws-key", "user_id": "ws-student"})
+	readReply(t, admin, "ACK", "flag_student")
+	if status := studentStatus(roomID, "ws-student"); status != Flagged {
+		t.Fatalf("Expected Flagged after the ACK, got %v", status)
+	}
+
+	watcher.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Type    string    `json:"type"`
+		Payload RoomDelta `json:"payload"`
+	}
+	if err := watcher.ReadJSON(&msg); err != nil || msg.Type != "ROOM_DELTA" {
+		t.Fatalf("Expected ROOM_DELTA broadcast, got %+v (err %v)", msg, err)
+	}
+	if msg.Payload.Student.UserID != "ws-student" || msg.Payload.Student.ActiveStatus != Flagged {
+		t.Fatalf("Expected the flagged student in the delta, got %+v", msg.Payload.Student)
+	}
+
+	admin.WriteJSON(map[string]string{"action": "force_submit", "room_id": roomID, "admin_key": "ws-key", "user_id": "ws-student"})
+	waitFor(t, "student to be submitted", func() bool { return studentStatus(roomID, "ws-student") == Submitted })
+
+	admin.WriteJSON(map[string]interface{}{"action": "update_status", "room_id": roomID, "admin_key": "ws-key", "user_id": "ws-student", "status": Online})
+	waitFor(t, "student to be online", func() bool { return studentStatus(roomID, "ws-student") == Online })
+	readReply(t, admin, "ACK", "force_submit")
+	readReply(t, admin, "ACK", "update_status")
+
+	// A missing status must not quietly decode as Online
+	admin.WriteJSON(map[string]interface{}{"action": "update_status", "room_id": roomID, "admin_key": "ws-key", "user_id": "ws-student", "status": Offline})
+	readReply(t, admin, "ACK", "update_status")
+	for _, status := range []interface{}{nil, 99} {
+		cmd := map[string]interface{}{"action": "update_status", "room_id": roomID, "admin_key": "ws-key", "user_id": "ws-student"}
+		if status != nil {
+			cmd["status"] = status
+		}
+		admin.WriteJSON(cmd)
+		readReply(t, admin, "NACK", "update_status")
+	}
+	if status := studentStatus(roomID, "ws-student"); status != Offline {
+		t.Fatalf("Expected a rejected update_status to leave the student Offline, got %v", status)
+	}
+}
+
+func TestLargeWebsocketMessageAccepted(t *testing.T) {
+	roomID := createTestRoom(t, "big-key")
+	_, dial := startTestHub(t)
+	conn := dial()
+
+	// Well past the old 512 byte limit, which used to drop the connection
+	padding := strings.Repeat("x", 2000)
+	if err := conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID, "note": padding}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// The connection must still be alive and subscribed
+	readReply(t, conn, "ACK", "subscribe_room")
+	broadcastUpdate(roomID, "ROOM_UPDATE", nil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ROOM_UPDATE" {
+		t.Fatalf("Expected ROOM_UPDATE after a large message, got %+v (err %v)", msg, err)
+	}
+}
+
+func TestHubStopClosesClients(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	hub := newHub(defaultHubConfig())
+	runDone := make(chan struct{})
+	go func() {
+		hub.run()
+		close(runDone)
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWs(hub, w, r)
+	}))
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	hub.stop()
+	select {
+	case <-runDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run() did not return after stop")
+	}
+
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Errorf("Client %d: expected a close frame, got %v", i, err)
+		}
+		conn.Close()
+	}
+
+	// Broadcasting after shutdown must not block
+	hub.publish(Message{Type: "ROOM_UPDATE", Target: "all"})
+	hub.stop()
+
+	server.Close()
+	waitFor(t, "client goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+}
+
+func TestSlowClientDropped(t *testing.T) {
+	config := defaultHubConfig()
+	config.SendBufferSize = 1
+	config.SlowClientGrace = 10 * time.Millisecond
+	hub := newHub(config)
+	go hub.run()
+	t.Cleanup(hub.stop)
+
+	// No pumps are attached, so nothing drains the buffer
+	client := &Client{hub: hub, send: make(chan []byte, config.SendBufferSize), subs: map[string]bool{"all": true}}
+	hub.register <- client
+
+	hub.publish(Message{Type: "ROOM_LIST_UPDATE", Target: "all"})
+	hub.publish(Message{Type: "ROOM_LIST_UPDATE", Target: "all"})
+
+	waitFor(t, "the slow client to be dropped", func() bool {
+		return hub.dropped.Load() == 1
+	})
+	if _, ok := <-client.send; !ok {
+		t.Fatal("Expected the first message to stay queued")
+	}
+	if _, ok := <-client.send; ok {
+		t.Fatal("Expected the send channel to be closed after the drop")
+	}
+}
+
+// A handler publishing under mu must not wait out a slow client's grace
+func TestPublishDoesNotWaitForSlowClients(t *testing.T) {
+	config := defaultHubConfig()
+	config.SendBufferSize = 1
+	config.SlowClientGrace = 500 * time.Millisecond
+	hub := newHub(config)
+	go hub.run()
+	t.Cleanup(hub.stop)
+
+	client := &Client{hub: hub, send: make(chan []byte, config.SendBufferSize), subs: map[string]bool{"all": true}}
+	hub.register <- client
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		hub.publish(Message{Type: "ROOM_LIST_UPDATE", Target: "all"})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("publish waited %v on a slow client", elapsed)
+	}
+	waitFor(t, "the slow client to be dropped", func() bool {
+		return hub.dropped.Load() == 1
+	})
+}
+
+// BenchmarkRoomBroadcast measures one room update while many other rooms are watched
+func BenchmarkRoomBroadcast(b *testing.B) {
+	hub := newHub(defaultHubConfig())
+	go hub.run()
+	defer hub.stop()
+
+	const roomCount, clientsPerRoom = 100, 10
+	for i := 0; i < roomCount*clientsPerRoom; i++ {
+		client := &Client{
+			hub:  hub,
+			send: make(chan []byte, 16),
+			subs: map[string]bool{fmt.Sprintf("room%d", i%roomCount): true},
+		}
+		hub.register <- client
+		go func() {
+			for range client.send {
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.publish(Message{Type: "ROOM_UPDATE", Target: "room0"})
+	}
+}
+
+func TestRoomSubscriptionLimit(t *testing.T) {
+	config := defaultHubConfig()
+	config.MaxRoomSubscriptions = 2
+	_, dial := startTestHubWithConfig(t, config)
+	conn := dial()
+
+	// "all" does not count towards the limit
+	conn.WriteJSON(map[string]string{"action": "subscribe_all"})
+	readReply(t, conn, "ACK", "subscribe_all")
+	for _, room := range []string{"room-a", "room-b"} {
+		conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": room})
+		readReply(t, conn, "ACK", "subscribe_room")
+	}
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": "room-c"})
+	if reply := readReply(t, conn, "NACK", "subscribe_room"); !strings.Contains(reply.Error, "2 rooms") {
+		t.Fatalf("Expected the limit in the error, got %q", reply.Error)
+	}
+
+	// Dropping everything frees the slots again
+	conn.WriteJSON(map[string]string{"action": "unsubscribe_all"})
+	readReply(t, conn, "ACK", "unsubscribe_all")
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": "room-c"})
+	readReply(t, conn, "ACK", "subscribe_room")
+
+	broadcastUpdate("all", "ROOM_LIST_UPDATE", nil)
+	broadcastUpdate("room-c", "ROOM_UPDATE", nil)
+
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ROOM_UPDATE" || msg.Target != "room-c" {
+		t.Fatalf("Expected ROOM_UPDATE for room-c only, got %+v (err %v)", msg, err)
+	}
+}
+
+func TestUnknownWebsocketActionNacked(t *testing.T) {
+	_, dial := startTestHub(t)
+	conn := dial()
+
+	conn.WriteJSON(map[string]string{"action": "subscribe_rooom", "room_id": "abc"})
+	if reply := readReply(t, conn, "NACK", "subscribe_rooom"); !strings.Contains(reply.Error, "unknown action") {
+		t.Fatalf("Expected an unknown action error, got %q", reply.Error)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+	readReply(t, conn, "NACK", "")
+
+	conn.WriteJSON(map[string]string{"action": "subscribe_room"})
+	if reply := readReply(t, conn, "NACK", "subscribe_room"); reply.Error != errRoomIDRequired.Error() {
+		t.Fatalf("Expected %q, got %q", errRoomIDRequired, reply.Error)
+	}
+}
+
+func TestRoomObserverCount(t *testing.T) {
+	roomID := createTestRoom(t, "obs-key")
+	_, dial := startTestHub(t)
+
+	observers := func() int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		RoomObserversHandler(rr, httptest.NewRequest("GET", "/room-observers?room_id="+roomID, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Observers int `json:"observers"`
+		}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp.Observers
+	}
+
+	if n := observers(); n != 0 {
+		t.Fatalf("Expected no observers yet, got %d", n)
+	}
+
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn := dial()
+		conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+		readReply(t, conn, "ACK", "subscribe_room")
+		conns = append(conns, conn)
+	}
+	// Watching the list does not count as watching the room
+	lister := dial()
+	lister.WriteJSON(map[string]string{"action": "subscribe_all"})
+	readReply(t, lister, "ACK", "subscribe_all")
+
+	if n := observers(); n != 2 {
+		t.Fatalf("Expected 2 observers, got %d", n)
+	}
+
+	conns[0].Close()
+	waitFor(t, "the closed client to be forgotten", func() bool { return observers() == 1 })
+
+	rr := httptest.NewRecorder()
+	RoomObserversHandler(rr, httptest.NewRequest("GET", "/room-observers?room_id=missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a missing room, got %d", rr.Code)
+	}
+}
+
+func TestResumeReplaysMissedBroadcasts(t *testing.T) {
+	config := defaultHubConfig()
+	config.ReplayBufferSize = 3
+	_, dial := startTestHubWithConfig(t, config)
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": "resume-room"})
+	readReply(t, conn, "ACK", "subscribe_room")
+	broadcastUpdate("resume-room", "ROOM_UPDATE", "first")
+
+	var seen Message
+	if err := conn.ReadJSON(&seen); err != nil || seen.Seq == 0 {
+		t.Fatalf("Expected a sequenced broadcast, got %+v (err %v)", seen, err)
+	}
+	conn.Close()
+
+	// Missed while disconnected; the other room must not be replayed
+	broadcastUpdate("resume-room", "ROOM_UPDATE", "second")
+	broadcastUpdate("other-room", "ROOM_UPDATE", "elsewhere")
+	broadcastUpdate("resume-room", "ROOM_UPDATE", "third")
+
+	conn = dial()
+	conn.WriteJSON(map[string]interface{}{"action": "subscribe_room", "room_id": "resume-room", "resume_from": seen.Seq})
+	readReply(t, conn, "ACK", "subscribe_room")
+	last := seen.Seq
+	for _, want := range []string{"second", "third"} {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil || msg.Payload != want || msg.Seq <= last {
+			t.Fatalf("Expected replay of %q after seq %d, got %+v (err %v)", want, last, msg, err)
+		}
+		last = msg.Seq
+	}
+
+	// Resuming from before the buffer asks the client to refetch
+	for i := 0; i < 3; i++ {
+		broadcastUpdate("resume-room", "ROOM_UPDATE", "more")
+	}
+	stale := dial()
+	stale.WriteJSON(map[string]interface{}{"action": "subscribe_room", "room_id": "resume-room", "resume_from": seen.Seq})
+	readReply(t, stale, "ACK", "subscribe_room")
+	var msg Message
+	if err := stale.ReadJSON(&msg); err != nil || msg.Type != "RESYNC_REQUIRED" {
+		t.Fatalf("Expected RESYNC_REQUIRED, got %+v (err %v)", msg, err)
+	}
+}
+
+func TestRoomDeltaSmallerThanSnapshot(t *testing.T) {
+	room := &Room{ID: "BIGRM1", SessionName: "Large class", Sets: map[string]string{"A": "https://example.com/a"}}
+	for i := 0; i < 200; i++ {
+		room.Students = append(room.Students, UserSession{
+			ID:           fmt.Sprintf("session-%d", i),
+			UserID:       fmt.Sprintf("student-%d", i),
+			Username:     fmt.Sprintf("Student %d", i),
+			RegNo:        fmt.Sprintf("REG%04d", i),
+			ActiveStatus: Online,
+			IpAddress:    "10.0.0.1:50000",
+			LastPing:     time.Now(),
+		})
+	}
+
+	full, _ := json.Marshal(Message{Type: "ROOM_UPDATE", Payload: room.publicView(), Target: room.ID, Seq: 1})
+	delta, _ := json.Marshal(Message{Type: "ROOM_DELTA", Payload: RoomDelta{RoomID: room.ID, Student: room.Students[42]}, Target: room.ID, Seq: 2})
+	t.Logf("200 students: ROOM_UPDATE %d bytes, ROOM_DELTA %d bytes", len(full), len(delta))
+	if len(delta)*50 > len(full) {
+		t.Fatalf("Expected the delta to be under 2%% of the snapshot, got %d vs %d bytes", len(delta), len(full))
+	}
+}
+
+func TestRoomListUpdateCarriesSummaries(t *testing.T) {
+	withEmptyRooms(t)
+	_, dial := startTestHub(t)
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_all"})
+	readReply(t, conn, "ACK", "subscribe_all")
+
+	roomID := createTestRoom(t, "list-key")
+
+	var msg struct {
+		Type    string        `json:"type"`
+		Payload []RoomSummary `json:"payload"`
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ROOM_LIST_UPDATE" {
+		t.Fatalf("Expected ROOM_LIST_UPDATE, got %+v (err %v)", msg, err)
+	}
+	if len(msg.Payload) != 1 {
+		t.Fatalf("Expected one room in the payload, got %+v", msg.Payload)
+	}
+	got := msg.Payload[0]
+	if got.ID != roomID || got.SessionName != "Test Session" || got.ActiveStatus != Waiting || got.StudentCount != 0 {
+		t.Fatalf("Unexpected summary %+v", got)
+	}
+
+	// Only list fields are sent, so keys and students never leak
+	mu.Lock()
+	broadcastRoomList()
+	mu.Unlock()
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	for _, field := range []string{"admin_key", "students", "list-key"} {
+		if strings.Contains(string(raw), field) {
+			t.Errorf("ROOM_LIST_UPDATE payload contains %q: %s", field, raw)
+		}
+	}
+}
+
+func TestHelloHandshake(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "hello-key")
+	sessionID, token := joinTestRoom(t, roomID, "greeter", "REG1320")
+
+	presence := func() Presence {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		RoomObserversHandler(rr, httptest.NewRequest("GET", "/room-observers?room_id="+roomID, nil))
+		var resp struct {
+			Presence Presence `json:"presence"`
+		}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp.Presence
+	}
+
+	admin := dial()
+	admin.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "admin_key": "wrong"})
+	readReply(t, admin, "NACK", "hello")
+	admin.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "admin_key": "hello-key"})
+	readReply(t, admin, "ACK", "hello")
+
+	student := dial()
+	student.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": "forged"})
+	readReply(t, student, "NACK", "hello")
+	if p := presence(); len(p.Students) != 0 || p.Admins != 1 {
+		t.Fatalf("Expected only the admin present, got %+v", p)
+	}
+
+	// The hello itself counts as a heartbeat and brings an Offline student back
+	mu.Lock()
+	rooms[roomID].Students[0].ActiveStatus = Offline
+	mu.Unlock()
+	advance(time.Minute)
+	student.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, student, "ACK", "hello")
+	if p := presence(); len(p.Students) != 1 || p.Students[0] != sessionID {
+		t.Fatalf("Expected the student present, got %+v", p)
+	}
+	mu.RLock()
+	s := rooms[roomID].Students[0]
+	mu.RUnlock()
+	if !s.LastPing.Equal(now()) || s.ActiveStatus != Online {
+		t.Fatalf("Expected a fresh ping and Online, got %v %v", s.LastPing, s.ActiveStatus)
+	}
+
+	// Later traffic on the socket keeps LastPing fresh
+	advance(time.Minute)
+	student.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	readReply(t, student, "ACK", "subscribe_room")
+	mu.RLock()
+	last := rooms[roomID].Students[0].LastPing
+	mu.RUnlock()
+	if !last.Equal(now()) {
+		t.Fatalf("Expected socket traffic to refresh LastPing, got %v want %v", last, now())
+	}
+
+	student.Close()
+	waitFor(t, "the student to leave", func() bool { return len(presence().Students) == 0 })
+}
+
+func TestPresenceDrivesOnlineStatus(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "presence-key")
+	sessionID, token := joinTestRoom(t, roomID, "present", "REG1330")
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Active
+	rooms[roomID].Students[0].ActiveStatus = Offline
+	mu.Unlock()
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, conn, "ACK", "hello")
+	if status := studentStatus(roomID, "present"); status != Online {
+		t.Fatalf("Expected connecting to bring the student Online, got %v", status)
+	}
+
+	// A quiet but open socket keeps the student Online without HTTP pings
+	advance(2 * defaultOfflineGrace)
+	checkMissedHeartbeats()
+	if status := studentStatus(roomID, "present"); status != Online {
+		t.Fatalf("Expected an open socket to keep the student Online, got %v", status)
+	}
+
+	// Disconnecting starts the grace period rather than marking Offline at once
+	advance(time.Minute)
+	conn.Close()
+	waitFor(t, "the disconnect to be recorded", func() bool {
+		mu.RLock()
+		defer mu.RUnlock()
+		return rooms[roomID].Students[0].LastPing.Equal(now())
+	})
+	checkMissedHeartbeats()
+	if status := studentStatus(roomID, "present"); status != Online {
+		t.Fatalf("Expected Online within the grace period, got %v", status)
+	}
+	advance(defaultOfflineGrace + time.Second)
+	checkMissedHeartbeats()
+	if status := studentStatus(roomID, "present"); status != Offline {
+		t.Fatalf("Expected Offline once the grace period passed, got %v", status)
+	}
+
+	// HTTP pings alone bring the student back
+	pingTestRoom(t, roomID, sessionID, token)
+	if status := studentStatus(roomID, "present"); status != Online {
+		t.Fatalf("Expected an HTTP ping to bring the student Online, got %v", status)
+	}
+}
+
+func TestRoomSummaryCountsAndUpdatedAt(t *testing.T) {
+	withEmptyRooms(t)
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	advance := useFakeClock(t, start)
+	_, dial := startTestHub(t)
+
+	roomID := createTestRoom(t, "count-key")
+	joinTestRoom(t, roomID, "present", "REG1390")
+	joinTestRoom(t, roomID, "away", "REG1391")
+	advance(time.Minute)
+	if err := updateUserStatus(roomID, "count-key", "away", Offline); err != nil {
+		t.Fatalf("updateUserStatus: %v", err)
+	}
+
+	page := getRoomPage(t, "")
+	if len(page.Rooms) != 1 {
+		t.Fatalf("Expected one room, got %d", len(page.Rooms))
+	}
+	got := page.Rooms[0]
+	if got.StudentCount != 2 || got.OnlineCount != 1 || !got.UpdatedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected listing: %d students, %d online, updated %v", got.StudentCount, got.OnlineCount, got.UpdatedAt)
+	}
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_all"})
+	readReply(t, conn, "ACK", "subscribe_all")
+	mu.Lock()
+	broadcastRoomList()
+	mu.Unlock()
+	var msg struct {
+		Type    string        `json:"type"`
+		Payload []RoomSummary `json:"payload"`
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ROOM_LIST_UPDATE" || len(msg.Payload) != 1 {
+		t.Fatalf("Expected ROOM_LIST_UPDATE, got %+v (err %v)", msg, err)
+	}
+	if s := msg.Payload[0]; s.StudentCount != 2 || s.OnlineCount != 1 || !s.UpdatedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+}
+
+func TestDisconnectKeepsStatus(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 2, 9, 0, 0, 0, time.UTC))
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "drop-key")
+	sessionID, token := joinTestRoom(t, roomID, "dropper", "REG1340")
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "hello", "room_id": roomID, "user_session_id": sessionID, "session_token": token})
+	readReply(t, conn, "ACK", "hello")
+	if err := updateUserStatus(roomID, "drop-key", "dropper", Offline); err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket going away starts the grace period but never brings the
+	// student back Online
+	advance(time.Minute)
+	conn.Close()
+	waitFor(t, "the disconnect to be recorded", func() bool {
+		mu.RLock()
+		defer mu.RUnlock()
+		return rooms[roomID].Students[0].LastPing.Equal(now())
+	})
+	if status := studentStatus(roomID, "dropper"); status != Offline {
+		t.Fatalf("Expected the student to stay Offline after the disconnect, got %v", status)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "fmt"
+
+// remoteAccessApps are the guest tools of common hypervisors and the agents
+// of remote desktop software. A match suggests the exam is running inside a
+// VM or is being watched or driven from another machine. They are matched
+// with the same pattern rules as forbiddenApps but reported separately, so a
+// proctor can tell "Discord is open" from "this is not the real desktop".
+var remoteAccessApps = []string{
+	"vboxservice", "vboxtray", "vboxclient", "vmtoolsd", "vmwaretray", "vmwareuser", "qemu-ga", "prl_*",
+	"teamviewer*", "anydesk", "rustdesk", "rdpclip", "xrdp*", "x11vnc", "*vncserver*", "parsecd",
+}
+
+// getRemoteAccessApps returns a copy of the remote access list
+func getRemoteAccessApps() []string {
+	patternListsMu.RLock()
+	defer patternListsMu.RUnlock()
+	return append([]string(nil), remoteAccessApps...)
+}
+
+// matchRemoteAccess returns the remoteAccessApps patterns that match at least
+// one process along with every process that matched
+func matchRemoteAccess(procs []ProcessInfo) ([]string, []ProcessInfo) {
+	return matchForbidden(procs, compileAppPatterns(getRemoteAccessApps()))
+}
+
+// checkRemoteAccess applies the remote access trigger to a student's scan. Caller holds mu.
+func (r *Room) checkRemoteAccess(idx int, result ScanResult) bool {
+	if !r.flagPolicy().RemoteAccess || len(result.RemoteAccess) == 0 {
+		return false
+	}
+	return r.autoFlag(idx, "remote_access", fmt.Sprintf("VM or remote access tools running: %v", result.RemoteAccess))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const sampleVMPs = `    PID COMMAND
+      1 /sbin/init splash
+    640 /usr/sbin/VBoxService --pidfile /var/run/vboxadd-service.sh
+    702 /usr/bin/VBoxClient --clipboard
+    812 /usr/lib/firefox/firefox-bin -contentproc -childID 1
+    990 /usr/bin/rustdesk --service
+   1010 /usr/bin/x11vnc -display :0
+`
+
+const sampleRemoteTasklist = `"System Idle Process","0","Services","0","8 K"
+"vmtoolsd.exe","2210","Services","0","20,112 K"
+"TeamViewer_Service.exe","3120","Services","0","14,880 K"
+"AnyDesk.exe","4410","Console","1","30,004 K"
+"explorer.exe","5120","Console","1","98,100 K"
+`
+
+func TestMatchRemoteAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		procs    []ProcessInfo
+		want     []string
+		wantPIDs []int
+	}{
+		{"virtualbox guest over vnc", parsePsOutput(sampleVMPs), []string{"vboxservice", "vboxclient", "rustdesk", "x11vnc"}, []int{640, 702, 990, 1010}},
+		{"vmware guest with remote desktop", parseTasklistOutput(sampleRemoteTasklist), []string{"vmtoolsd", "teamviewer*", "anydesk"}, []int{2210, 3120, 4410}},
+		{"ordinary desktop", parsePsOutput(samplePs), []string{}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matches := matchRemoteAccess(tt.procs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchRemoteAccess() = %v, want %v", got, tt.want)
+			}
+			pids := []int{}
+			for _, p := range matches {
+				pids = append(pids, p.PID)
+			}
+			if !reflect.DeepEqual(pids, tt.wantPIDs) {
+				t.Errorf("matchRemoteAccess() PIDs = %v, want %v", pids, tt.wantPIDs)
+			}
+		})
+	}
+}
+
+func TestRemoteAccessReportedSeparately(t *testing.T) {
+	procs := parsePsOutput(sampleVMPs)
+
+	result := evaluateScan(procs, Blacklist, nil, nil, nil)
+	if !reflect.DeepEqual(result.Processes, []string{"firefox"}) {
+		t.Errorf("Expected only firefox among forbidden apps, got %v", result.Processes)
+	}
+	if len(result.RemoteAccess) != 4 || len(result.RemoteAccessMatches) != 4 {
+		t.Errorf("Expected four remote access matches, got %+v", result)
+	}
+
+	// Whitelist rooms still get the remote access report
+	result = evaluateScan(procs, Whitelist, []string{"re:.*"}, systemApps, nil)
+	if result.ForbiddenFound || len(result.RemoteAccess) != 4 {
+		t.Errorf("Expected a clean whitelist scan with remote access reported, got %+v", result)
+	}
+}
+
+func TestRemoteAccessTrigger(t *testing.T) {
+	prev := runCommand
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte(sampleVMPs), nil
+	}
+	t.Cleanup(func() { runCommand = prev })
+
+	roomID := createTestRoom(t, "policy-remote")
+	sessionID, token := joinTestRoom(t, roomID, "remote", "REG1230")
+	scan := func() ScanResult {
+		rr := httptest.NewRecorder()
+		checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan?room_id="+roomID+"&user_session_id="+sessionID+"&session_token="+token, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Scan returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var result ScanResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatalf("Decoding scan result: %v", err)
+		}
+		return result
+	}
+
+	if result := scan(); len(result.RemoteAccess) == 0 {
+		t.Fatalf("Expected remote access in the scan result, got %+v", result)
+	}
+	if status := studentStatus(roomID, "remote"); status != Online {
+		t.Fatalf("Expected the trigger to be off by default, got %v", status)
+	}
+
+	setFlagPolicy(t, roomID, "policy-remote", `{"remote_access": true}`)
+	scan()
+	if status := studentStatus(roomID, "remote"); status != Flagged {
+		t.Fatalf("Expected remote access to flag the student, got %v", status)
+	}
+	if flag, ok := lastFlag(roomID, "remote"); !ok || flag.By != autoFlagger {
+		t.Errorf("Expected an automatic flag, got %+v", flag)
+	}
+}
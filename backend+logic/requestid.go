@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader carries the request ID in both directions. A client may
+// send one to tie its own logs to ours; otherwise the server picks one.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds caller-supplied IDs so they can't flood the log
+const maxRequestIDLen = 64
+
+type requestIDKey struct{}
+
+// withRequestID assigns every request an ID, stores it in the request
+// context and echoes it in the response header
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = generateID(); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts short IDs made of letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFrom returns the request ID stored in ctx, or "" outside a request
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs through the standard logger, prefixed with the request ID from
+// ctx when there is one
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if id := requestIDFrom(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDGeneratedAndEchoed(t *testing.T) {
+	logs := captureLog(t)
+	var seen string
+	h := withRequestID(logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFrom(r.Context())
+		logf(r.Context(), "inside handler")
+	})))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/get-room", nil))
+
+	id := rr.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatal("response is missing the request ID header")
+	}
+	if seen != id {
+		t.Errorf("context carries %q, header says %q", seen, id)
+	}
+	// Both the handler's line and the access log line carry the ID
+	if n := strings.Count(logs.String(), "["+id+"] "); n != 2 {
+		t.Errorf("expected 2 log lines tagged with %s, got %d: %q", id, n, logs.String())
+	}
+}
+
+func TestRequestIDFromHeader(t *testing.T) {
+	captureLog(t)
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		sent string
+		kept bool
+	}{
+		{"client-abc.123_x", true},
+		{"has space", false},
+		{"inject\nline", false},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/join-room", nil)
+		req.Header.Set(requestIDHeader, c.sent)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		got := rr.Header().Get(requestIDHeader)
+		if c.kept && got != c.sent {
+			t.Errorf("sent %q: got %q, want it echoed", c.sent, got)
+		}
+		if !c.kept && (got == c.sent || got == "") {
+			t.Errorf("sent %q: got %q, want a fresh ID", c.sent, got)
+		}
+	}
+}
@@ -2,35 +2,20 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// StatusEnum defines the current state of the Exam Room
-type StatusEnum int
-
-const (
-	Waiting StatusEnum = iota
-	Active
-	NetworkLoss
-	Paused
-	Complete
-)
-
-// UStatusEnum defines the state of an individual student
-type UStatusEnum int
-
-const (
-	Online UStatusEnum = iota
-	Offline
-	Submitted
-	Flagged
-)
-
 // Room represents the exam session managed by an examiner
 type Room struct {
 	ID            string            `json:"id"`
@@ -38,124 +23,381 @@ type Room struct {
 	SessionName   string            `json:"session_name"`
 	Sets          map[string]string `json:"sets"` // e.g., {"SetA": "Questions_URL_1"}
 	ActiveStatus  StatusEnum        `json:"active_status"`
-	AdminKey      string            `json:"admin_key"` // Changed to string for better security
-	TimeAllocated time.Duration     `json:"time_allocated"`
+	AdminKey      string            `json:"admin_key"`                // Changed to string for better security
+	AdminKeyHash  string            `json:"admin_key_hash,omitempty"` // Replaces AdminKey once the key is rotated
+	AgentKeyHash  string            `json:"agent_key_hash,omitempty"` // Authenticates scan agents; see AgentKeyHandler
+	TimeAllocated Duration          `json:"time_allocated"`
 	StartTime     time.Time         `json:"start_time"`
 	EndTime       time.Time         `json:"end_time"`
 	Students      []UserSession     `json:"students"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ScanMode      ScanModeEnum      `json:"scan_mode"`
+	AllowedApps   []string          `json:"allowed_apps,omitempty"`   // Used in Whitelist mode
+	SystemApps    []string          `json:"system_apps,omitempty"`    // Overrides the default system process ignore list
+	ScanIgnore    []string          `json:"scan_ignore,omitempty"`    // Overrides the global scanIgnore list
+	BlurThreshold int               `json:"blur_threshold,omitempty"` // Blur events within BlurWindow that flag a student
+	BlurWindow    Duration          `json:"blur_window,omitempty"`
+	OfflineGrace  Duration          `json:"offline_grace,omitempty"` // Overrides defaultOfflineGrace when set
+	ScanInterval  Duration          `json:"scan_interval,omitempty"` // How often clients should scan; overrides defaultScanInterval when set
+	Rubric        *Rubric           `json:"rubric,omitempty"`        // Answer key used to score submissions
+
+	// ShowLeaderboard broadcasts the top LeaderboardSize scores whenever a score changes
+	ShowLeaderboard bool `json:"show_leaderboard,omitempty"`
+	LeaderboardSize int  `json:"leaderboard_size,omitempty"`
+
+	// Admin-only: extra admin keys, the record of admin actions and removed students
+	CoProctors []CoProctor  `json:"co_proctors,omitempty"`
+	AuditLog   []AuditEntry `json:"audit_log,omitempty"`
+	Bans       []Ban        `json:"bans,omitempty"`
+	JoinTokens []JoinToken  `json:"join_tokens,omitempty"` // Personal join links, issued and used
+
+	RequireJoinToken bool     `json:"require_join_token,omitempty"` // Only personal join links may join
+	RequiredFields   []string `json:"required_fields,omitempty"`    // Identity fields a new student must give: user_id, username or regno
+
+	// New students may only join between these times; either end may be unset.
+	// Students already in the room can always reconnect.
+	JoinOpensAt  *time.Time `json:"join_opens_at,omitempty"`
+	JoinClosesAt *time.Time `json:"join_closes_at,omitempty"`
+
+	LastAnnouncement *Announcement `json:"last_announcement,omitempty"` // Shown to students who join later
+
+	Abort *ExamAbort `json:"abort,omitempty"` // Why and when the exam was aborted, once it is
+
+	// Admin-only: direct messages waiting for a student to reconnect
+	PendingMessages []DirectMessage `json:"pending_messages,omitempty"`
+
+	FlagPolicy *FlagPolicy `json:"flag_policy,omitempty"` // Auto-flag triggers; nil uses defaultFlagPolicy
+
+	// Set while the heartbeat monitor holds the room in NetworkLoss
+	NetworkLossSince *time.Time `json:"network_loss_since,omitempty"`
+
+	// Version increases with every change broadcast to the room's observers.
+	// Heartbeats alone do not bump it.
+	Version uint64 `json:"version"`
+
+	// UpdatedAt is when Version last increased, or when the room was created
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// UserSession represents the student's state within a specific room
+// UserSession represents the student's state within a specific room.
+// Only UserID, Username, RegNo and SelectedSet come from the student, through
+// JoinRequest. The rest, Score, ActiveStatus and Flags above all, is set by
+// the server alone, so no student-facing request may decode into this type.
 type UserSession struct {
-	ID           string      `json:"id"`
-	UserID       string      `json:"user_id"`
-	Username     string      `json:"username"`
-	RegNo        string      `json:"regno"`
-	ActiveStatus UStatusEnum `json:"active_status"`
-	SelectedSet  string      `json:"selected_set"` // Changed to string to match Room.Sets key
-	IpAddress    string      `json:"ip_address"`   // Security tracking
-	LastPing     time.Time   `json:"last_ping"`    // To detect disconnects
-	Score        float64     `json:"score"`        // Optional: for auto-grading
+	ID           string          `json:"id"`
+	UserID       string          `json:"user_id"`
+	Username     string          `json:"username"`
+	RegNo        string          `json:"regno"`
+	ActiveStatus UStatusEnum     `json:"active_status"`
+	SelectedSet  string          `json:"selected_set"` // Changed to string to match Room.Sets key
+	Seed         uint32          `json:"seed"`         // Shuffles the student's questions the same way on every reload
+	IpAddress    string          `json:"ip_address"`   // Security tracking
+	LastPing     time.Time       `json:"last_ping"`    // To detect disconnects
+	JoinedAt     time.Time       `json:"joined_at"`
+	LastScan     *time.Time      `json:"last_scan,omitempty"`    // Last scan attributed to the student
+	ScanOverdue  bool            `json:"scan_overdue,omitempty"` // Set by the missed scan trigger until the next scan
+	Score        float64         `json:"score"`                  // Optional: for auto-grading
+	Answers      json.RawMessage `json:"answers,omitempty"`      // Raw answers recorded on submit
+	SubmittedAt  *time.Time      `json:"submitted_at,omitempty"` // Set by a submit or an admin setting Submitted, kept through a flag
+	Evidence     []string        `json:"evidence,omitempty"`     // Uploaded evidence file names
+	FocusEvents  []FocusEvent    `json:"focus_events,omitempty"`
+	Flags        []FlagRecord    `json:"flags,omitempty"`      // Why and by whom the student was flagged
+	RiskScore    int             `json:"risk_score,omitempty"` // Points of the uncleared automatic flags
+	RiskLevel    string          `json:"risk_level,omitempty"` // low, medium or high, from RiskScore
+}
+
+// publicView returns a copy of the room that is safe to hand out to anyone
+// watching it: the admin key, the students' answers and their risk scoring
+// are stripped.
+func (r *Room) publicView() Room {
+	view := *r
+	view.AdminKey = ""
+	view.AdminKeyHash = ""
+	view.AgentKeyHash = ""
+	view.Rubric = nil
+	view.CoProctors = nil
+	view.AuditLog = nil
+	view.Bans = nil
+	view.JoinTokens = nil
+	view.PendingMessages = nil
+	view.Sets = map[string]string{}                // Question URLs go only to admins, and to each student for their own set
+	view.ScanInterval = Duration(r.scanInterval()) // Clients throttle by it, so always send one
+	view.Students = make([]UserSession, len(r.Students))
+	for i, s := range r.Students {
+		view.Students[i] = s.publicView()
+	}
+	return view
+}
+
+// publicView returns a copy of the session without submitted answers or
+// risk scoring, which only admins see
+func (s UserSession) publicView() UserSession {
+	s.Answers = nil
+	s.RiskScore = 0
+	s.RiskLevel = ""
+	if s.Flags != nil {
+		flags := make([]FlagRecord, len(s.Flags))
+		for i, f := range s.Flags {
+			f.Severity, f.Points = "", 0
+			flags[i] = f
+		}
+		s.Flags = flags
+	}
+	return s
+}
+
+// RoomDelta is the ROOM_DELTA payload: a single student that joined or changed
+type RoomDelta struct {
+	RoomID  string      `json:"room_id"`
+	Student UserSession `json:"student"`
+}
+
+// RoomSummary is one row of the ROOM_LIST_UPDATE payload. It carries only
+// what the room list shows so admins can redraw it without refetching.
+type RoomSummary struct {
+	ID           string     `json:"id"`
+	HostID       string     `json:"host_id"`
+	SessionName  string     `json:"session_name"`
+	ActiveStatus StatusEnum `json:"active_status"`
+	StartTime    time.Time  `json:"start_time"`
+	StudentCount int        `json:"student_count"`
+	OnlineCount  int        `json:"online_count"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
+// summary returns the room's entry in the room list. Caller holds mu.
+func (r *Room) summary() RoomSummary {
+	return RoomSummary{
+		ID:           r.ID,
+		HostID:       r.HostID,
+		SessionName:  r.SessionName,
+		ActiveStatus: r.ActiveStatus,
+		StartTime:    r.StartTime,
+		StudentCount: len(r.Students),
+		OnlineCount:  r.onlineCount(),
+		UpdatedAt:    r.UpdatedAt,
+	}
+}
+
+// onlineCount returns how many of the room's students are Online. Caller holds mu.
+func (r *Room) onlineCount() int {
+	n := 0
+	for _, s := range r.Students {
+		if s.ActiveStatus == Online {
+			n++
+		}
+	}
+	return n
+}
+
+// now is the clock used for all exam logic. Tests replace it to control time;
+// websocket deadlines keep using the real clock.
+var now = time.Now
+
 var (
 	rooms = make(map[string]*Room)
 	mu    sync.RWMutex
 	// wsHub is defined in main.go but accessible here as same package
 )
 
+// broadcastUpdate sends a message to target's observers. Every change they
+// can see is broadcast, so a room target also bumps that room's version.
+// Caller holds mu for room targets.
 func broadcastUpdate(target string, msgType string, payload interface{}) {
+	if target != "all" {
+		markChanged(target)
+	}
 	if wsHub == nil {
 		return
 	}
-	wsHub.broadcast <- Message{
+	wsHub.publish(Message{
 		Type:    msgType,
 		Payload: payload,
 		Target:  target,
+	})
+}
+
+// broadcastRoomList sends a summary of every room, in ID order, to the
+// "all" target. Caller holds mu.
+func broadcastRoomList() {
+	list := make([]RoomSummary, 0, len(rooms))
+	for _, r := range rooms {
+		list = append(list, r.summary())
 	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	broadcastUpdate("all", "ROOM_LIST_UPDATE", list)
 }
 
-func generateID() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return fmt.Sprintf("%x", b)
+// broadcastStudent tells a room's observers about one student instead of
+// resending the whole room. Caller holds mu.
+func broadcastStudent(roomID string, student UserSession) {
+	broadcastUpdate(roomID, "ROOM_DELTA", RoomDelta{RoomID: roomID, Student: student.publicView()})
 }
 
-const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// sessionIDBytes is how many random bytes go into session IDs and the other
+// opaque IDs from generateID; roomIDLength is how many characters a room
+// code has. Set with -session-id-bytes and -room-id-length.
+var (
+	sessionIDBytes = 16
+	roomIDLength   = 6
+)
 
-func generateShortRoomID() string {
-	b := make([]byte, 6)
-	rand.Read(b)
-	for i := range b {
-		b[i] = charset[int(b[i])%len(charset)]
+// Limits checked by checkIDConfig. Below minRoomIDLength the code space is
+// small enough to fill up; beyond maxRoomIDLength nobody can type it.
+const (
+	minSessionIDBytes = 8
+	minRoomIDLength   = 4
+	maxRoomIDLength   = 16
+)
+
+// checkIDConfig validates the ID settings at startup
+func checkIDConfig() error {
+	if sessionIDBytes < minSessionIDBytes {
+		return fmt.Errorf("session ID bytes must be at least %d, got %d", minSessionIDBytes, sessionIDBytes)
 	}
-	return string(b)
+	if roomIDLength < minRoomIDLength || roomIDLength > maxRoomIDLength {
+		return fmt.Errorf("room ID length must be between %d and %d, got %d", minRoomIDLength, maxRoomIDLength, roomIDLength)
+	}
+	return nil
 }
 
-// File path for persistence
-const dataFile = "rooms.json"
+// randReader supplies every random byte the server uses. Tests replace it.
+var randReader io.Reader = rand.Reader
+
+// errNoEntropy is returned when randReader fails. Handlers answer it with a
+// 500; an ID built from short or zeroed bytes would be guessable.
+var errNoEntropy = errors.New("Could not generate a random ID")
 
-func init() {
-	loadRooms()
+// randomBytes returns n bytes from randReader, logging the underlying error
+// when it fails
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		log.Printf("Error reading random bytes: %v", err)
+		return nil, errNoEntropy
+	}
+	return b, nil
 }
 
-func loadRooms() {
-	file, err := os.Open(dataFile)
+func generateID() (string, error) {
+	b, err := randomBytes(sessionIDBytes)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return
-		}
-		fmt.Println("Error reading rooms.json:", err)
-		return
+		return "", err
 	}
-	defer file.Close()
+	return fmt.Sprintf("%x", b), nil
+}
 
-	var loaded map[string]*Room
-	if err := json.NewDecoder(file).Decode(&loaded); err != nil {
-		fmt.Println("Error decoding rooms.json:", err)
-		return
+// generateSeed returns a random, nonzero question shuffle seed. It fits in
+// 32 bits so clients can use it as a JavaScript number.
+func generateSeed() (uint32, error) {
+	b, err := randomBytes(4)
+	if err != nil {
+		return 0, err
 	}
-
-	mu.Lock()
-	rooms = loaded
-	mu.Unlock()
+	return max(binary.BigEndian.Uint32(b), 1), nil
 }
 
-func saveRooms() {
-	mu.Lock()
-	defer mu.Unlock()
+// charset leaves out 0/O and 1/I so students cannot mistype a room code.
+// Its 32 characters divide 256 evenly, so every character is equally likely.
+const charset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
 
-	file, err := os.Create(dataFile)
+func generateShortRoomID() (string, error) {
+	b, err := randomBytes(roomIDLength)
 	if err != nil {
-		fmt.Println("Error saving rooms.json:", err)
-		return
+		return "", err
+	}
+	for i := range b {
+		b[i] = charset[int(b[i])%len(charset)]
 	}
-	defer file.Close()
+	return string(b), nil
+}
+
+// maxRoomIDAttempts bounds the search for an unused room code
+const maxRoomIDAttempts = 100
+
+// errNoRoomID is returned when every attempt hit an existing room
+var errNoRoomID = errors.New("Could not allocate a room ID, try again")
+
+// newRoomID is replaced by tests to force collisions
+var newRoomID = generateShortRoomID
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(rooms); err != nil {
-		fmt.Println("Error encoding rooms.json:", err)
+// allocateRoomID returns a room code no existing room uses. Caller holds mu.
+func allocateRoomID() (string, error) {
+	for i := 0; i < maxRoomIDAttempts; i++ {
+		id, err := newRoomID()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := rooms[id]; !exists {
+			return id, nil
+		}
 	}
+	return "", errNoRoomID
 }
 
-// StartExamHandler allows the admin to start the exam
-func StartExamHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
-		return
+// idempotencyTTL is how long a create-room idempotency key is remembered
+const idempotencyTTL = 24 * time.Hour
+
+type idempotencyEntry struct {
+	RoomID    string
+	CreatedAt time.Time
+}
+
+// idempotencyKeys maps host ID + client key to the room it created. Guarded by mu.
+var idempotencyKeys = make(map[string]idempotencyEntry)
+
+// lookupIdempotencyKey returns the room previously created with this key by
+// the same host, pruning expired keys along the way. Caller must hold mu.
+func lookupIdempotencyKey(hostID, key string) (string, bool) {
+	for k, entry := range idempotencyKeys {
+		if now().Sub(entry.CreatedAt) > idempotencyTTL {
+			delete(idempotencyKeys, k)
+		}
+	}
+	if key == "" {
+		return "", false
 	}
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	entry, ok := idempotencyKeys[hostID+"\x00"+key]
+	if !ok {
+		return "", false
+	}
+	// The room may have been deleted since
+	if _, exists := rooms[entry.RoomID]; !exists {
+		return "", false
+	}
+	return entry.RoomID, true
+}
+
+// rememberIdempotencyKey records the room created for a key. Caller must hold mu.
+func rememberIdempotencyKey(hostID, key, roomID string) {
+	if key == "" {
 		return
 	}
+	idempotencyKeys[hostID+"\x00"+key] = idempotencyEntry{RoomID: roomID, CreatedAt: now()}
+}
+
+// startRetryWindow is how long after a start the same admin can repeat it
+// (a double click, a retried request) and get success instead of an error
+const startRetryWindow = 10 * time.Second
 
+// startedBy returns who started the exam, from the audit log. Caller holds mu.
+func (r *Room) startedBy() string {
+	for i := len(r.AuditLog) - 1; i >= 0; i-- {
+		if r.AuditLog[i].Action == "start_exam" {
+			return r.AuditLog[i].Actor
+		}
+	}
+	return ""
+}
+
+// StartExamHandler allows the admin to start the exam
+func StartExamHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		RoomID   string `json:"room_id"`
 		AdminKey string `json:"admin_key"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -164,27 +406,45 @@ func StartExamHandler(w http.ResponseWriter, r *http.Request) {
 
 	room, exists := rooms[req.RoomID]
 	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeError(w, errRoomNotFound)
 		return
 	}
 
-	if room.AdminKey != req.AdminKey {
-		http.Error(w, "Unauthorized: Invalid Admin Key", http.StatusUnauthorized)
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
 		return
 	}
 
+	// A repeated start from the same admin just after the first one reports
+	// the existing times; anything else on a started room is a real re-start
+	if room.ActiveStatus == Active && room.startedBy() == actor && now().Sub(room.StartTime) <= startRetryWindow {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":         "Exam already started",
+			"start_time":      room.StartTime,
+			"end_time":        room.EndTime,
+			"already_started": true,
+		})
+		return
+	}
+	// Stricter than canTransition: resuming a Paused room goes through /update-room
 	if room.ActiveStatus != Waiting {
-		http.Error(w, "Exam can only be started from Waiting state", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRoomState, "Exam can only be started from Waiting state")
 		return
 	}
 
 	room.ActiveStatus = Active
-	room.StartTime = time.Now()
+	room.StartTime = now()
 	// If TimeAllocated is 0, assume infinite or manual stop?
 	// Let's just calculate EndTime if TimeAllocated > 0
 	if room.TimeAllocated > 0 {
-		room.EndTime = room.StartTime.Add(room.TimeAllocated)
+		room.EndTime = room.StartTime.Add(room.TimeAllocated.Std())
 	}
+	room.audit(actor, "start_exam", "")
+	broadcastUpdate(room.ID, "ROOM_UPDATE", room.publicView())
+	broadcastRoomList()
+	requestSave(room.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -194,259 +454,936 @@ func StartExamHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// duplicateRoomNames decides what happens when a host creates a room with
+// the session name of one of their rooms that has not ended: "off" allows
+// it, "warn" creates the room but reports the existing one, and "reject"
+// refuses with a 409 naming the existing room. Set with -duplicate-room-names.
+var duplicateRoomNames = "warn"
+
+// setDuplicateRoomNames validates and sets duplicateRoomNames
+func setDuplicateRoomNames(mode string) error {
+	switch mode {
+	case "off", "warn", "reject":
+		duplicateRoomNames = mode
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate room names mode %q (want off, warn or reject)", mode)
+}
+
+// findDuplicateRoom returns the host's room, not yet ended, with the
+// same session name, ignoring case and surrounding space. Caller holds mu.
+func findDuplicateRoom(hostID, name string) *Room {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	var found *Room
+	for _, room := range rooms {
+		if room.HostID != hostID || room.ActiveStatus.Ended() || !strings.EqualFold(strings.TrimSpace(room.SessionName), name) {
+			continue
+		}
+		// The oldest is the one the class most likely joined
+		if found == nil || room.CreatedAt.Before(found.CreatedAt) {
+			found = room
+		}
+	}
+	return found
+}
+
 // CreateRoomHandler handles the creation of a new exam room
 func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
-		return
+	var req struct {
+		SessionName    string   `json:"session_name"`
+		HostID         string   `json:"host_id"`
+		AdminKey       string   `json:"admin_key"`
+		TimeAllocated  Duration `json:"time_allocated"`  // e.g. "90m", or nanoseconds
+		IdempotencyKey string   `json:"idempotency_key"` // Optional, makes retries safe
+		TemplateID     string   `json:"template_id"`     // Optional, prefills the room's configuration
+		TemplateKey    string   `json:"template_key"`    // The key the template was saved with; defaults to admin_key
 	}
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	var req struct {
-		SessionName string `json:"session_name"`
-		HostID      string `json:"host_id"`
-		AdminKey    string `json:"admin_key"`
+	mu.Lock()
+	// A retried request carrying the same idempotency key gets the room it already created
+	if existingID, ok := lookupIdempotencyKey(req.HostID, req.IdempotencyKey); ok {
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"room_id": existingID,
+			"message": "Room already created",
+		})
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var duplicateID, duplicateMsg string
+	if duplicateRoomNames != "off" {
+		if d := findDuplicateRoom(req.HostID, req.SessionName); d != nil {
+			duplicateID, duplicateMsg = d.ID, fmt.Sprintf("You already have a room named %q", d.SessionName)
+		}
+	}
+	if duplicateID != "" && duplicateRoomNames == "reject" {
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: codeDuplicateRoom, Message: duplicateMsg, RoomID: duplicateID}})
 		return
 	}
 
-	// Generate a Short ID (6 chars)
-	var roomID string
-	for {
-		roomID = generateShortRoomID()
-		mu.Lock()
-		_, exists := rooms[roomID]
+	roomID, err := allocateRoomID()
+	if err != nil {
 		mu.Unlock()
-		if !exists {
-			break
-		}
+		writeError(w, err)
+		return
 	}
 
 	newRoom := &Room{
-		ID:           roomID,
-		SessionName:  req.SessionName,
-		HostID:       req.HostID,
-		AdminKey:     req.AdminKey,
-		ActiveStatus: Waiting, // Default status
-		Students:     []UserSession{},
-		Sets:         make(map[string]string),
+		ID:            roomID,
+		SessionName:   req.SessionName,
+		HostID:        req.HostID,
+		AdminKey:      req.AdminKey,
+		TimeAllocated: req.TimeAllocated,
+		ActiveStatus:  Waiting, // Default status
+		CreatedAt:     now(),
+		UpdatedAt:     now(),
+		Students:      []UserSession{},
+		Sets:          make(map[string]string),
+	}
+
+	if req.TemplateID != "" {
+		if req.TemplateKey == "" {
+			req.TemplateKey = req.AdminKey
+		}
+		templatesMu.RLock()
+		t, ok := templates[req.TemplateID]
+		allowed := ok && t.allows(req.TemplateKey)
+		if allowed {
+			t.apply(newRoom)
+		}
+		templatesMu.RUnlock()
+		if !ok {
+			mu.Unlock()
+			writeJSONError(w, http.StatusNotFound, codeTemplateNotFound, "Template not found")
+			return
+		}
+		if !allowed {
+			mu.Unlock()
+			writeError(w, errUnauthorized)
+			return
+		}
+		// An explicit time allocation still wins over the template's
+		if req.TimeAllocated != 0 {
+			newRoom.TimeAllocated = req.TimeAllocated
+		}
 	}
 
-	mu.Lock()
 	rooms[roomID] = newRoom
+	rememberIdempotencyKey(req.HostID, req.IdempotencyKey, roomID)
+	broadcastRoomList()
 	mu.Unlock()
 
-	saveRooms() // Persist the new room
-	
-	// Broadcast List Update
-	broadcastUpdate("all", "ROOM_LIST_UPDATE", nil)
+	requestSave(roomID) // Persist the new room
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	resp := map[string]string{
 		"room_id": roomID,
 		"message": "Room created successfully",
-	})
-}
-
-// JoinRoomHandler allows a user to join a specific room
-func JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("[DEBUG] JoinRoomHandler Hit")
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
-		fmt.Println("[DEBUG] JoinRoomHandler OPTIONS")
-		return
 	}
-	if r.Method != "POST" {
-		fmt.Println("[DEBUG] JoinRoomHandler Method Not Allowed:", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	if duplicateID != "" {
+		resp["warning"] = duplicateMsg
+		resp["existing_room_id"] = duplicateID
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
 
-	var req struct {
-		RoomID string `json:"room_id"`
-		UserSession
+// JoinRequest is everything a student may say about themselves when
+// joining. Status, score, timestamps and the session ID are the server's to
+// set, so a body that tries to send them is rejected as unknown fields.
+type JoinRequest struct {
+	RoomID       string `json:"room_id"`
+	JoinToken    string `json:"join_token"`    // Optional personal join link token
+	SessionToken string `json:"session_token"` // Proves a reconnecting student is the one who joined
+	UserID       string `json:"user_id"`
+	Username     string `json:"username"`
+	RegNo        string `json:"regno"`
+	SelectedSet  string `json:"selected_set"` // Optional, must be one of the room's sets
+}
+
+// reconnectRestoresOnline brings an Offline student back Online as soon as
+// they rejoin, rather than at their next heartbeat. Set with -reconnect-online.
+var reconnectRestoresOnline = true
+
+// reconnect refreshes a rejoining student's LastPing and, if
+// reconnectRestoresOnline is set, brings them back Online. Flagged and
+// Submitted students keep their status; only a proctor clears a flag.
+// Caller holds mu.
+func (r *Room) reconnect(idx int) {
+	s := &r.Students[idx]
+	s.LastPing = now()
+	if reconnectRestoresOnline && s.ActiveStatus == Offline {
+		s.ActiveStatus = Online
+		broadcastStudent(r.ID, *s)
+		requestSave(r.ID)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Println("[DEBUG] JoinRoomHandler Decode Error:", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// JoinRoomHandler allows a user to join a specific room
+func JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var req JoinRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	fmt.Printf("[DEBUG] Join Request: %+v\n", req)
 
 	mu.Lock()
 	defer mu.Unlock()
 
 	room, exists := rooms[req.RoomID]
 	if !exists {
-		fmt.Printf("[DEBUG] Room Not Found: %s. Available: %v\n", req.RoomID, rooms)
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeError(w, errRoomNotFound)
 		return
 	}
 
-	// Check if user already exists
-	for _, s := range room.Students {
-		if s.UserID == req.UserID || (s.RegNo == req.RegNo && req.RegNo != "") {
-			// User already in room, maybe return existing session or update?
-			// For now, let's just return success with existing ID
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
+	// A join token decides who the student is
+	var token *JoinToken
+	if req.JoinToken != "" {
+		var err error
+		if token, err = room.resolveJoinToken(req.JoinToken, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+	} else if room.RequireJoinToken {
+		writeError(w, errJoinTokenRequired)
+		return
+	}
+
+	if room.isBanned(req.UserID, req.RegNo, r.RemoteAddr) {
+		writeError(w, errBanned)
+		return
+	}
+
+	// The same user_id is the same student reconnecting, provided they can
+	// show it: user IDs are public, so the session token or the student's
+	// join token is required before a new session token is handed out
+	for i, s := range room.Students {
+		if req.UserID != "" && s.UserID == req.UserID {
+			if token != nil && s.RegNo != token.RegNo {
+				writeError(w, errJoinTokenMismatch)
+				return
+			}
+			if token == nil {
+				if req.SessionToken == "" {
+					writeError(w, errUserIDInUse)
+					return
+				}
+				if err := verifySessionToken(room.ID, s.ID, req.SessionToken); err != nil {
+					writeError(w, err)
+					return
+				}
+			}
+			room.reconnect(i)
+			resp := map[string]interface{}{
 				"message":         "User already in room",
 				"user_session_id": s.ID,
-			})
+				"session_token":   signSession(room.ID, s.ID),
+				"session":         room.Students[i].publicView(),
+			}
+			if url := room.questionURL(s.SelectedSet); url != "" {
+				resp["question_url"] = url
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	if code, message, ok := room.checkJoinWindow(now()); !ok {
+		writeJSONError(w, http.StatusForbidden, code, message)
+		return
+	}
+	if field := room.missingIdentity(&req); field != "" {
+		writeJSONError(w, http.StatusBadRequest, codeMissingIdentity, field+" is required to join this room")
+		return
+	}
+
+	// A different user claiming an existing regno is a real collision
+	if req.RegNo != "" {
+		for _, s := range room.Students {
+			if s.RegNo == req.RegNo {
+				writeJSONError(w, http.StatusConflict, codeRegnoInUse, "Registration number already in use in this room")
+				return
+			}
+		}
+	}
+
+	if req.SelectedSet != "" {
+		if _, ok := room.Sets[req.SelectedSet]; !ok {
+			writeError(w, errUnknownSet)
 			return
 		}
 	}
 
-	newUser := req.UserSession
-	newUser.ID = generateID()
+	newUser := UserSession{
+		UserID:      req.UserID,
+		Username:    req.Username,
+		RegNo:       req.RegNo,
+		SelectedSet: req.SelectedSet,
+	}
+	id, err := generateID()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	newUser.ID = id
+	if newUser.Seed, err = generateSeed(); err != nil {
+		writeError(w, err)
+		return
+	}
 	newUser.ActiveStatus = Online
-	newUser.LastPing = time.Now()
+	newUser.LastPing = now()
+	newUser.JoinedAt = newUser.LastPing
 	newUser.IpAddress = r.RemoteAddr
 
 	room.Students = append(room.Students, newUser)
+	if token != nil {
+		usedAt := now()
+		token.UsedAt = &usedAt
+		token.SessionID = newUser.ID
+	}
 
-	// Broadcast Room Update (specifically to observers of this room)
-	broadcastUpdate(req.RoomID, "ROOM_UPDATE", room)
+	// Broadcast the new student (specifically to observers of this room)
+	idx := len(room.Students) - 1
+	if !room.checkSharedIP(idx) {
+		broadcastStudent(req.RoomID, newUser)
+	}
+	broadcastRoomList() // The room's student count changed
+	requestSave(req.RoomID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	resp := map[string]interface{}{
 		"message":         "Joined successfully",
 		"user_session_id": newUser.ID,
-	})
+		"session_token":   signSession(room.ID, newUser.ID),
+		"seed":            newUser.Seed,
+	}
+	// Without a set yet, the student finds the URL through /get-room once one is assigned
+	if url := room.questionURL(newUser.SelectedSet); url != "" {
+		resp["selected_set"] = newUser.SelectedSet
+		resp["question_url"] = url
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-// AdminUpdateUserHandler allows the admin to modify a user's status
-func AdminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
-		return
+var (
+	errRoomNotFound = errors.New("Room not found")
+	errUnauthorized = errors.New("Unauthorized: Invalid Admin Key")
+	errUserNotFound = errors.New("User not found in room")
+	errMissingToken = errors.New("Unauthorized: Missing session token")
+	errInvalidToken = errors.New("Unauthorized: Invalid session token")
+	errInvalidState = errors.New("Invalid status")
+	errUserIDInUse  = errors.New("User ID already in use in this room; reconnect with its session token")
+)
+
+// statusForError maps the shared mutation errors to HTTP status codes
+func statusForError(err error) int {
+	switch err {
+	case errRoomNotFound, errUserNotFound:
+		return http.StatusNotFound
+	case errUnauthorized, errMissingToken, errInvalidToken:
+		return http.StatusUnauthorized
+	case errBanned, errInvalidJoinToken, errJoinTokenMismatch, errJoinTokenRequired:
+		return http.StatusForbidden
+	case errJoinTokenUsed, errUserIDInUse:
+		return http.StatusConflict
+	case errNoEntropy:
+		return http.StatusInternalServerError
+	case errNoRoomID:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
 	}
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+}
+
+// updateUserStatus sets a student's status on behalf of the room admin and
+// broadcasts the change. Shared by the REST handler and websocket commands.
+func updateUserStatus(roomID, adminKey, userID string, status UStatusEnum) error {
+	if !status.Valid() {
+		return errInvalidState
 	}
 
+	mu.Lock()
+	room, exists := rooms[roomID]
+	if !exists {
+		mu.Unlock()
+		return errRoomNotFound
+	}
+
+	actor, ok := room.adminLabel(adminKey)
+	if !ok {
+		mu.Unlock()
+		return errUnauthorized
+	}
+
+	found := false
+	for i, s := range room.Students {
+		if s.UserID == userID {
+			room.Students[i].setStatusBy(status, actor)
+			room.audit(actor, "update_status", userID+" -> "+status.String())
+			found = true
+
+			// Broadcast Update
+			broadcastStudent(roomID, room.Students[i])
+			break
+		}
+	}
+	mu.Unlock()
+
+	if !found {
+		return errUserNotFound
+	}
+
+	requestSave(roomID)
+	return nil
+}
+
+// AdminUpdateUserHandler allows the admin to modify a user's status
+func AdminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		RoomID   string      `json:"room_id"`
 		AdminKey string      `json:"admin_key"`
 		UserID   string      `json:"user_id"`
 		Status   UStatusEnum `json:"status"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	// Room statuses are small ints too; only student statuses are accepted here
+	if !req.Status.Valid() {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "status must be a student status: Online, Offline, Submitted or Flagged")
 		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
+	if err := updateUserStatus(req.RoomID, req.AdminKey, req.UserID, req.Status); err != nil {
+		writeError(w, err)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User status updated successfully",
+	})
+}
+
+// StatusUpdateResult reports the outcome for one student of a batch update
+type StatusUpdateResult struct {
+	UserID string `json:"user_id"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AdminUpdateStatusBatchHandler applies several status changes under one
+// lock and sends a single ROOM_UPDATE for all of them
+func AdminUpdateStatusBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Updates  []struct {
+			UserID string      `json:"user_id"`
+			Status UStatusEnum `json:"status"`
+		} `json:"updates"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mu.Lock()
 	room, exists := rooms[req.RoomID]
 	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		mu.Unlock()
+		writeError(w, errUnauthorized)
 		return
 	}
 
-	if room.AdminKey != req.AdminKey {
-		http.Error(w, "Unauthorized: Invalid Admin Key", http.StatusUnauthorized)
+	index := make(map[string]int, len(room.Students))
+	for i, s := range room.Students {
+		index[s.UserID] = i
+	}
+
+	results := make([]StatusUpdateResult, 0, len(req.Updates))
+	changed := 0
+	for _, u := range req.Updates {
+		result := StatusUpdateResult{UserID: u.UserID}
+		i, found := index[u.UserID]
+		switch {
+		case !u.Status.Valid():
+			result.Error = errInvalidState.Error()
+		case !found:
+			result.Error = errUserNotFound.Error()
+		default:
+			room.Students[i].setStatusBy(u.Status, actor)
+			room.audit(actor, "update_status", u.UserID+" -> "+u.Status.String())
+			result.OK = true
+			changed++
+		}
+		results = append(results, result)
+	}
+	if changed > 0 {
+		broadcastUpdate(req.RoomID, "ROOM_UPDATE", room.publicView())
+	}
+	mu.Unlock()
+
+	if changed > 0 {
+		requestSave(req.RoomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Updated %d of %d students", changed, len(req.Updates)),
+		"results": results,
+	})
+}
+
+// SubmitHandler records a student's final answers and marks them as submitted
+func SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string          `json:"room_id"`
+		UserSessionID string          `json:"user_session_id"`
+		SessionToken  string          `json:"session_token"`
+		Answers       json.RawMessage `json:"answers"`
+	}
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	found := false
+	if err := verifySessionToken(req.RoomID, req.UserSessionID, req.SessionToken); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	mu.Lock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.Unlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if room.sessionBanned(req.UserSessionID) {
+		mu.Unlock()
+		writeError(w, errBanned)
+		return
+	}
+	if room.ActiveStatus == Aborted {
+		mu.Unlock()
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRoomState, "The exam was aborted; answers are no longer accepted")
+		return
+	}
+
+	idx := -1
 	for i, s := range room.Students {
-		if s.UserID == req.UserID {
-			room.Students[i].ActiveStatus = req.Status
-			found = true
-			
-			// Broadcast Update
-			broadcastUpdate(req.RoomID, "ROOM_UPDATE", room)			
+		if s.ID == req.UserSessionID {
+			// Flagging a student after they submitted does not reopen it
+			if s.ActiveStatus == Submitted || s.SubmittedAt != nil {
+				mu.Unlock()
+				writeJSONError(w, http.StatusConflict, codeAlreadySubmitted, "Answers already submitted")
+				return
+			}
+			room.Students[i].Answers = req.Answers
+			if room.Rubric != nil {
+				room.Students[i].Score = room.Rubric.score(req.Answers)
+			}
+			submittedAt := now()
+			room.Students[i].ActiveStatus = Submitted
+			room.Students[i].SubmittedAt = &submittedAt
+			room.Students[i].LastPing = submittedAt
+			idx = i
 			break
 		}
 	}
-
-	if !found {
-		http.Error(w, "User not found in room", http.StatusNotFound)
+	if idx < 0 {
+		mu.Unlock()
+		writeError(w, errUserNotFound)
 		return
 	}
+	broadcastStudent(req.RoomID, room.Students[idx])
+	if room.Rubric != nil {
+		broadcastLeaderboard(room)
+	}
+	mu.Unlock()
+
+	requestSave(req.RoomID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "User status updated successfully",
+		"message": "Answers submitted successfully",
 	})
 }
 
-// GetRoomHandler allows fetching room details (useful for polling)
-func GetRoomHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
+// recordPing refreshes a student's LastPing and brings them back Online if
+// they were Offline. Caller holds mu.
+func (r *Room) recordPing(sessionID string) error {
+	// A kicked student must not come back Online
+	if r.sessionBanned(sessionID) {
+		return errBanned
+	}
+	for i, s := range r.Students {
+		if s.ID == sessionID {
+			r.Students[i].LastPing = now()
+			if s.ActiveStatus == Offline {
+				r.Students[i].ActiveStatus = Online
+				broadcastStudent(r.ID, r.Students[i])
+			}
+			return nil
+		}
+	}
+	return errUserNotFound
+}
+
+// PingHandler is the student heartbeat; it keeps LastPing fresh and brings an
+// Offline student back Online
+func PingHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID        string `json:"room_id"`
+		UserSessionID string `json:"user_session_id"`
+		SessionToken  string `json:"session_token"`
+	}
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	roomID := r.URL.Query().Get("room_id")
+	if err := verifySessionToken(req.RoomID, req.UserSessionID, req.SessionToken); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if err := room.recordPing(req.UserSessionID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "pong",
+	})
+}
+
+// GetRoomHandler allows fetching room details (useful for polling)
+func GetRoomHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	roomID := q.Get("room_id")
 	if roomID == "" {
-		http.Error(w, "room_id is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
 		return
 	}
+	// An admin passing their key sees every set. A student passing their
+	// session sees their own set once the exam is in progress.
+	adminKey, sessionID := q.Get("admin_key"), q.Get("user_session_id")
+	if adminKey == "" && sessionID != "" {
+		if err := verifySessionToken(roomID, sessionID, q.Get("session_token")); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
 
 	mu.RLock()
 	room, exists := rooms[roomID]
+	var view Room
+	var questionURL string
+	var authErr error
+	if exists {
+		view = room.publicView()
+		switch {
+		case adminKey != "":
+			if _, ok := room.adminLabel(adminKey); ok {
+				for k, v := range room.Sets {
+					view.Sets[k] = v
+				}
+				for i, s := range room.Students {
+					view.Students[i].RiskScore, view.Students[i].RiskLevel = s.RiskScore, s.RiskLevel
+					view.Students[i].Flags = append([]FlagRecord(nil), s.Flags...)
+				}
+			} else {
+				authErr = errUnauthorized
+			}
+		case sessionID != "":
+			authErr = errUserNotFound
+			for _, s := range room.Students {
+				if s.ID == sessionID {
+					if questionURL = room.questionURL(s.SelectedSet); questionURL != "" {
+						view.Sets[s.SelectedSet] = questionURL
+					}
+					authErr = nil
+					break
+				}
+			}
+		}
+	}
 	mu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if authErr != nil {
+		writeError(w, authErr)
+		return
+	}
+
+	// Pollers send the last ETag back and skip the body when nothing changed
+	etag := roomETag(view.Version)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
+	timed := timedView(view)
+	timed.QuestionURL = questionURL
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(room)
+	json.NewEncoder(w).Encode(timed)
 }
 
-// GetAllRoomsHandler returns a list of all current rooms (active or waiting)
-func GetAllRoomsHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
+// TimedRoom is a room as /get-room returns it, with the server's clock so a
+// client can correct its countdown for its own clock drift
+type TimedRoom struct {
+	Room
+	ServerTime time.Time `json:"server_time"`
+	Remaining  *Duration `json:"remaining,omitempty"` // Time left until EndTime; omitted when there is none
+
+	// The asking student's set, when user_session_id and session_token are given
+	QuestionURL string `json:"question_url,omitempty"`
+}
+
+// timedView stamps a room view with the server time and the time remaining.
+// Extending an exam moves EndTime, so it is reflected here. Pauses do not
+// stop the clock, so a Paused room keeps counting down.
+func timedView(view Room) TimedRoom {
+	t := TimedRoom{Room: view, ServerTime: now()}
+	t.Remaining = view.remaining(t.ServerTime)
+	return t
+}
+
+// remaining returns the time left at t until EndTime, or nil when the room
+// has no end time
+func (r *Room) remaining(t time.Time) *Duration {
+	if r.EndTime.IsZero() {
+		return nil
+	}
+	left := r.EndTime.Sub(t).Truncate(time.Millisecond)
+	if left < 0 || r.ActiveStatus.Ended() {
+		left = 0
+	}
+	remaining := Duration(left)
+	return &remaining
+}
+
+// ExportRoomHandler returns the full room, including submitted answers, to the admin
+func ExportRoomHandler(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
 		return
 	}
 
 	mu.RLock()
 	defer mu.RUnlock()
 
-	roomList := make([]*Room, 0, len(rooms))
-	for _, room := range rooms {
-		roomList = append(roomList, room)
+	room, exists := rooms[roomID]
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+
+	actor, ok := room.adminLabel(r.URL.Query().Get("admin_key"))
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	// Co-proctors do not get the other admin keys
+	export := *room
+	export.Sets = nonNilMap(export.Sets)
+	export.Students = nonNil(export.Students)
+	if actor != ownerLabel {
+		export.AdminKey = ""
+		export.AdminKeyHash = ""
+		export.AgentKeyHash = ""
+		export.CoProctors = nil
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(roomList)
+	json.NewEncoder(w).Encode(export)
 }
 
-// UpdateRoomHandler allows updating room details
-func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	if r.Method == "OPTIONS" {
+const (
+	defaultRoomPageSize = 50
+	maxRoomPageSize     = 200
+)
+
+// RoomPage is a single page of the room list
+type RoomPage struct {
+	Total  int           `json:"total"` // Rooms matching the filter, across all pages
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+	Rooms  []RoomListing `json:"rooms"`
+}
+
+// RoomListing is a room's public view with the counts the room list shows
+type RoomListing struct {
+	Room
+	StudentCount int `json:"student_count"`
+	OnlineCount  int `json:"online_count"`
+}
+
+// listing returns the room's RoomListing. Caller holds mu.
+func (r *Room) listing() RoomListing {
+	return RoomListing{Room: r.publicView(), StudentCount: len(r.Students), OnlineCount: r.onlineCount()}
+}
+
+// queryInt reads a non-negative integer query parameter, falling back to def
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// roomSorters are the orderings accepted by ?sort= on the room list
+var roomSorters = map[string]func(a, b *Room) bool{
+	"":           func(a, b *Room) bool { return false }, // ID order only
+	"start_time": func(a, b *Room) bool { return a.StartTime.Before(b.StartTime) },
+	"name":       func(a, b *Room) bool { return strings.ToLower(a.SessionName) < strings.ToLower(b.SessionName) },
+	"status":     func(a, b *Room) bool { return a.ActiveStatus < b.ActiveStatus },
+}
+
+// GetAllRoomsHandler returns a page of current rooms (active or waiting),
+// optionally filtered with ?status= and ?host_id= and ordered with
+// ?sort=&order=. Admin keys and answers are never included.
+func GetAllRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultRoomPageSize)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
 		return
 	}
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if limit == 0 || limit > maxRoomPageSize {
+		limit = maxRoomPageSize
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
 		return
 	}
 
+	var statusFilter *StatusEnum
+	if raw := r.URL.Query().Get("status"); raw != "" {
+		status, ok := parseStatusEnum(raw)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Unknown status filter")
+			return
+		}
+		statusFilter = &status
+	}
+
+	less, ok := roomSorters[r.URL.Query().Get("sort")]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "sort must be one of start_time, name or status")
+		return
+	}
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "order must be asc or desc")
+		return
+	}
+	hostID := r.URL.Query().Get("host_id")
+
+	mu.RLock()
+	matched := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		if statusFilter != nil && room.ActiveStatus != *statusFilter {
+			continue
+		}
+		if hostID != "" && room.HostID != hostID {
+			continue
+		}
+		matched = append(matched, room)
+	}
+	// Map iteration is random; pages need a stable order, so ties fall back to the ID
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if order == "desc" {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	})
+
+	page := RoomPage{Total: len(matched), Limit: limit, Offset: offset, Rooms: []RoomListing{}}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		page.Rooms = append(page.Rooms, matched[i].listing())
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// UpdateRoomHandler allows updating room details
+func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		RoomID        string            `json:"room_id"`
 		AdminKey      string            `json:"admin_key"`
 		SessionName   *string           `json:"session_name"`
 		Sets          map[string]string `json:"sets"`
-		TimeAllocated *time.Duration    `json:"time_allocated"`
+		TimeAllocated *Duration         `json:"time_allocated"` // e.g. "90m", or nanoseconds
 		ActiveStatus  *StatusEnum       `json:"active_status"`
+		ScanMode      *ScanModeEnum     `json:"scan_mode"`
+		AllowedApps   []string          `json:"allowed_apps"`
+		SystemApps    []string          `json:"system_apps"`
+		ScanIgnore    []string          `json:"scan_ignore"` // Known safe processes, replacing the global list
+		BlurThreshold *int              `json:"blur_threshold"`
+		BlurWindow    *Duration         `json:"blur_window"`
+		OfflineGrace  *Duration         `json:"offline_grace"` // Zero goes back to the global default
+		ScanInterval  *Duration         `json:"scan_interval"` // Zero goes back to the global default
+		Rubric        *Rubric           `json:"rubric"`        // Replaces the answer key and rescores submissions
+		FlagPolicy    *FlagPolicy       `json:"flag_policy"`
+
+		ShowLeaderboard  *bool `json:"show_leaderboard"`
+		LeaderboardSize  *int  `json:"leaderboard_size"`
+		RequireJoinToken *bool `json:"require_join_token"`
+
+		RequiredFields []string `json:"required_fields"` // Empty lets anyone join without identifying
+
+		// Bounds of the join window; a zero time clears that end
+		JoinOpensAt  *time.Time `json:"join_opens_at"`
+		JoinClosesAt *time.Time `json:"join_closes_at"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -455,12 +1392,98 @@ func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
 
 	room, exists := rooms[req.RoomID]
 	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeError(w, errRoomNotFound)
 		return
 	}
 
-	if room.AdminKey != req.AdminKey {
-		http.Error(w, "Unauthorized: Invalid Admin Key", http.StatusUnauthorized)
+	actor, ok := room.adminLabel(req.AdminKey)
+	if !ok {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	if req.ScanMode != nil && !req.ScanMode.Valid() {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid scan_mode")
+		return
+	}
+	if req.ActiveStatus != nil && !req.ActiveStatus.Valid() {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid active_status")
+		return
+	}
+	if req.ActiveStatus != nil && *req.ActiveStatus == Aborted && room.ActiveStatus != Aborted {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRoomState, "Use /abort-exam to abort an exam")
+		return
+	}
+	if req.ActiveStatus != nil && !canTransition(room.ActiveStatus, *req.ActiveStatus) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRoomState, fmt.Sprintf("A room cannot go from %v to %v", room.ActiveStatus, *req.ActiveStatus))
+		return
+	}
+	if (req.BlurThreshold != nil && *req.BlurThreshold < 0) || (req.BlurWindow != nil && *req.BlurWindow < 0) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "blur_threshold and blur_window must not be negative")
+		return
+	}
+	for _, entry := range req.ScanIgnore {
+		if _, err := compileIgnorePattern(entry); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid scan_ignore entry %q: %v", entry, err))
+			return
+		}
+	}
+	if req.OfflineGrace != nil && *req.OfflineGrace < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "offline_grace must not be negative")
+		return
+	}
+	if req.ScanInterval != nil && *req.ScanInterval < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "scan_interval must not be negative")
+		return
+	}
+	if req.FlagPolicy != nil && req.FlagPolicy.MissedHeartbeats < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "flag_policy.missed_heartbeats must not be negative")
+		return
+	}
+	if req.FlagPolicy != nil && !missedScansActions[req.FlagPolicy.MissedScans] {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "flag_policy.missed_scans must be notify or flag")
+		return
+	}
+	if req.FlagPolicy != nil && req.FlagPolicy.RiskThreshold < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "flag_policy.risk_threshold must not be negative")
+		return
+	}
+	if req.FlagPolicy != nil {
+		if err := validateSeverities(req.FlagPolicy.Severities); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if err := validateRequiredFields(req.RequiredFields); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if req.LeaderboardSize != nil && *req.LeaderboardSize < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "leaderboard_size must not be negative")
+		return
+	}
+	if req.Rubric != nil {
+		if err := req.Rubric.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.TimeAllocated != nil {
+		if *req.TimeAllocated < 0 {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "time_allocated must not be negative")
+			return
+		}
+		// Shrinking a running exam must not put its end in the past
+		if room.ActiveStatus == Active && *req.TimeAllocated > 0 &&
+			!room.StartTime.Add(req.TimeAllocated.Std()).After(now()) {
+			elapsed := now().Sub(room.StartTime).Round(time.Second)
+			writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("time_allocated must exceed the %v already elapsed", elapsed))
+			return
+		}
+	}
+
+	if err := room.setJoinWindow(req.JoinOpensAt, req.JoinClosesAt); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
 		return
 	}
 
@@ -471,23 +1494,74 @@ func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
 	if req.Sets != nil {
 		room.Sets = req.Sets
 	}
+	if req.ScanMode != nil {
+		room.ScanMode = *req.ScanMode
+	}
+	if req.AllowedApps != nil {
+		room.AllowedApps = req.AllowedApps
+	}
+	if req.SystemApps != nil {
+		room.SystemApps = req.SystemApps
+	}
+	if req.ScanIgnore != nil {
+		room.ScanIgnore = req.ScanIgnore
+	}
+	if req.BlurThreshold != nil {
+		room.BlurThreshold = *req.BlurThreshold
+	}
+	if req.BlurWindow != nil {
+		room.BlurWindow = *req.BlurWindow
+	}
+	if req.OfflineGrace != nil {
+		room.OfflineGrace = *req.OfflineGrace
+	}
+	if req.ScanInterval != nil {
+		room.ScanInterval = *req.ScanInterval
+	}
+	if req.FlagPolicy != nil {
+		room.FlagPolicy = req.FlagPolicy
+	}
+	if req.ShowLeaderboard != nil {
+		room.ShowLeaderboard = *req.ShowLeaderboard
+	}
+	if req.LeaderboardSize != nil {
+		room.LeaderboardSize = *req.LeaderboardSize
+	}
+	if req.RequireJoinToken != nil {
+		room.RequireJoinToken = *req.RequireJoinToken
+	}
+	if req.RequiredFields != nil {
+		room.RequiredFields = req.RequiredFields
+	}
+	if req.Rubric != nil {
+		room.Rubric = req.Rubric
+		for i, s := range room.Students {
+			if s.SubmittedAt != nil { // Still a submission once flagged
+				room.Students[i].Score = room.Rubric.score(s.Answers)
+			}
+		}
+	}
 	if req.TimeAllocated != nil {
 		room.TimeAllocated = *req.TimeAllocated
-		// Recalculate end time if active?
+		// Recalculate end time if active; zero means no time limit
 		if room.ActiveStatus == Active {
-			room.EndTime = room.StartTime.Add(room.TimeAllocated)
+			room.EndTime = time.Time{}
+			if room.TimeAllocated > 0 {
+				room.EndTime = room.StartTime.Add(room.TimeAllocated.Std())
+			}
 		}
 	}
 	if req.ActiveStatus != nil {
 		// Logic changes based on status?
 		if *req.ActiveStatus == Active && room.ActiveStatus == Waiting {
-			room.StartTime = time.Now()
+			room.StartTime = now()
 			if room.TimeAllocated > 0 {
-				room.EndTime = room.StartTime.Add(room.TimeAllocated)
+				room.EndTime = room.StartTime.Add(room.TimeAllocated.Std())
 			}
 		}
 		room.ActiveStatus = *req.ActiveStatus
 	}
+	room.audit(actor, "update_room", "")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -495,10 +1569,13 @@ func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Broadcast updates
-	broadcastUpdate(req.RoomID, "ROOM_UPDATE", room)
+	broadcastUpdate(req.RoomID, "ROOM_UPDATE", room.publicView())
+	if req.Rubric != nil || req.ShowLeaderboard != nil || req.LeaderboardSize != nil {
+		broadcastLeaderboard(room)
+	}
 	// Also broadcast list update in case name/status changed
-	broadcastUpdate("all", "ROOM_LIST_UPDATE", nil)
+	broadcastRoomList()
 
 	// Save state
-	go saveRooms()
+	requestSave(req.RoomID)
 }
@@ -3,9 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRoomFlow(t *testing.T) {
@@ -49,21 +55,24 @@ func TestRoomFlow(t *testing.T) {
 	}
 
 	// 3. Admin Update Status
-	updateBody := []byte(`{
-		"room_id": "` + roomID + `",
-		"admin_key": "secret123",
-		"user_id": "user1",
-		"status": 3
-	}`) // 3 is Flagged (Wait, StatusEnum vs UStatusEnum... let's check)
-	// UStatusEnum: Online=0, Offline=1, Submitted=2, Flagged=3
+	// UStatusEnum: Online=0, Offline=1, Submitted=2, Flagged=3. Both the
+	// integer and the name are accepted on input.
+	for _, status := range []string{`3`, `"Flagged"`} {
+		updateBody := []byte(`{
+			"room_id": "` + roomID + `",
+			"admin_key": "secret123",
+			"user_id": "user1",
+			"status": ` + status + `
+		}`)
 
-	req, _ = http.NewRequest("POST", "/admin/update-status", bytes.NewBuffer(updateBody))
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(AdminUpdateUserHandler)
-	handler.ServeHTTP(rr, req)
+		req, _ = http.NewRequest("POST", "/admin/update-status", bytes.NewBuffer(updateBody))
+		rr = httptest.NewRecorder()
+		handler = http.HandlerFunc(AdminUpdateUserHandler)
+		handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("AdminUpdateUser handler returned wrong status code: got %v want %v. Body: %s", status, http.StatusOK, rr.Body.String())
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("AdminUpdateUser handler returned wrong status code: got %v want %v. Body: %s", status, http.StatusOK, rr.Body.String())
+		}
 	}
 
 	// 3.5. Start Exam
@@ -88,17 +97,13 @@ func TestRoomFlow(t *testing.T) {
 
 	var room Room
 	json.Unmarshal(rr.Body.Bytes(), &room)
-	
+
 	found := false
 	for _, s := range room.Students {
 		if s.UserID == "user1" {
 			found = true
-			if s.ActiveStatus != Flagged { // Wait, Flagged is 3.
-				// In Go, enums are just consts. I need to make sure Flagged is visible or just use 3.
-				// Flagged is defined in rooms.go in package main.
-				// Since test is package main, it should be visible if I run `go test .` (which compiles all main package files together).
-				// But `go test rooms_test.go` won't see rooms.go.
-				// I should run `go test -v .`
+			if s.ActiveStatus != Flagged {
+				t.Errorf("User status is %v, want %v", s.ActiveStatus, Flagged)
 			}
 		}
 	}
@@ -106,3 +111,1118 @@ func TestRoomFlow(t *testing.T) {
 		t.Errorf("User not found in room after update")
 	}
 }
+
+// createTestRoom creates a room through the handler and returns its ID
+func createTestRoom(t *testing.T, adminKey string) string {
+	t.Helper()
+	body := []byte(`{"host_id": "host1", "session_name": "Test Session", "admin_key": "` + adminKey + `"}`)
+	req, _ := http.NewRequest("POST", "/create-room", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CreateRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp["room_id"]
+}
+
+// joinTestRoom joins a student to the room and returns the user_session_id
+// and session_token
+func joinTestRoom(t *testing.T, roomID, userID, regNo string) (string, string) {
+	t.Helper()
+	body := []byte(`{"room_id": "` + roomID + `", "user_id": "` + userID + `", "username": "` + userID + `", "regno": "` + regNo + `"}`)
+	req, _ := http.NewRequest("POST", "/join-room", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(JoinRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("JoinRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp["user_session_id"], resp["session_token"]
+}
+
+// rejoinTestRoom reconnects a student with their session token and returns
+// the session ID
+func rejoinTestRoom(t *testing.T, roomID, userID, regNo, token string) string {
+	t.Helper()
+	body := `{"room_id": "` + roomID + `", "user_id": "` + userID + `", "username": "` + userID + `", "regno": "` + regNo + `", "session_token": "` + token + `"}`
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Rejoin returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	id, _ := resp["user_session_id"].(string)
+	return id
+}
+
+func TestSubmitAnswers(t *testing.T) {
+	roomID := createTestRoom(t, "secret123")
+	sessionID, token := joinTestRoom(t, roomID, "submitter", "REG100")
+
+	submitBody := []byte(`{
+		"room_id": "` + roomID + `",
+		"user_session_id": "` + sessionID + `",
+		"session_token": "` + token + `",
+		"answers": {"q1": "B", "q2": "D"}
+	}`)
+	req, _ := http.NewRequest("POST", "/submit", bytes.NewBuffer(submitBody))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SubmitHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Submit returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	// A second submit must not overwrite the first
+	req, _ = http.NewRequest("POST", "/submit", bytes.NewBuffer(submitBody))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SubmitHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Second submit returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+
+	// The public view must not leak answers or the admin key
+	req, _ = http.NewRequest("GET", "/get-room?room_id="+roomID, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(GetRoomHandler).ServeHTTP(rr, req)
+	if bytes.Contains(rr.Body.Bytes(), []byte(`"answers"`)) || bytes.Contains(rr.Body.Bytes(), []byte("secret123")) {
+		t.Errorf("GetRoom leaked private data: %s", rr.Body.String())
+	}
+
+	// The admin export carries the answers
+	req, _ = http.NewRequest("GET", "/admin/export-room?room_id="+roomID+"&admin_key=secret123", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ExportRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ExportRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var exported Room
+	json.Unmarshal(rr.Body.Bytes(), &exported)
+	if len(exported.Students) != 1 || exported.Students[0].ActiveStatus != Submitted {
+		t.Fatalf("Unexpected exported students: %+v", exported.Students)
+	}
+
+	// Answers must survive the same JSON round trip used by saveRooms/loadRooms
+	data, _ := json.Marshal(map[string]*Room{roomID: &exported})
+	var loaded map[string]*Room
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+	var answers map[string]string
+	json.Unmarshal(loaded[roomID].Students[0].Answers, &answers)
+	if answers["q1"] != "B" || answers["q2"] != "D" {
+		t.Errorf("Answers did not round trip: %s", loaded[roomID].Students[0].Answers)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/export-room?room_id="+roomID+"&admin_key=wrong", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ExportRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("ExportRoom with wrong key returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCreateRoomIdempotencyKey(t *testing.T) {
+	create := func(hostID string) string {
+		body := []byte(`{"host_id": "` + hostID + `", "session_name": "Retry", "admin_key": "k", "idempotency_key": "retry-1"}`)
+		req, _ := http.NewRequest("POST", "/create-room", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(CreateRoomHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("CreateRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+		}
+		var resp map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp["room_id"]
+	}
+
+	mu.RLock()
+	before := len(rooms)
+	mu.RUnlock()
+
+	first := create("idem-host")
+	second := create("idem-host")
+	if first == "" || first != second {
+		t.Errorf("Retried create returned %q, want %q", second, first)
+	}
+
+	mu.RLock()
+	after := len(rooms)
+	mu.RUnlock()
+	if after != before+1 {
+		t.Errorf("Expected exactly one new room, got %d", after-before)
+	}
+
+	// The same key from a different host is a different request
+	if other := create("other-host"); other == first {
+		t.Errorf("Key was shared across hosts")
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	roomID := createTestRoom(t, "secret123")
+	sessionID, token := joinTestRoom(t, roomID, "pinger", "REG300")
+	otherID, _ := joinTestRoom(t, roomID, "victim", "REG301")
+
+	ping := func(session, tok string) int {
+		body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + session + `", "session_token": "` + tok + `"}`)
+		req, _ := http.NewRequest("POST", "/ping", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(PingHandler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tampered := []byte(token)
+	if tampered[0] == 'a' {
+		tampered[0] = 'b'
+	} else {
+		tampered[0] = 'a'
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		token   string
+		want    int
+	}{
+		{"valid", sessionID, token, http.StatusOK},
+		{"missing", sessionID, "", http.StatusUnauthorized},
+		{"tampered", sessionID, string(tampered), http.StatusUnauthorized},
+		{"other session", otherID, token, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ping(tt.session, tt.token); got != tt.want {
+				t.Errorf("Ping returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Submitting someone else's session with your own token must fail
+	body := []byte(`{"room_id": "` + roomID + `", "user_session_id": "` + otherID + `", "session_token": "` + token + `", "answers": {}}`)
+	req, _ := http.NewRequest("POST", "/submit", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SubmitHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Spoofed submit returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+// withEmptyRooms swaps in an empty room map for the duration of the test
+func withEmptyRooms(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	prev := rooms
+	rooms = make(map[string]*Room)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		rooms = prev
+		mu.Unlock()
+	})
+}
+
+func getRoomPage(t *testing.T, query string) RoomPage {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/get-all-rooms?"+query, nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(GetAllRoomsHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetAllRooms(%s) returned %v. Body: %s", query, rr.Code, rr.Body.String())
+	}
+	if bytes.Contains(rr.Body.Bytes(), []byte("page-key")) {
+		t.Errorf("GetAllRooms leaked an admin key")
+	}
+
+	var page RoomPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	return page
+}
+
+func TestGetAllRoomsPagination(t *testing.T) {
+	withEmptyRooms(t)
+	ids := []string{}
+	for i := 0; i < 7; i++ {
+		ids = append(ids, createTestRoom(t, "page-key"))
+	}
+	mu.Lock()
+	rooms[ids[0]].ActiveStatus = Active
+	rooms[ids[1]].ActiveStatus = Active
+	mu.Unlock()
+
+	tests := []struct {
+		query string
+		total int
+		count int
+	}{
+		{"limit=3", 7, 3},
+		{"limit=3&offset=3", 7, 3},
+		{"limit=3&offset=6", 7, 1},
+		{"limit=3&offset=7", 7, 0},
+		{"offset=100", 7, 0},
+		{"status=active", 2, 2},
+		{"status=Waiting&limit=2&offset=4", 5, 1},
+	}
+	for _, tt := range tests {
+		page := getRoomPage(t, tt.query)
+		if page.Total != tt.total || len(page.Rooms) != tt.count {
+			t.Errorf("%s: got total %d, %d rooms; want %d, %d", tt.query, page.Total, len(page.Rooms), tt.total, tt.count)
+		}
+	}
+
+	// Consecutive pages must not overlap
+	seen := map[string]bool{}
+	for offset := 0; offset < 7; offset += 2 {
+		for _, room := range getRoomPage(t, "limit=2&offset="+strconv.Itoa(offset)).Rooms {
+			if seen[room.ID] {
+				t.Errorf("Room %s appeared on two pages", room.ID)
+			}
+			seen[room.ID] = true
+		}
+	}
+	if len(seen) != 7 {
+		t.Errorf("Pages covered %d rooms, want 7", len(seen))
+	}
+
+	for _, query := range []string{"limit=-1", "offset=abc", "status=bogus"} {
+		req, _ := http.NewRequest("GET", "/get-all-rooms?"+query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetAllRoomsHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %v, want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetAllRoomsSortAndHostFilter(t *testing.T) {
+	withEmptyRooms(t)
+	base := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	mu.Lock()
+	rooms["R1"] = &Room{ID: "R1", HostID: "h1", SessionName: "beta", ActiveStatus: Active, StartTime: base.Add(2 * time.Hour)}
+	rooms["R2"] = &Room{ID: "R2", HostID: "h1", SessionName: "Alpha", ActiveStatus: Waiting, StartTime: base}
+	rooms["R3"] = &Room{ID: "R3", HostID: "h2", SessionName: "gamma", ActiveStatus: Complete, StartTime: base.Add(time.Hour)}
+	mu.Unlock()
+
+	ids := func(page RoomPage) string {
+		out := ""
+		for _, room := range page.Rooms {
+			out += room.ID + " "
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "R1 R2 R3 "},
+		{"sort=start_time", "R2 R3 R1 "},
+		{"sort=start_time&order=desc", "R1 R3 R2 "},
+		{"sort=name", "R2 R1 R3 "},
+		{"sort=status&order=desc", "R3 R1 R2 "},
+		{"host_id=h1&sort=name", "R2 R1 "},
+		{"host_id=nobody", ""},
+	}
+	for _, tt := range tests {
+		if got := ids(getRoomPage(t, tt.query)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"sort=size", "order=up"} {
+		req, _ := http.NewRequest("GET", "/get-all-rooms?"+query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetAllRoomsHandler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %v, want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestJoinRoomRegNoUniqueness(t *testing.T) {
+	roomID := createTestRoom(t, "secret123")
+	firstID, firstToken := joinTestRoom(t, roomID, "alice", "REG400")
+
+	join := func(userID, regNo, token string) (int, map[string]string) {
+		body := []byte(`{"room_id": "` + roomID + `", "user_id": "` + userID + `", "regno": "` + regNo + `", "session_token": "` + token + `"}`)
+		req, _ := http.NewRequest("POST", "/join-room", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(JoinRoomHandler).ServeHTTP(rr, req)
+		var resp map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	// Same user reconnecting gets their existing session back
+	code, resp := join("alice", "REG400", firstToken)
+	if code != http.StatusOK || resp["user_session_id"] != firstID {
+		t.Errorf("Reconnect returned %v %v, want existing session %s", code, resp, firstID)
+	}
+
+	// A different user with the same regno is rejected
+	if code, _ := join("mallory", "REG400", ""); code != http.StatusConflict {
+		t.Errorf("Duplicate regno returned %v, want %v", code, http.StatusConflict)
+	}
+
+	// Empty regnos never collide with each other
+	if code, _ := join("bob", "", ""); code != http.StatusOK {
+		t.Errorf("First empty regno returned %v", code)
+	}
+	if code, _ := join("carol", "", ""); code != http.StatusOK {
+		t.Errorf("Second empty regno returned %v", code)
+	}
+
+	mu.RLock()
+	count := len(rooms[roomID].Students)
+	mu.RUnlock()
+	if count != 3 {
+		t.Errorf("Room has %d students, want 3", count)
+	}
+}
+
+func TestReconnectRequiresSessionToken(t *testing.T) {
+	roomID := createTestRoom(t, "impostor-key")
+	victimID, victimToken := joinTestRoom(t, roomID, "victim", "REG410")
+	_, otherToken := joinTestRoom(t, roomID, "other", "REG411")
+	tampered := []byte(victimToken)
+	if tampered[0] == 'a' {
+		tampered[0] = 'b'
+	} else {
+		tampered[0] = 'a'
+	}
+	mu.Lock()
+	rooms[roomID].Students[0].ActiveStatus = Offline
+	mu.Unlock()
+
+	// A second client that read the victim's user_id off /get-room
+	impostor := func(token string) *httptest.ResponseRecorder {
+		body := `{"room_id": "` + roomID + `", "user_id": "victim", "username": "victim", "regno": "REG410", "session_token": "` + token + `"}`
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(body)))
+		return rr
+	}
+	for _, tt := range []struct {
+		token  string
+		status int
+		code   string
+	}{
+		{"", http.StatusConflict, codeUserIDInUse},
+		{string(tampered), http.StatusUnauthorized, codeInvalidToken},
+		{otherToken, http.StatusUnauthorized, codeInvalidToken},
+	} {
+		rr := impostor(tt.token)
+		if rr.Code != tt.status || strings.Contains(rr.Body.String(), victimID) {
+			t.Fatalf("Token %q: expected %d without the session, got %d: %s", tt.token, tt.status, rr.Code, rr.Body.String())
+		}
+		if e := decodeAPIError(t, rr); e.Code != tt.code {
+			t.Errorf("Token %q: expected %s, got %+v", tt.token, tt.code, e)
+		}
+	}
+	if status := studentStatus(roomID, "victim"); status != Offline {
+		t.Fatalf("Expected the refused reconnects to leave the victim Offline, got %v", status)
+	}
+
+	// The victim's own token still gets them back in
+	if id := rejoinTestRoom(t, roomID, "victim", "REG410", victimToken); id != victimID {
+		t.Fatalf("Expected the victim to reconnect to %s, got %s", victimID, id)
+	}
+}
+
+func TestStartExamUsesClock(t *testing.T) {
+	start := time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC)
+	useFakeClock(t, start)
+
+	body := []byte(`{"host_id": "host1", "session_name": "Timed", "admin_key": "clock-key", "time_allocated": 5400000000000}`)
+	req, _ := http.NewRequest("POST", "/create-room", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateRoomHandler).ServeHTTP(rr, req)
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	roomID := created["room_id"]
+
+	body = []byte(`{"room_id": "` + roomID + `", "admin_key": "clock-key"}`)
+	req, _ = http.NewRequest("POST", "/start-exam", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(StartExamHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("StartExam returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	mu.RLock()
+	room := rooms[roomID]
+	gotStart, gotEnd, createdAt := room.StartTime, room.EndTime, room.CreatedAt
+	mu.RUnlock()
+	if !gotStart.Equal(start) || !gotEnd.Equal(start.Add(90*time.Minute)) || !createdAt.Equal(start) {
+		t.Errorf("Got start %v, end %v, created %v; want start %v and end 90m later", gotStart, gotEnd, createdAt, start)
+	}
+}
+
+func TestRoomIDCharset(t *testing.T) {
+	prev := roomIDLength
+	roomIDLength = 8
+	t.Cleanup(func() { roomIDLength = prev })
+
+	if strings.ContainsAny(charset, "0O1I") || 256%len(charset) != 0 {
+		t.Fatalf("charset %q must exclude 0, O, 1 and I and divide 256 evenly", charset)
+	}
+	seen := make(map[rune]bool)
+	for i := 0; i < 500; i++ {
+		id, err := generateShortRoomID()
+		if err != nil || len(id) != 8 {
+			t.Fatalf("Got room ID %q, want 8 characters", id)
+		}
+		for _, c := range id {
+			if !strings.ContainsRune(charset, c) {
+				t.Fatalf("Room ID %q contains %q, which is not in the charset", id, c)
+			}
+			seen[c] = true
+		}
+	}
+	if len(seen) != len(charset) {
+		t.Errorf("Only %d of %d characters were ever generated", len(seen), len(charset))
+	}
+	if id, _ := generateID(); len(id) != 2*sessionIDBytes {
+		t.Errorf("generateID() = %q, want %d hex characters", id, 2*sessionIDBytes)
+	}
+}
+
+// failingReader stands in for a broken CSPRNG
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy source unavailable")
+}
+
+func TestRandomFailureIsReported(t *testing.T) {
+	prev := randReader
+	randReader = failingReader{}
+	t.Cleanup(func() { randReader = prev })
+	captureLog(t)
+
+	if id, err := generateID(); err != errNoEntropy || id != "" {
+		t.Errorf("generateID() = %q, %v; want errNoEntropy", id, err)
+	}
+	if id, err := generateShortRoomID(); err != errNoEntropy || id != "" {
+		t.Errorf("generateShortRoomID() = %q, %v; want errNoEntropy", id, err)
+	}
+
+	// Handlers answer with a 500 and create nothing. The request carries its
+	// own ID, since generating one would fail too.
+	mu.RLock()
+	before := len(rooms)
+	mu.RUnlock()
+	req := httptest.NewRequest("POST", "/create-room", strings.NewReader(`{"admin_key": "no-entropy"}`))
+	req.Header.Set(requestIDHeader, "no-entropy")
+	rr := httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 when randomness fails, got %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	after := len(rooms)
+	mu.RUnlock()
+	if after != before {
+		t.Errorf("Expected no room to be created, had %d rooms and now %d", before, after)
+	}
+
+	// Without a client-supplied ID the request ID itself cannot be made
+	rr = httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, httptest.NewRequest("GET", "/get-all-rooms", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 without a request ID, got %d", rr.Code)
+	}
+}
+
+func TestRoomIDCollisions(t *testing.T) {
+	withEmptyRooms(t)
+	prev := newRoomID
+	t.Cleanup(func() { newRoomID = prev })
+
+	mu.Lock()
+	rooms["TAKEN1"] = &Room{ID: "TAKEN1"}
+	mu.Unlock()
+
+	ids := []string{"TAKEN1", "TAKEN1", "FRESH2"}
+	newRoomID = func() (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+	roomID := createTestRoom(t, "collide")
+	if roomID != "FRESH2" {
+		t.Fatalf("Expected the first unused ID, got %q", roomID)
+	}
+
+	// A code space with nothing left fails instead of spinning
+	newRoomID = func() (string, error) { return "TAKEN1", nil }
+	rr := httptest.NewRecorder()
+	CreateRoomHandler(rr, httptest.NewRequest("POST", "/create-room", strings.NewReader(`{"admin_key": "full"}`)))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 when no ID is free, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// Run with -race: joins of distinct and repeated users race each other
+func TestConcurrentJoins(t *testing.T) {
+	roomID := createTestRoom(t, "crowd")
+
+	const students, repeats = 40, 5
+	var wg sync.WaitGroup
+	sessions := make([][]string, students)
+	for i := 0; i < students; i++ {
+		sessions[i] = make([]string, repeats)
+		for j := 0; j < repeats; j++ {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"room_id": %q, "user_id": "crowd-%d", "username": "crowd-%d", "regno": "REG3%03d"}`, roomID, i, i, i)
+				rr := httptest.NewRecorder()
+				JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(body)))
+				// Only one of a user's racing joins gets in; the others hold no token
+				if rr.Code == http.StatusConflict {
+					return
+				}
+				if rr.Code != http.StatusOK {
+					t.Errorf("Join %d/%d returned %d: %s", i, j, rr.Code, rr.Body.String())
+					return
+				}
+				var resp map[string]string
+				json.NewDecoder(rr.Body).Decode(&resp)
+				sessions[i][j] = resp["user_session_id"]
+			}(i, j)
+		}
+	}
+	wg.Wait()
+	flush()
+
+	// Exactly one join of each user got a session
+	for i, ids := range sessions {
+		got := 0
+		for _, id := range ids {
+			if id != "" {
+				got++
+			}
+		}
+		if got != 1 {
+			t.Errorf("crowd-%d got sessions %v, want one", i, ids)
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	room := rooms[roomID]
+	if len(room.Students) != students {
+		t.Fatalf("Room has %d students, want %d", len(room.Students), students)
+	}
+	seenIDs, seenUsers := make(map[string]bool), make(map[string]bool)
+	for _, s := range room.Students {
+		if seenIDs[s.ID] || seenUsers[s.UserID] {
+			t.Errorf("Duplicate session %s for %s", s.ID, s.UserID)
+		}
+		seenIDs[s.ID], seenUsers[s.UserID] = true, true
+	}
+}
+
+func TestJoinIgnoresServerManagedFields(t *testing.T) {
+	roomID := createTestRoom(t, "injector")
+	mu.Lock()
+	rooms[roomID].Sets["A"] = "https://example.com/a"
+	mu.Unlock()
+
+	join := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(`{"room_id": "`+roomID+`", `+body+`}`)))
+		return rr
+	}
+
+	// Server-managed fields are not part of a join and never reach the session
+	injected := []string{
+		`"id": "chosen-id"`,
+		`"active_status": "Submitted"`,
+		`"score": 100`,
+		`"last_ping": "2030-01-01T00:00:00Z"`,
+		`"ip_address": "10.9.9.9"`,
+		`"answers": {"q1": "a"}`,
+		`"flags": []`,
+		`"seed": 1`,
+	}
+	for _, field := range injected {
+		if rr := join(`"user_id": "sneaky", "regno": "REG930", ` + field); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d: %s", field, rr.Code, rr.Body.String())
+		}
+	}
+	mu.RLock()
+	count := len(rooms[roomID].Students)
+	mu.RUnlock()
+	if count != 0 {
+		t.Fatalf("Expected no student from rejected joins, got %d", count)
+	}
+
+	if rr := join(`"user_id": "honest", "username": "Honest", "regno": "REG931", "selected_set": "B"`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an undefined set to be rejected, got %d", rr.Code)
+	}
+	if rr := join(`"user_id": "honest", "username": "Honest", "regno": "REG931", "selected_set": "A"`); rr.Code != http.StatusOK {
+		t.Fatalf("Join returned %d: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	s := rooms[roomID].Students[0]
+	mu.RUnlock()
+	if s.UserID != "honest" || s.Username != "Honest" || s.RegNo != "REG931" || s.SelectedSet != "A" {
+		t.Errorf("Expected the client's identity fields to be kept, got %+v", s)
+	}
+	if s.ActiveStatus != Online || s.Score != 0 || s.Answers != nil || len(s.Flags) != 0 || s.ID == "" {
+		t.Errorf("Expected server defaults for managed fields, got %+v", s)
+	}
+}
+
+func TestStartExamTwice(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "double-start")
+	var added map[string]string
+	json.NewDecoder(postCoProctor(t, AddCoProctorHandler, roomID, "double-start", "deputy").Body).Decode(&added)
+
+	start := func(key string) (int, map[string]interface{}) {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + key + `"}`)
+		rr := httptest.NewRecorder()
+		StartExamHandler(rr, httptest.NewRequest("POST", "/start-exam", bytes.NewBuffer(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	code, first := start("double-start")
+	if code != http.StatusOK {
+		t.Fatalf("First start returned %d: %v", code, first)
+	}
+	advance(2 * time.Second)
+	code, second := start("double-start")
+	if code != http.StatusOK || second["already_started"] != true || second["start_time"] != first["start_time"] {
+		t.Fatalf("Expected the repeated start to report the first one, got %d: %v", code, second)
+	}
+
+	mu.RLock()
+	starts := 0
+	for _, e := range rooms[roomID].AuditLog {
+		if e.Action == "start_exam" {
+			starts++
+		}
+	}
+	mu.RUnlock()
+	if starts != 1 {
+		t.Errorf("Expected one start_exam audit entry, got %d", starts)
+	}
+
+	// A different admin, or the same one much later, is a genuine re-start
+	if code, _ := start(added["key"]); code != http.StatusBadRequest {
+		t.Errorf("Expected a co-proctor's repeat to be rejected, got %d", code)
+	}
+	advance(time.Minute)
+	if code, _ := start("double-start"); code != http.StatusBadRequest {
+		t.Errorf("Expected a late repeat to be rejected, got %d", code)
+	}
+
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Complete
+	mu.Unlock()
+	if code, resp := start("double-start"); code != http.StatusBadRequest {
+		t.Errorf("Expected starting a Complete room to fail, got %d: %v", code, resp)
+	}
+}
+
+func TestGetRoomReportsRemainingTime(t *testing.T) {
+	start := time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC)
+	advance := useFakeClock(t, start)
+	roomID := createTestRoom(t, "countdown")
+
+	getRoom := func() TimedRoom {
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GetRoom returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var got TimedRoom
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := getRoom(); got.Remaining != nil || !got.ServerTime.Equal(start) {
+		t.Fatalf("Expected server time and no remaining time before the start, got %v and %v", got.ServerTime, got.Remaining)
+	}
+
+	setTimeAllocated := func(d string) {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "countdown", "time_allocated": "` + d + `"}`)
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	setTimeAllocated("1h")
+	rr := httptest.NewRecorder()
+	StartExamHandler(rr, httptest.NewRequest("POST", "/start-exam", bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "countdown"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("StartExam returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if got := getRoom(); got.Remaining == nil || *got.Remaining != Duration(time.Hour) {
+		t.Fatalf("Expected 1h remaining at the start, got %v", got.Remaining)
+	}
+	advance(10*time.Minute + 500*time.Millisecond)
+	got := getRoom()
+	if got.Remaining == nil || *got.Remaining != Duration(49*time.Minute+59500*time.Millisecond) {
+		t.Fatalf("Expected 49m59.5s remaining, got %v", got.Remaining)
+	}
+	if !got.ServerTime.Equal(start.Add(10*time.Minute + 500*time.Millisecond)) {
+		t.Errorf("Expected the server clock in the response, got %v", got.ServerTime)
+	}
+
+	// Extending the exam adds to the remaining time
+	setTimeAllocated("1h30m")
+	if got := getRoom(); *got.Remaining != Duration(79*time.Minute+59500*time.Millisecond) {
+		t.Fatalf("Expected the extension to be counted, got %v", got.Remaining)
+	}
+
+	// It never goes negative
+	advance(2 * time.Hour)
+	if got := getRoom(); *got.Remaining != 0 {
+		t.Fatalf("Expected no time remaining after the end, got %v", got.Remaining)
+	}
+}
+
+func TestHumanFriendlyTimeAllocated(t *testing.T) {
+	body := []byte(`{"host_id": "host1", "session_name": "Friendly", "admin_key": "dur-key", "time_allocated": "1h30m"}`)
+	req, _ := http.NewRequest("POST", "/create-room", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateRoomHandler).ServeHTTP(rr, req)
+	var created map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	roomID := created["room_id"]
+
+	mu.RLock()
+	got := rooms[roomID].TimeAllocated.Std()
+	mu.RUnlock()
+	if got != 90*time.Minute {
+		t.Errorf("Create stored %v, want 1h30m", got)
+	}
+
+	body = []byte(`{"room_id": "` + roomID + `", "admin_key": "dur-key", "time_allocated": "45m"}`)
+	req, _ = http.NewRequest("POST", "/update-room", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(UpdateRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/get-room?room_id="+roomID, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(GetRoomHandler).ServeHTTP(rr, req)
+	if !bytes.Contains(rr.Body.Bytes(), []byte(`"time_allocated":"45m0s"`)) {
+		t.Errorf("GetRoom did not return a readable duration: %s", rr.Body.String())
+	}
+
+	body = []byte(`{"room_id": "` + roomID + `", "admin_key": "dur-key", "time_allocated": "soon"}`)
+	req, _ = http.NewRequest("POST", "/update-room", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(UpdateRoomHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Invalid duration returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShrinkTimeBelowElapsed(t *testing.T) {
+	start := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	advance := useFakeClock(t, start)
+	roomID := createTestRoom(t, "shrink-key")
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/update-room", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(UpdateRoomHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := update(`{"room_id": "` + roomID + `", "admin_key": "shrink-key", "time_allocated": "1h", "active_status": "Active"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Starting exam returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+	advance(40 * time.Minute)
+
+	rr := update(`{"room_id": "` + roomID + `", "admin_key": "shrink-key", "time_allocated": "30m"}`)
+	if rr.Code != http.StatusBadRequest || !bytes.Contains(rr.Body.Bytes(), []byte("40m0s already elapsed")) {
+		t.Errorf("Shrinking below elapsed returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := update(`{"room_id": "` + roomID + `", "admin_key": "shrink-key", "time_allocated": "40m"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Ending exactly now returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	mu.RLock()
+	end := rooms[roomID].EndTime
+	mu.RUnlock()
+	if !end.Equal(start.Add(time.Hour)) {
+		t.Errorf("Rejected update changed EndTime to %v", end)
+	}
+
+	if rr := update(`{"room_id": "` + roomID + `", "admin_key": "shrink-key", "time_allocated": "50m"}`); rr.Code != http.StatusOK {
+		t.Errorf("Shrinking above elapsed returned %v. Body: %s", rr.Code, rr.Body.String())
+	}
+	mu.RLock()
+	end = rooms[roomID].EndTime
+	mu.RUnlock()
+	if !end.Equal(start.Add(50 * time.Minute)) {
+		t.Errorf("EndTime = %v, want %v", end, start.Add(50*time.Minute))
+	}
+}
+
+func TestAdminUpdateStatusBatch(t *testing.T) {
+	roomID := createTestRoom(t, "batch-key")
+	joinTestRoom(t, roomID, "b1", "REG1100")
+	joinTestRoom(t, roomID, "b2", "REG1101")
+
+	body := []byte(`{"room_id": "` + roomID + `", "admin_key": "batch-key", "updates": [
+		{"user_id": "b1", "status": "Submitted"},
+		{"user_id": "ghost", "status": "Submitted"},
+		{"user_id": "b2", "status": "Flagged"}
+	]}`)
+	rr := httptest.NewRecorder()
+	AdminUpdateStatusBatchHandler(rr, httptest.NewRequest("POST", "/admin/update-status-batch", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Results []StatusUpdateResult `json:"results"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Results) != 3 || !resp.Results[0].OK || resp.Results[1].OK || !resp.Results[2].OK {
+		t.Fatalf("Unexpected results %+v", resp.Results)
+	}
+	if resp.Results[1].Error != errUserNotFound.Error() {
+		t.Errorf("Expected %q for the unknown user, got %q", errUserNotFound, resp.Results[1].Error)
+	}
+	if studentStatus(roomID, "b1") != Submitted || studentStatus(roomID, "b2") != Flagged {
+		t.Fatalf("Statuses not applied: b1=%v b2=%v", studentStatus(roomID, "b1"), studentStatus(roomID, "b2"))
+	}
+}
+
+func TestGetRoomETag(t *testing.T) {
+	roomID := createTestRoom(t, "etag-key")
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, req)
+		return rr
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d and %q", first.Code, etag)
+	}
+
+	if rr := get(etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("Expected an empty 304 for an unchanged room, got %d: %q", rr.Code, rr.Body.String())
+	}
+
+	joinTestRoom(t, roomID, "etagged", "REG1270")
+	rr := get(etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after the room changed, got %d", rr.Code)
+	}
+	if next := rr.Header().Get("ETag"); next == etag {
+		t.Fatalf("Expected a new ETag after the room changed, still %q", next)
+	}
+
+	// Starting the exam is a change too, and is saved
+	etag = rr.Header().Get("ETag")
+	mu.RLock()
+	version := rooms[roomID].Version
+	mu.RUnlock()
+	start := httptest.NewRecorder()
+	StartExamHandler(start, httptest.NewRequest("POST", "/start-exam", strings.NewReader(`{"room_id": "`+roomID+`", "admin_key": "etag-key"}`)))
+	if start.Code != http.StatusOK {
+		t.Fatalf("StartExam returned %d: %s", start.Code, start.Body.String())
+	}
+	mu.RLock()
+	started := rooms[roomID].Version
+	mu.RUnlock()
+	if started <= version {
+		t.Fatalf("Expected the start to bump version %d, got %d", version, started)
+	}
+	if rr := get(etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("Expected 200 with a new ETag after the start, got %d and %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	flush()
+	if saved, err := readRoomFile(roomFile(roomID)); err != nil || saved.ActiveStatus != Active {
+		t.Fatalf("Expected the start to be saved, got %+v (err %v)", saved, err)
+	}
+}
+
+func TestReconnectRestoresOfflineButNotFlagged(t *testing.T) {
+	advance := useFakeClock(t, time.Date(2026, 8, 1, 9, 0, 0, 0, time.UTC))
+	roomID := createTestRoom(t, "reconnect-key")
+	_, droppedToken := joinTestRoom(t, roomID, "dropped", "REG1350")
+	_, suspectToken := joinTestRoom(t, roomID, "suspect", "REG1351")
+	if err := updateUserStatus(roomID, "reconnect-key", "suspect", Flagged); err != nil {
+		t.Fatalf("Flagging failed: %v", err)
+	}
+	mu.Lock()
+	rooms[roomID].Students[0].ActiveStatus = Offline
+	mu.Unlock()
+	advance(5 * time.Minute)
+
+	rejoin := func(userID, regNo, token string) UserSession {
+		t.Helper()
+		body := `{"room_id": "` + roomID + `", "user_id": "` + userID + `", "regno": "` + regNo + `", "session_token": "` + token + `"}`
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Rejoin returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Session UserSession `json:"session"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Decoding rejoin: %v", err)
+		}
+		return resp.Session
+	}
+
+	s := rejoin("dropped", "REG1350", droppedToken)
+	if s.ActiveStatus != Online || !s.LastPing.Equal(now()) || s.RegNo != "REG1350" {
+		t.Fatalf("Expected the Offline student back Online with a fresh ping, got %+v", s)
+	}
+
+	s = rejoin("suspect", "REG1351", suspectToken)
+	if s.ActiveStatus != Flagged || !s.LastPing.Equal(now()) || len(s.Flags) != 1 {
+		t.Fatalf("Expected the flag to survive a reconnect, got %+v", s)
+	}
+
+	// With the policy off, Online waits for the next heartbeat
+	prev := reconnectRestoresOnline
+	reconnectRestoresOnline = false
+	t.Cleanup(func() { reconnectRestoresOnline = prev })
+	mu.Lock()
+	rooms[roomID].Students[0].ActiveStatus = Offline
+	mu.Unlock()
+	if s := rejoin("dropped", "REG1350", droppedToken); s.ActiveStatus != Offline {
+		t.Fatalf("Expected the student to stay Offline, got %v", s.ActiveStatus)
+	}
+}
+
+func TestSeedStableAcrossGetRoom(t *testing.T) {
+	roomID := createTestRoom(t, "seed-key")
+	rr := httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(`{"room_id": "`+roomID+`", "user_id": "sam"}`)))
+	var joined struct {
+		SessionID string `json:"user_session_id"`
+		Token     string `json:"session_token"`
+		Seed      uint32 `json:"seed"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &joined)
+	if joined.Seed == 0 {
+		t.Fatalf("Expected a seed on join: %s", rr.Body.String())
+	}
+	joinTestRoom(t, roomID, "tom", "")
+
+	seeds := func() map[string]uint32 {
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil))
+		var room Room
+		json.Unmarshal(rr.Body.Bytes(), &room)
+		out := map[string]uint32{}
+		for _, s := range room.Students {
+			out[s.ID] = s.Seed
+		}
+		return out
+	}
+	first, second := seeds(), seeds()
+	if first[joined.SessionID] != joined.Seed || second[joined.SessionID] != joined.Seed {
+		t.Fatalf("Expected seed %d on every GetRoom, got %d and %d", joined.Seed, first[joined.SessionID], second[joined.SessionID])
+	}
+	if len(first) != 2 {
+		t.Fatalf("Expected two students, got %v", first)
+	}
+
+	// Reconnecting keeps the seed
+	rr = httptest.NewRecorder()
+	JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", strings.NewReader(`{"room_id": "`+roomID+`", "user_id": "sam", "session_token": "`+joined.Token+`"}`)))
+	var again struct {
+		Session UserSession `json:"session"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &again)
+	if again.Session.Seed != joined.Seed {
+		t.Fatalf("Expected seed %d on reconnect, got %d", joined.Seed, again.Session.Seed)
+	}
+}
+
+func TestDuplicateSessionNames(t *testing.T) {
+	withEmptyRooms(t)
+	prev := duplicateRoomNames
+	t.Cleanup(func() { duplicateRoomNames = prev })
+
+	create := func(host, name string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		CreateRoomHandler(rr, httptest.NewRequest("POST", "/create-room", bytes.NewBufferString(`{"host_id": "`+host+`", "session_name": "`+name+`", "admin_key": "dup-admin"}`)))
+		return rr
+	}
+	rr := create("host-dup", "Midterm")
+	var first map[string]string
+	json.NewDecoder(rr.Body).Decode(&first)
+
+	// Warn: the room is created, and the response points at the existing one
+	rr = create("host-dup", " midterm ")
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp["existing_room_id"] != first["room_id"] || resp["warning"] == "" {
+		t.Fatalf("Expected a warning naming %s, got %d %v", first["room_id"], rr.Code, resp)
+	}
+
+	// Reject: no room is created, and the error names the existing one
+	setDuplicateRoomNames("reject")
+	rr = create("host-dup", "Midterm")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 for a duplicate name, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeDuplicateRoom || e.RoomID != first["room_id"] {
+		t.Errorf("Expected DUPLICATE_ROOM naming the oldest room %s, got %+v", first["room_id"], e)
+	}
+	mu.RLock()
+	count := len(rooms)
+	mu.RUnlock()
+	if count != 2 {
+		t.Errorf("Expected no room to be created on reject, have %d", count)
+	}
+
+	// Other hosts, and rooms that are Complete, do not count
+	if rr := create("other-host", "Midterm"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another host to reuse the name, got %d", rr.Code)
+	}
+	mu.Lock()
+	for _, room := range rooms {
+		if room.HostID == "host-dup" {
+			room.ActiveStatus = Complete
+		}
+	}
+	mu.Unlock()
+	if rr := create("host-dup", "Midterm"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the name to be free once the rooms are Complete, got %d", rr.Code)
+	}
+
+	setDuplicateRoomNames("off")
+	if rr := create("host-dup", "Midterm"); rr.Code != http.StatusOK {
+		t.Errorf("Expected duplicates allowed when off, got %d", rr.Code)
+	}
+	if err := setDuplicateRoomNames("strict"); err == nil {
+		t.Error("Expected an unknown mode to be refused")
+	}
+}
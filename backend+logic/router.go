@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// route is one endpoint: the verb it accepts, its path and its handler
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// routes lists every endpoint the server exposes
+var routes = []route{
+	{http.MethodGet, "/ws", serveWsHandler},
+	{http.MethodGet, "/events/stream", serveSSEHandler},
+	{http.MethodGet, "/scan", checkProcessesHandler},
+	{http.MethodPost, "/scan/extensions", ScanExtensionsHandler},
+	{http.MethodPost, "/scan/agent", AgentScanHandler},
+	{http.MethodGet, "/admin/forbidden-lists", ForbiddenListsHandler},
+	{http.MethodPost, "/admin/update-forbidden-lists", UpdateForbiddenListsHandler},
+	{http.MethodPost, "/create-room", CreateRoomHandler},
+	{http.MethodPost, "/admin/clone-room", CloneRoomHandler},
+	{http.MethodPost, "/save-template", SaveTemplateHandler},
+	{http.MethodGet, "/templates", ListTemplatesHandler},
+	{http.MethodPost, "/join-room", JoinRoomHandler},
+	{http.MethodPost, "/start-exam", StartExamHandler},
+	{http.MethodPost, "/abort-exam", AbortExamHandler},
+	{http.MethodPost, "/admin/update-status", AdminUpdateUserHandler},
+	{http.MethodPost, "/admin/update-status-batch", AdminUpdateStatusBatchHandler},
+	{http.MethodPost, "/admin/assign-set", AdminAssignSetHandler},
+	{http.MethodPost, "/admin/clear-flag", ClearFlagHandler},
+	{http.MethodPost, "/admin/kick", KickHandler},
+	{http.MethodPost, "/admin/unban", UnbanHandler},
+	{http.MethodPost, "/admin/add-co-proctor", AddCoProctorHandler},
+	{http.MethodPost, "/admin/revoke-co-proctor", RevokeCoProctorHandler},
+	{http.MethodPost, "/admin/rotate-key", RotateKeyHandler},
+	{http.MethodPost, "/admin/join-tokens", IssueJoinTokensHandler},
+	{http.MethodPost, "/admin/agent-key", AgentKeyHandler},
+	{http.MethodPost, "/admin/announce", AnnounceHandler},
+	{http.MethodPost, "/admin/dm", DirectMessageHandler},
+	{http.MethodGet, "/admin/audit-log", AuditLogHandler},
+	{http.MethodGet, "/admin/overview", OverviewHandler},
+	{http.MethodPost, "/submit", SubmitHandler},
+	{http.MethodPost, "/ping", PingHandler},
+	{http.MethodGet, "/get-room", GetRoomHandler},
+	{http.MethodGet, "/get-room-longpoll", LongPollRoomHandler},
+	{http.MethodGet, "/room-observers", RoomObserversHandler},
+	{http.MethodGet, "/set-distribution", SetDistributionHandler},
+	{http.MethodGet, "/admin/export-room", ExportRoomHandler},
+	{http.MethodGet, "/admin/backups", BackupsHandler},
+	{http.MethodPost, "/admin/restore-backup", RestoreBackupHandler},
+	{http.MethodPost, "/upload-evidence", UploadEvidenceHandler},
+	{http.MethodGet, "/evidence", GetEvidenceHandler},
+	{http.MethodPost, "/events/focus", FocusEventHandler},
+	{http.MethodGet, "/get-all-rooms", GetAllRoomsHandler},
+	{http.MethodGet, "/search-rooms", SearchRoomsHandler},
+	{http.MethodPost, "/update-room", UpdateRoomHandler},
+	{http.MethodGet, "/version", VersionHandler},
+}
+
+// newRouter registers routes behind methodGuard and wraps the mux in the
+// shared middleware: request ID, request logging, CORS, the body size limit
+// and panic recovery, outermost first
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.path, methodGuard(rt.handler, rt.method))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Proctor Backend Active. Use /scan to check processes.")
+	})
+	return withRequestID(logRequests(cors(limitBodies(recoverPanics(mux)))))
+}
+
+// limitBodies rejects a request whose declared length exceeds
+// maxRequestBytes with a 413, and caps the body of every other request so a
+// chunked one cannot run past it either
+func limitBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxRequestBytes > 0 {
+			limit := int64(maxRequestBytes)
+			if r.ContentLength > limit {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Request body must not exceed %d bytes", limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors sets the CORS headers on every response and answers OPTIONS
+// preflights itself. Only origins on the allowlist are echoed back, so the
+// browser blocks every other site from reading responses.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && originAllowed(origin) {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT")
+		h.Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, "+requestIDHeader)
+		h.Set("Access-Control-Expose-Headers", "ETag, "+requestIDHeader)
+		if r.Method == http.MethodOptions {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 instead of a dropped
+// connection, logging the stack
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logf(r.Context(), "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			writeJSONError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequests logs the method, path, status and duration of every request
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logf(r.Context(), "%s %s %d %s", r.Method, r.URL.Path, status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// Flush and Hijack through so SSE and websocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testOrigin is on the default allowlist
+const testOrigin = "tauri://localhost"
+
+// originRequest builds a request the way a browser on testOrigin sends it
+func originRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Origin", testOrigin)
+	return req
+}
+
+// captureLog redirects the standard logger into a buffer for the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &buf
+}
+
+func TestRouterCORSAndPreflight(t *testing.T) {
+	captureLog(t)
+	router := newRouter()
+
+	// Preflights are answered by the middleware without touching the handler
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, originRequest("OPTIONS", "/create-room"))
+	if rr.Code != http.StatusOK {
+		t.Errorf("OPTIONS: got status %d, want 200", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("OPTIONS: expected empty body, got %q", rr.Body.String())
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != testOrigin {
+		t.Error("OPTIONS: missing CORS headers")
+	}
+
+	// Errors carry CORS headers too so the browser can read them
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, originRequest("GET", "/get-room"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("GET /get-room without room_id: got status %d, want 400", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != testOrigin {
+		t.Error("400 response is missing CORS headers")
+	}
+}
+
+func TestRouterMethodDispatch(t *testing.T) {
+	captureLog(t)
+	router := newRouter()
+
+	for _, rt := range routes {
+		wrong := http.MethodPost
+		if rt.method == http.MethodPost {
+			wrong = http.MethodGet
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, originRequest(wrong, rt.path))
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: got status %d, want 405", wrong, rt.path, rr.Code)
+		}
+		if got, want := rr.Header().Get("Allow"), rt.method+", OPTIONS"; got != want {
+			t.Errorf("%s %s: got Allow %q, want %q", wrong, rt.path, got, want)
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != testOrigin {
+			t.Errorf("%s %s: 405 is missing CORS headers", wrong, rt.path)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	logs := captureLog(t)
+	h := logRequests(cors(recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, originRequest("GET", "/explode"))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != testOrigin {
+		t.Error("500 response is missing CORS headers")
+	}
+	out := logs.String()
+	if !strings.Contains(out, "panic serving GET /explode: boom") {
+		t.Errorf("panic was not logged: %q", out)
+	}
+	if !strings.Contains(out, "GET /explode 500") {
+		t.Errorf("request log line should record the 500: %q", out)
+	}
+}
+
+func TestLogRequestsKeepsFlusherAndHijacker(t *testing.T) {
+	logs := captureLog(t)
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("wrapped writer is not an http.Flusher")
+		}
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Error("wrapped writer is not an http.Hijacker")
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/ping", nil))
+
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("got status %d, want 418", rr.Code)
+	}
+	if !strings.Contains(logs.String(), "POST /ping 418") {
+		t.Errorf("unexpected log output: %q", logs.String())
+	}
+}
+
+func TestGlobalBodyLimit(t *testing.T) {
+	captureLog(t)
+	prev := maxRequestBytes
+	maxRequestBytes = 1024
+	t.Cleanup(func() { maxRequestBytes = prev })
+	router := newRouter()
+	body := `{"room_id": "` + strings.Repeat("X", 4096) + `"}`
+
+	// Declared too long, rejected before the handler runs
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/update-room", strings.NewReader(body)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for an oversized body, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeBodyTooLarge || !strings.Contains(e.Message, "1024 bytes") {
+		t.Errorf("Unexpected error %+v", e)
+	}
+
+	// A chunked body with no declared length is cut off at the same limit
+	req := httptest.NewRequest("POST", "/update-room", strings.NewReader(body))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for an oversized chunked body, got %d", rr.Code)
+	}
+	if e := decodeAPIError(t, rr); e.Code != codeBodyTooLarge || !strings.Contains(e.Message, "1024 bytes") {
+		t.Errorf("Unexpected error %+v", e)
+	}
+}
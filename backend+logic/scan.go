@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ScanResult struct {
+	Mode           string        `json:"mode"` // "blacklist" or "whitelist"
+	ForbiddenFound bool          `json:"forbidden_found"`
+	Processes      []string      `json:"processes"`            // Matched names, kept for older clients
+	Matches        []ProcessInfo `json:"matches"`              // Full details of every flagged process
+	ScanError      string        `json:"scan_error,omitempty"` // Set when the process list could not be read
+
+	// VM and remote desktop indicators, reported in either scan mode
+	RemoteAccess        []string      `json:"remote_access"`         // Matched remoteAccessApps patterns
+	RemoteAccessMatches []ProcessInfo `json:"remote_access_matches"` // Full details of every matching process
+
+	// Set when a scan agent reported the processes instead of the server listing its own
+	UserSessionID string `json:"user_session_id,omitempty"`
+	Host          string `json:"host,omitempty"`
+}
+
+// forbiddenApps entries are matched against individual process names.
+// A plain entry matches the executable name exactly or followed by a
+// separator ("firefox" matches "firefox-bin"), an entry containing glob
+// characters is matched with path.Match, and an entry prefixed with "re:"
+// is compiled as a case-insensitive regular expression.
+//
+// Scans read the list concurrently, so it is only accessed through
+// getForbiddenApps and setForbiddenApps.
+var forbiddenApps = []string{"firefox", "hotspotshield", "discord", "slack", "spotify", "zen"}
+
+// patternListsMu guards forbiddenApps, remoteAccessApps and
+// forbiddenExtensions. It is separate from mu so scans never wait on room
+// updates.
+var patternListsMu sync.RWMutex
+
+// getForbiddenApps returns a copy of the forbidden app list
+func getForbiddenApps() []string {
+	patternListsMu.RLock()
+	defer patternListsMu.RUnlock()
+	return append([]string(nil), forbiddenApps...)
+}
+
+// setForbiddenApps replaces the forbidden app list with a copy of apps, so
+// the caller may keep using its slice, and saves it to forbidden.json
+func setForbiddenApps(apps []string) {
+	if err := setForbiddenLists(forbiddenLists{ForbiddenApps: apps}); err != nil {
+		log.Println("Error saving forbidden.json:", err)
+	}
+}
+
+// systemApps are ignored in whitelist mode so OS processes are never flagged.
+// A room can replace this list through Room.SystemApps.
+var systemApps = []string{
+	"systemd", "systemd-*", "init", "kthreadd", "kworker/*", "ksoftirqd/*", "migration/*", "cpuhp/*",
+	"rcu_*", "dbus-daemon", "dbus-broker", "sshd", "login", "agetty", "cron", "bash", "sh",
+	"zsh", "fish", "ps", "xorg", "xwayland", "gnome-*", "pipewire*", "pulseaudio", "wireplumber",
+	"networkmanager", "wpa_supplicant", "polkitd", "udisksd", "backend-logic", "server",
+}
+
+// scanIgnore lists known safe processes. Their matches are dropped after the
+// forbidden or whitelist match, to cut false positives such as a harmless
+// tool installed under a path that contains a forbidden name. An entry is a
+// case-insensitive substring of the command line, or "re:" followed by a
+// regular expression. A room can replace this list through Room.ScanIgnore.
+var scanIgnore = []string{}
+
+// defaultScanInterval is how often clients are told to scan a student's
+// machine. A room's ScanInterval overrides it.
+var defaultScanInterval = 30 * time.Second
+
+// scanInterval returns the room's scan interval, or the global default when unset
+func (r *Room) scanInterval() time.Duration {
+	if r.ScanInterval > 0 {
+		return r.ScanInterval.Std()
+	}
+	return defaultScanInterval
+}
+
+// appPattern is a compiled forbidden app entry
+type appPattern struct {
+	Name  string // The configured entry, reported back on a match
+	match func(name string) bool
+}
+
+func compileAppPattern(entry string) (appPattern, error) {
+	p := appPattern{Name: entry}
+	switch {
+	case strings.HasPrefix(entry, "re:"):
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(entry, "re:"))
+		if err != nil {
+			return p, err
+		}
+		p.match = re.MatchString
+	case strings.ContainsAny(entry, "*?["):
+		glob := strings.ToLower(entry)
+		if _, err := path.Match(glob, ""); err != nil {
+			return p, err
+		}
+		p.match = func(name string) bool {
+			ok, _ := path.Match(glob, name)
+			return ok
+		}
+	default:
+		re := regexp.MustCompile(`^` + regexp.QuoteMeta(strings.ToLower(entry)) + `([-_. ].*)?$`)
+		p.match = re.MatchString
+	}
+	return p, nil
+}
+
+// compileIgnorePattern compiles a scan ignore entry, matched against a
+// process's command line
+func compileIgnorePattern(entry string) (appPattern, error) {
+	p := appPattern{Name: entry}
+	if strings.HasPrefix(entry, "re:") {
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(entry, "re:"))
+		if err != nil {
+			return p, err
+		}
+		p.match = re.MatchString
+		return p, nil
+	}
+	if entry == "" {
+		return p, fmt.Errorf("empty entry would ignore every process")
+	}
+	sub := strings.ToLower(entry)
+	p.match = func(cmd string) bool { return strings.Contains(strings.ToLower(cmd), sub) }
+	return p, nil
+}
+
+// compileIgnorePatterns compiles every scan ignore entry, skipping (and logging) invalid ones
+func compileIgnorePatterns(entries []string) []appPattern {
+	patterns := make([]appPattern, 0, len(entries))
+	for _, entry := range entries {
+		p, err := compileIgnorePattern(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid scan ignore pattern %q: %v", entry, err)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// compileAppPatterns compiles every entry, skipping (and logging) invalid ones
+func compileAppPatterns(entries []string) []appPattern {
+	patterns := make([]appPattern, 0, len(entries))
+	for _, entry := range entries {
+		p, err := compileAppPattern(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid forbidden app pattern %q: %v", entry, err)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// ProcessInfo describes a single running process
+type ProcessInfo struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"` // Lowercased executable name used for matching
+	Cmd  string `json:"cmd"`  // Full command line where the platform reports it
+}
+
+// scanTimeout bounds a single process listing so a wedged system cannot
+// pile up stuck scans
+var scanTimeout = 5 * time.Second
+
+// runCommand runs a command and returns its stdout, killing it when ctx is
+// done. Tests replace it.
+var runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// defaultScanSetting picks the scan command or parser for the platform
+const defaultScanSetting = "{default}"
+
+// scanCommand is the process listing command line, split on spaces, and
+// scanParser names the entry of scanParsers that reads its output. Both are
+// set with -scan-command and -scan-parser for containers and custom OSes.
+var (
+	scanCommand = defaultScanSetting
+	scanParser  = defaultScanSetting
+)
+
+// scanParsers turn process listing output into processes
+var scanParsers = map[string]func(string) []ProcessInfo{
+	"ps":       parsePsOutput,
+	"tasklist": parseTasklistOutput,
+	"lines":    parseLinesOutput,
+}
+
+// resolveScan returns the command to run and how to parse its output,
+// filling in the platform defaults
+func resolveScan() (name string, args []string, parse func(string) []ProcessInfo, err error) {
+	parser := scanParser
+	if scanCommand == defaultScanSetting {
+		if runtime.GOOS == "windows" {
+			name, args = "tasklist", []string{"/fo", "csv", "/nh"}
+		} else {
+			// "args" gives the full command line; argv[0] doubles as the process name
+			name, args = "ps", []string{"-eo", "pid,args"}
+		}
+	} else {
+		fields := strings.Fields(scanCommand)
+		if len(fields) == 0 {
+			return "", nil, nil, fmt.Errorf("scan command is empty")
+		}
+		name, args = fields[0], fields[1:]
+	}
+	if parser == defaultScanSetting {
+		parser = "ps"
+		if runtime.GOOS == "windows" {
+			parser = "tasklist"
+		}
+	}
+	parse, ok := scanParsers[parser]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown scan parser %q (want ps, tasklist or lines)", parser)
+	}
+	return name, args, parse, nil
+}
+
+// checkScanConfig validates the scan settings at startup. An unknown parser
+// is an error; a command that cannot be found only warns, since it may be
+// installed later, and each scan then reports it as unavailable.
+func checkScanConfig() error {
+	name, _, _, err := resolveScan()
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		log.Printf("Warning: scan command %q not found: %v", name, err)
+	}
+	return nil
+}
+
+// listProcesses runs the process listing command and parses it
+func listProcesses(ctx context.Context) ([]ProcessInfo, error) {
+	name, args, parse, err := resolveScan()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	output, err := runCommand(ctx, name, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("process listing timed out after %v", scanTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parse(string(output)), nil
+}
+
+// parsePsOutput parses `ps -eo pid,args` output. Leading directories of
+// absolute paths are dropped from the name so a path that merely contains a
+// forbidden word does not match, and kernel threads lose their brackets.
+func parsePsOutput(output string) []ProcessInfo {
+	procs := []ProcessInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// Skips the header row as well
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		args := strings.Join(fields[1:], " ")
+		name := fields[1]
+		if strings.HasPrefix(args, "[") && strings.HasSuffix(args, "]") {
+			name = strings.Trim(args, "[]")
+		} else if strings.HasPrefix(name, "/") {
+			name = path.Base(name)
+		}
+
+		procs = append(procs, ProcessInfo{
+			PID:  pid,
+			Name: strings.ToLower(name),
+			Cmd:  args,
+		})
+	}
+	return procs
+}
+
+// parseTasklistOutput parses `tasklist /fo csv /nh` output, which only
+// reports the image name, so Cmd repeats it.
+func parseTasklistOutput(output string) []ProcessInfo {
+	procs := []ProcessInfo{}
+	records, err := csv.NewReader(strings.NewReader(output)).ReadAll()
+	if err != nil {
+		log.Println("Error parsing tasklist output:", err)
+	}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(record[1])
+		if err != nil {
+			continue
+		}
+		procs = append(procs, ProcessInfo{
+			PID:  pid,
+			Name: strings.ToLower(record[0]),
+			Cmd:  record[0],
+		})
+	}
+	return procs
+}
+
+// parseLinesOutput reads one process name or executable path per line, for
+// custom commands that report nothing else. PIDs are unknown and left at zero.
+func parseLinesOutput(output string) []ProcessInfo {
+	procs := []ProcessInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		procs = append(procs, ProcessInfo{
+			Name: strings.ToLower(path.Base(line)),
+			Cmd:  line,
+		})
+	}
+	return procs
+}
+
+// matchForbidden returns the pattern names that match at least one process
+// along with every process that matched
+func matchForbidden(procs []ProcessInfo, patterns []appPattern) ([]string, []ProcessInfo) {
+	found := []string{}
+	matches := []ProcessInfo{}
+	for _, proc := range procs {
+		if matchesAny(proc.Name, patterns) {
+			matches = append(matches, proc)
+		}
+	}
+	for _, p := range patterns {
+		for _, proc := range matches {
+			if p.match(proc.Name) {
+				found = append(found, p.Name)
+				break
+			}
+		}
+	}
+	return found, matches
+}
+
+// matchUnallowed returns every distinct process name that matches neither an
+// allowed nor an ignored system pattern, along with the processes themselves
+func matchUnallowed(procs []ProcessInfo, allowed, ignored []appPattern) ([]string, []ProcessInfo) {
+	found := []string{}
+	matches := []ProcessInfo{}
+	seen := make(map[string]bool)
+	for _, proc := range procs {
+		if matchesAny(proc.Name, allowed) || matchesAny(proc.Name, ignored) {
+			continue
+		}
+		matches = append(matches, proc)
+		if !seen[proc.Name] {
+			seen[proc.Name] = true
+			found = append(found, proc.Name)
+		}
+	}
+	return found, matches
+}
+
+func matchesAny(name string, patterns []appPattern) bool {
+	for _, p := range patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unignored returns the matched processes no scan ignore pattern covers. A
+// process is tested by its command line, or its name if the platform
+// reported no command line.
+func unignored(matches []ProcessInfo, patterns []appPattern) []ProcessInfo {
+	if len(patterns) == 0 {
+		return matches
+	}
+	kept := []ProcessInfo{}
+	for _, proc := range matches {
+		cmd := proc.Cmd
+		if cmd == "" {
+			cmd = proc.Name
+		}
+		if !matchesAny(cmd, patterns) {
+			kept = append(kept, proc)
+		}
+	}
+	return kept
+}
+
+// evaluateScan applies the room's scan mode to the listed processes, then
+// drops the matches covered by the safe (scan ignore) entries
+func evaluateScan(procs []ProcessInfo, mode ScanModeEnum, allowed, ignored, safe []string) ScanResult {
+	match := func(procs []ProcessInfo) ([]string, []ProcessInfo) {
+		return matchForbidden(procs, compileAppPatterns(getForbiddenApps()))
+	}
+	if mode == Whitelist {
+		allowedPatterns, ignoredPatterns := compileAppPatterns(allowed), compileAppPatterns(ignored)
+		match = func(procs []ProcessInfo) ([]string, []ProcessInfo) {
+			return matchUnallowed(procs, allowedPatterns, ignoredPatterns)
+		}
+	}
+	found, matches := match(procs)
+	// Matching the survivors again keeps the reported names in step with them
+	if kept := unignored(matches, compileIgnorePatterns(safe)); len(kept) < len(matches) {
+		found, matches = match(kept)
+	}
+
+	remote, remoteMatches := matchRemoteAccess(procs)
+
+	return ScanResult{
+		Mode:                mode.String(),
+		ForbiddenFound:      len(found) > 0,
+		Processes:           found,
+		Matches:             matches,
+		RemoteAccess:        remote,
+		RemoteAccessMatches: remoteMatches,
+	}
+}
+
+// scanSettings returns the room's scan mode, allowed apps, ignored system
+// apps and scan ignore list. Caller holds mu.
+func (r *Room) scanSettings() (ScanModeEnum, []string, []string, []string) {
+	ignored, safe := systemApps, scanIgnore
+	if r.SystemApps != nil {
+		ignored = r.SystemApps
+	}
+	if r.ScanIgnore != nil {
+		safe = r.ScanIgnore
+	}
+	return r.ScanMode, r.AllowedApps, ignored, safe
+}
+
+// applyScanPolicy records the scanned student's LastScan, clearing
+// ScanOverdue, and runs the room's forbidden app and remote access triggers
+// for them
+func applyScanPolicy(roomID, sessionID string, result ScanResult) {
+	mu.Lock()
+	defer mu.Unlock()
+	room, exists := rooms[roomID]
+	if !exists {
+		return
+	}
+	for i, s := range room.Students {
+		if s.ID == sessionID {
+			at := now()
+			room.Students[i].LastScan = &at
+			room.Students[i].ScanOverdue = false
+			// Both triggers add to the student's risk, so neither short-circuits the other
+			apps := room.checkForbiddenApps(i, result)
+			remote := room.checkRemoteAccess(i, result)
+			flagged := apps || remote
+			if s.ScanOverdue && !flagged {
+				broadcastStudent(roomID, room.Students[i])
+			}
+			requestSave(roomID)
+			return
+		}
+	}
+}
+
+func checkProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	// An optional room_id selects that room's scan mode. A student scanning
+	// their own machine also passes user_session_id and session_token so the
+	// room's forbidden app and remote access triggers can apply to them.
+	q := r.URL.Query()
+	roomID, sessionID := q.Get("room_id"), q.Get("user_session_id")
+	if sessionID != "" {
+		if err := verifySessionToken(roomID, sessionID, q.Get("session_token")); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	mode := Blacklist
+	var allowed []string
+	ignored, safe := systemApps, scanIgnore
+	if roomID != "" {
+		mu.RLock()
+		room, exists := rooms[roomID]
+		if exists {
+			mode, allowed, ignored, safe = room.scanSettings()
+		}
+		mu.RUnlock()
+
+		if !exists {
+			writeError(w, errRoomNotFound)
+			return
+		}
+	}
+
+	var result ScanResult
+	// The request context cancels the scan if the client goes away
+	procs, err := listProcesses(r.Context())
+	if err != nil {
+		// Still a 200 so the client can tell "scanner broken" from "forbidden app found"
+		logf(r.Context(), "Error listing processes: %v", err)
+		result = ScanResult{
+			Mode:                mode.String(),
+			Processes:           []string{},
+			Matches:             []ProcessInfo{},
+			RemoteAccess:        []string{},
+			RemoteAccessMatches: []ProcessInfo{},
+			ScanError:           "Process scan unavailable: " + err.Error(),
+		}
+	} else {
+		result = evaluateScan(procs, mode, allowed, ignored, safe)
+		if sessionID != "" {
+			applyScanPolicy(roomID, sessionID, result)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const samplePs = `    PID COMMAND
+      1 /sbin/init splash
+      2 [kthreadd]
+    812 /usr/lib/firefox/firefox-bin -contentproc -childID 1
+    950 /home/alice/slack-exports/viewer --open notes.txt
+   1023 Discord --type=renderer
+   1100 zenity --info
+   1200 spotify.exe
+   1300 [kworker/0:1-events]
+`
+
+const sampleTasklist = `"System Idle Process","0","Services","0","8 K"
+"Discord.exe","4412","Console","1","120,344 K"
+"explorer.exe","5120","Console","1","98,100 K"
+`
+
+func processNames(procs []ProcessInfo) []string {
+	names := []string{}
+	for _, p := range procs {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestParsePsOutput(t *testing.T) {
+	procs := parsePsOutput(samplePs)
+	want := []string{"init", "kthreadd", "firefox-bin", "viewer", "discord", "zenity", "spotify.exe", "kworker/0:1-events"}
+	if got := processNames(procs); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePsOutput() names = %v, want %v", got, want)
+	}
+
+	firefox := procs[2]
+	if firefox.PID != 812 || firefox.Cmd != "/usr/lib/firefox/firefox-bin -contentproc -childID 1" {
+		t.Errorf("Unexpected firefox details: %+v", firefox)
+	}
+}
+
+func TestParseTasklistOutput(t *testing.T) {
+	procs := parseTasklistOutput(sampleTasklist)
+	want := []ProcessInfo{
+		{PID: 0, Name: "system idle process", Cmd: "System Idle Process"},
+		{PID: 4412, Name: "discord.exe", Cmd: "Discord.exe"},
+		{PID: 5120, Name: "explorer.exe", Cmd: "explorer.exe"},
+	}
+	if !reflect.DeepEqual(procs, want) {
+		t.Errorf("parseTasklistOutput() = %+v, want %+v", procs, want)
+	}
+
+	found, matches := matchForbidden(procs, compileAppPatterns([]string{"discord"}))
+	if !reflect.DeepEqual(found, []string{"discord"}) || len(matches) != 1 || matches[0].PID != 4412 {
+		t.Errorf("matchForbidden on tasklist = %v, %+v", found, matches)
+	}
+}
+
+func TestMatchForbidden(t *testing.T) {
+	procs := parsePsOutput(samplePs)
+
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+	}{
+		{"literal with suffix", []string{"firefox"}, []string{"firefox"}},
+		{"path containing word", []string{"slack"}, []string{}},
+		{"case insensitive", []string{"discord"}, []string{"discord"}},
+		{"no prefix collision", []string{"zen"}, []string{}},
+		{"extension separator", []string{"spotify"}, []string{"spotify"}},
+		{"regex", []string{"re:^zen(ity)?$"}, []string{"re:^zen(ity)?$"}},
+		{"glob", []string{"fire*"}, []string{"fire*"}},
+		{"invalid regex skipped", []string{"re:(", "firefox"}, []string{"firefox"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := matchForbidden(procs, compileAppPatterns(tt.entries))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchForbidden(%v) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateScanWhitelist(t *testing.T) {
+	procs := parsePsOutput(samplePs)
+
+	result := evaluateScan(procs, Whitelist, []string{"firefox"}, systemApps, nil)
+	want := []string{"viewer", "discord", "zenity", "spotify.exe"}
+	if result.Mode != "whitelist" || !result.ForbiddenFound || !reflect.DeepEqual(result.Processes, want) {
+		t.Errorf("evaluateScan(Whitelist) = %+v, want processes %v", result, want)
+	}
+	if len(result.Matches) != 4 || result.Matches[0].PID != 950 {
+		t.Errorf("Unexpected whitelist matches: %+v", result.Matches)
+	}
+
+	// Allowing everything that is left must produce a clean result
+	result = evaluateScan(procs, Whitelist, []string{"firefox", "viewer", "discord", "re:^zen", "spotify"}, systemApps, nil)
+	if result.ForbiddenFound || len(result.Processes) != 0 {
+		t.Errorf("Expected clean whitelist scan, got %+v", result)
+	}
+
+	result = evaluateScan(procs, Blacklist, nil, nil, nil)
+	if result.Mode != "blacklist" || !reflect.DeepEqual(result.Processes, []string{"firefox", "discord", "spotify"}) {
+		t.Errorf("evaluateScan(Blacklist) = %+v", result)
+	}
+	if len(result.Matches) != 3 || result.Matches[0].PID != 812 {
+		t.Errorf("Unexpected blacklist matches: %+v", result.Matches)
+	}
+}
+
+func TestScanIgnoreSuppressesFalsePositive(t *testing.T) {
+	procs := []ProcessInfo{
+		{PID: 1400, Name: "firefox", Cmd: "/opt/exam-kiosk/firefox --kiosk https://exam.local"},
+		{PID: 1401, Name: "discord", Cmd: "/usr/bin/discord"},
+	}
+
+	result := evaluateScan(procs, Blacklist, nil, nil, nil)
+	if !reflect.DeepEqual(result.Processes, []string{"firefox", "discord"}) {
+		t.Fatalf("Expected both processes flagged without an ignore list, got %+v", result)
+	}
+
+	// The kiosk browser is known safe; the real discord is still reported
+	result = evaluateScan(procs, Blacklist, nil, nil, []string{"/opt/exam-kiosk/"})
+	if !result.ForbiddenFound || !reflect.DeepEqual(result.Processes, []string{"discord"}) || len(result.Matches) != 1 || result.Matches[0].PID != 1401 {
+		t.Errorf("Expected the kiosk browser suppressed, got %+v", result)
+	}
+
+	result = evaluateScan(procs, Whitelist, nil, systemApps, []string{`re:^/usr/bin/`, "EXAM-KIOSK"})
+	if result.ForbiddenFound || len(result.Processes) != 0 {
+		t.Errorf("Expected every whitelist match suppressed, got %+v", result)
+	}
+}
+
+func TestRoomScanIgnoreOverride(t *testing.T) {
+	roomID := createTestRoom(t, "ignore-admin")
+	sessionID, _ := joinTestRoom(t, roomID, "kiosk-user", "REG1374")
+	key := issueAgentKey(t, roomID, "ignore-admin")
+	old := scanIgnore
+	scanIgnore = []string{"/opt/exam-kiosk/"}
+	t.Cleanup(func() { scanIgnore = old })
+
+	scan := func() ScanResult {
+		t.Helper()
+		rr := agentScan(map[string]interface{}{
+			"room_id": roomID, "agent_key": key, "user_session_id": sessionID,
+			"processes": []map[string]interface{}{{"pid": 20, "cmd": "/opt/exam-kiosk/firefox --kiosk"}},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Agent scan returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var result ScanResult
+		json.NewDecoder(rr.Body).Decode(&result)
+		return result
+	}
+	update := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", strings.NewReader(body)))
+		return rr
+	}
+
+	if result := scan(); result.ForbiddenFound {
+		t.Fatalf("Expected the global ignore list to suppress the kiosk browser, got %+v", result)
+	}
+
+	// The room's own list replaces the global one
+	rr := update(`{"room_id": "` + roomID + `", "admin_key": "ignore-admin", "scan_ignore": ["re:^/srv/"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Setting scan_ignore returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if result := scan(); !result.ForbiddenFound {
+		t.Fatalf("Expected the room override to drop the global entry, got %+v", result)
+	}
+
+	rr = update(`{"room_id": "` + roomID + `", "admin_key": "ignore-admin", "scan_ignore": ["re:("]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pattern to be rejected, got %d", rr.Code)
+	}
+	rr = update(`{"room_id": "` + roomID + `", "admin_key": "ignore-admin", "scan_ignore": [""]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an empty entry to be rejected, got %d", rr.Code)
+	}
+}
+
+// Run with -race: scans read the list while it is replaced
+func TestForbiddenAppsConcurrentAccess(t *testing.T) {
+	prev := getForbiddenApps()
+	t.Cleanup(func() { setForbiddenApps(prev) })
+
+	apps := []string{"discord"}
+	setForbiddenApps(apps)
+	apps[0] = "spotify"
+	if got := getForbiddenApps(); !reflect.DeepEqual(got, []string{"discord"}) {
+		t.Fatalf("setForbiddenApps kept the caller's slice: %v", got)
+	}
+	got := getForbiddenApps()
+	got[0] = "spotify"
+	if again := getForbiddenApps(); again[0] != "discord" {
+		t.Fatalf("getForbiddenApps returned the shared slice: %v", again)
+	}
+
+	procs := parsePsOutput(samplePs)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				evaluateScan(procs, Blacklist, nil, nil, nil)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if (i+j)%2 == 0 {
+					setForbiddenApps([]string{"firefox", "discord"})
+				} else {
+					setForbiddenApps([]string{"spotify"})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestScanReportsUnavailableCommand(t *testing.T) {
+	prev := runCommand
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return nil, errors.New(`exec: "` + name + `": executable file not found in $PATH`)
+	}
+	t.Cleanup(func() { runCommand = prev })
+
+	rr := httptest.NewRecorder()
+	checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	var result ScanResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.ScanError == "" || result.ForbiddenFound {
+		t.Fatalf("Expected a scan error and no forbidden apps, got %+v", result)
+	}
+}
+
+func TestScanTimesOut(t *testing.T) {
+	prevRun, prevTimeout := runCommand, scanTimeout
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, "sleep", "10").Output()
+	}
+	scanTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { runCommand, scanTimeout = prevRun, prevTimeout })
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan", nil))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scan was not killed at the deadline, took %v", elapsed)
+	}
+
+	var result ScanResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if !strings.Contains(result.ScanError, "timed out") {
+		t.Fatalf("Expected a timeout scan error, got %+v", result)
+	}
+}
+
+func TestCustomScanCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the fake command")
+	}
+	script := filepath.Join(t.TempDir(), "list-procs")
+	body := "#!/bin/sh\necho \"$1\"\necho /opt/apps/Discord\necho sshd\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	prevCommand, prevParser := scanCommand, scanParser
+	t.Cleanup(func() { scanCommand, scanParser = prevCommand, prevParser })
+	scanCommand, scanParser = script+" code", "lines"
+	if err := checkScanConfig(); err != nil {
+		t.Fatalf("Expected a valid config, got %v", err)
+	}
+
+	procs, err := listProcesses(context.Background())
+	if err != nil {
+		t.Fatalf("Custom command failed: %v", err)
+	}
+	var names []string
+	for _, p := range procs {
+		names = append(names, p.Name)
+	}
+	if want := []string{"code", "discord", "sshd"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Got processes %v, want %v", names, want)
+	}
+
+	rr := httptest.NewRecorder()
+	checkProcessesHandler(rr, httptest.NewRequest("GET", "/scan", nil))
+	var result ScanResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if !result.ForbiddenFound || len(result.Processes) != 1 || result.Processes[0] != "discord" {
+		t.Fatalf("Expected discord to be flagged through the custom command, got %+v", result)
+	}
+
+	scanParser = "xml"
+	if err := checkScanConfig(); err == nil {
+		t.Fatal("Expected an unknown parser to be rejected")
+	}
+	scanCommand, scanParser = "   ", "lines"
+	if _, err := listProcesses(context.Background()); err == nil {
+		t.Fatal("Expected an empty command to be rejected")
+	}
+}
+
+func TestScanIntervalGuidance(t *testing.T) {
+	_, dial := startTestHub(t)
+	roomID := createTestRoom(t, "interval-admin")
+
+	interval := func() Duration {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID, nil))
+		var room Room
+		json.NewDecoder(rr.Body).Decode(&room)
+		return room.ScanInterval
+	}
+	if got := interval(); got.Std() != defaultScanInterval {
+		t.Fatalf("Expected the default interval %v, got %v", defaultScanInterval, got)
+	}
+
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", strings.NewReader(`{"room_id": "`+roomID+`", "admin_key": "interval-admin", "scan_interval": "45s"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Setting scan_interval returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := interval(); got.Std() != 45*time.Second {
+		t.Errorf("Expected the room's interval in /get-room, got %v", got)
+	}
+
+	conn := dial()
+	conn.WriteJSON(map[string]string{"action": "subscribe_room", "room_id": roomID})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg struct {
+			Type    string `json:"type"`
+			Payload struct {
+				ScanInterval Duration `json:"scan_interval"`
+			} `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed waiting for the snapshot: %v", err)
+		}
+		if msg.Type == "ROOM_UPDATE" {
+			if msg.Payload.ScanInterval.Std() != 45*time.Second {
+				t.Errorf("Expected the interval in the snapshot, got %v", msg.Payload.ScanInterval)
+			}
+			break
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", strings.NewReader(`{"room_id": "`+roomID+`", "admin_key": "interval-admin", "scan_interval": "-1s"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a negative interval to be rejected, got %d", rr.Code)
+	}
+}
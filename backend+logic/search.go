@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Match ranks for room search, best first
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+	noMatch
+)
+
+// matchRank reports how well query matches name, ignoring case. A fuzzy
+// match has every character of query in name, in order but not adjacent,
+// so "mth" finds "Maths Final".
+func matchRank(name, query string) int {
+	name, query = strings.ToLower(name), strings.ToLower(query)
+	switch {
+	case name == query:
+		return matchExact
+	case strings.HasPrefix(name, query):
+		return matchPrefix
+	case strings.Contains(name, query):
+		return matchSubstring
+	}
+	rest := name
+	for _, c := range query {
+		i := strings.IndexRune(rest, c)
+		if i < 0 {
+			return noMatch
+		}
+		rest = rest[i+utf8.RuneLen(c):]
+	}
+	return matchFuzzy
+}
+
+// SearchRoomsHandler finds rooms whose session name matches ?q=, optionally
+// limited to one ?host_id=. Exact matches come first, then prefix,
+// substring and fuzzy matches. Results are paged like /get-all-rooms and
+// never include admin keys or answers.
+func SearchRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "q is required")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultRoomPageSize)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if limit == 0 || limit > maxRoomPageSize {
+		limit = maxRoomPageSize
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	hostID := r.URL.Query().Get("host_id")
+
+	type hit struct {
+		room *Room
+		rank int
+	}
+
+	mu.RLock()
+	hits := []hit{}
+	for _, room := range rooms {
+		if hostID != "" && room.HostID != hostID {
+			continue
+		}
+		if rank := matchRank(room.SessionName, query); rank != noMatch {
+			hits = append(hits, hit{room, rank})
+		}
+	}
+	// Ties fall back to the name and then the ID so pages are stable
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		an, bn := strings.ToLower(a.room.SessionName), strings.ToLower(b.room.SessionName)
+		if an != bn {
+			return an < bn
+		}
+		return a.room.ID < b.room.ID
+	})
+
+	page := RoomPage{Total: len(hits), Limit: limit, Offset: offset, Rooms: []RoomListing{}}
+	for i := offset; i < len(hits) && i < offset+limit; i++ {
+		page.Rooms = append(page.Rooms, hits[i].room.listing())
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
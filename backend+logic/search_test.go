@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func searchRooms(t *testing.T, query string) (int, RoomPage) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	SearchRoomsHandler(rr, httptest.NewRequest("GET", "/search-rooms?"+query, nil))
+
+	var page RoomPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	for _, room := range page.Rooms {
+		if room.AdminKey != "" {
+			t.Errorf("%s: search leaked an admin key", query)
+		}
+	}
+	return rr.Code, page
+}
+
+func TestSearchRooms(t *testing.T) {
+	withEmptyRooms(t)
+	mu.Lock()
+	rooms["R1"] = &Room{ID: "R1", HostID: "h1", SessionName: "Maths Final", AdminKey: "k"}
+	rooms["R2"] = &Room{ID: "R2", HostID: "h1", SessionName: "Applied Maths", AdminKey: "k"}
+	rooms["R3"] = &Room{ID: "R3", HostID: "h2", SessionName: "maths", AdminKey: "k"}
+	rooms["R4"] = &Room{ID: "R4", HostID: "h2", SessionName: "Physics Midterm", AdminKey: "k"}
+	mu.Unlock()
+
+	ids := func(page RoomPage) string {
+		out := ""
+		for _, room := range page.Rooms {
+			out += room.ID + " "
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"q=MATHS", "R3 R1 R2 "},         // exact, prefix, substring
+		{"q=maths&host_id=h1", "R1 R2 "}, // host filter
+		{"q=phy+mid", "R4 "},             // substring across words
+		{"q=phmt", "R4 "},                // fuzzy
+		{"q=maths&limit=1&offset=1", "R1 "},
+		{"q=chemistry", ""},
+		{"q=maths&host_id=nobody", ""},
+	}
+	for _, tt := range tests {
+		code, page := searchRooms(t, tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d", tt.query, code)
+		}
+		if got := ids(page); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	if _, page := searchRooms(t, "q=maths&limit=1"); page.Total != 3 {
+		t.Errorf("Expected total 3 across pages, got %d", page.Total)
+	}
+
+	for _, query := range []string{"", "q=+", "q=maths&limit=-1"} {
+		if code, _ := searchRooms(t, query); code != http.StatusBadRequest {
+			t.Errorf("%q returned %d, want 400", query, code)
+		}
+	}
+}
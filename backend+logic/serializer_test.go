@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// useStore points persistence at a fresh data dir with the given format
+func useStore(t testing.TB, format string) {
+	t.Helper()
+	flush()
+	prevDir, prevSerializer := dataDir, storeSerializer
+	if err := setDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := setStoreFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dataDir, storeSerializer = prevDir, prevSerializer
+	})
+}
+
+// sampleRooms builds n rooms of m students each
+func sampleRooms(n, m int) map[string]*Room {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	out := make(map[string]*Room, n)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("R%04d", i)
+		room := &Room{
+			ID:            id,
+			HostID:        "host",
+			SessionName:   "Session " + id,
+			ActiveStatus:  Active,
+			AdminKey:      "key-" + id,
+			TimeAllocated: Duration(90 * time.Minute),
+			StartTime:     start,
+			CreatedAt:     start,
+			UpdatedAt:     start,
+			Sets:          map[string]string{"A": "https://example.com/a"},
+			Rubric:        &Rubric{Questions: map[string]RubricItem{"q1": {Answer: answerList{"B"}, Points: 2}}},
+		}
+		for j := 0; j < m; j++ {
+			room.Students = append(room.Students, UserSession{
+				ID:          fmt.Sprintf("%s-s%d", id, j),
+				UserID:      fmt.Sprintf("user%d", j),
+				Username:    fmt.Sprintf("Student %d", j),
+				RegNo:       fmt.Sprintf("REG%05d", j),
+				SelectedSet: "A",
+				Seed:        uint32(1000 + j),
+				IpAddress:   "10.0.0.1:5000",
+				LastPing:    start.Add(time.Duration(j) * time.Second),
+				Answers:     json.RawMessage(`{"q1":"B"}`),
+				Flags:       []FlagRecord{{At: start, Reason: "tab switch", By: "auto"}},
+			})
+		}
+		out[id] = room
+	}
+	return out
+}
+
+func TestStoreFormatsRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "gob"} {
+		t.Run(format, func(t *testing.T) {
+			withEmptyRooms(t)
+			useStore(t, format)
+
+			want := sampleRooms(3, 5)
+			want["EMPTY"] = &Room{ID: "EMPTY", Students: []UserSession{}, Sets: map[string]string{}, CreatedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), UpdatedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+			mu.Lock()
+			rooms = want
+			mu.Unlock()
+			requestSave()
+			flush()
+
+			mu.Lock()
+			rooms = map[string]*Room{}
+			mu.Unlock()
+			if err := loadRooms(); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.RLock()
+			defer mu.RUnlock()
+			got, _ := json.Marshal(rooms)
+			expected, _ := json.Marshal(want)
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("Round trip changed the rooms:\ngot  %s\nwant %s", got, expected)
+			}
+		})
+	}
+}
+
+func TestStoreFormatDetectedOnLoad(t *testing.T) {
+	withEmptyRooms(t)
+	useStore(t, "gob")
+
+	mu.Lock()
+	rooms = sampleRooms(1, 2)
+	mu.Unlock()
+	requestSave()
+	flush()
+	data, _ := os.ReadFile(roomFile("R0000"))
+	if !bytes.HasPrefix(data, []byte(gobMagic)) {
+		t.Fatal("Expected a gob file")
+	}
+
+	// Switching back to JSON still reads the gob file, then rewrites it as JSON
+	if err := setStoreFormat("json"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	rooms = map[string]*Room{}
+	mu.Unlock()
+	if err := loadRooms(); err != nil {
+		t.Fatal(err)
+	}
+	mu.RLock()
+	n := len(rooms)
+	mu.RUnlock()
+	if n != 1 {
+		t.Fatalf("Expected the gob state to load under the json setting, got %d rooms", n)
+	}
+	requestSave()
+	flush()
+	data, _ = os.ReadFile(roomFile("R0000"))
+	var decoded Room
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID != "R0000" {
+		t.Fatalf("Expected JSON after switching back, got err %v", err)
+	}
+
+	if err := setStoreFormat("xml"); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
+
+func benchmarkStore(b *testing.B, format string) {
+	s := serializers[format]
+	state := sampleRooms(5, 2000)
+
+	b.Run("save", func(b *testing.B) {
+		var buf bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			if err := s.encode(&buf, state); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(buf.Len()), "bytes")
+	})
+
+	var buf bytes.Buffer
+	s.encode(&buf, state)
+	data := buf.Bytes()
+	b.Run("load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var loaded map[string]*Room
+			if err := decodeDetected(bytes.NewReader(data), &loaded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStoreJSON(b *testing.B) { benchmarkStore(b, "json") }
+func BenchmarkStoreGob(b *testing.B)  { benchmarkStore(b, "gob") }
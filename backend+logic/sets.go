@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+)
+
+var errUnknownSet = errors.New("Set is not defined for this room")
+
+// SetMember identifies a student in a set distribution
+type SetMember struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	RegNo    string `json:"regno"`
+}
+
+// SetDistribution lists the students assigned to each of a room's sets.
+// Students with no set, or a set the room no longer defines, are Unassigned.
+type SetDistribution struct {
+	RoomID     string                 `json:"room_id"`
+	Sets       map[string][]SetMember `json:"sets"`
+	Unassigned []SetMember            `json:"unassigned"`
+}
+
+// questionURL returns the question URL of one of the room's sets, or "" when
+// the set is unassigned or no longer defined, or the exam is not in
+// progress. A student is only ever given the URL of their own set. Caller
+// holds mu.
+func (r *Room) questionURL(set string) string {
+	if set == "" || !r.ActiveStatus.InProgress() {
+		return ""
+	}
+	return r.Sets[set]
+}
+
+// distributeSets groups the room's students by SelectedSet. Caller holds mu.
+func distributeSets(room *Room) SetDistribution {
+	dist := SetDistribution{
+		RoomID:     room.ID,
+		Sets:       make(map[string][]SetMember, len(room.Sets)),
+		Unassigned: []SetMember{},
+	}
+	for key := range room.Sets {
+		dist.Sets[key] = []SetMember{}
+	}
+	for _, s := range room.Students {
+		member := SetMember{UserID: s.UserID, Username: s.Username, RegNo: s.RegNo}
+		if _, ok := room.Sets[s.SelectedSet]; ok {
+			dist.Sets[s.SelectedSet] = append(dist.Sets[s.SelectedSet], member)
+		} else {
+			dist.Unassigned = append(dist.Unassigned, member)
+		}
+	}
+	for _, members := range dist.Sets {
+		sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	}
+	return dist
+}
+
+// SetDistributionHandler reports which students are assigned to each set
+func SetDistributionHandler(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "room_id is required")
+		return
+	}
+
+	mu.RLock()
+	room, exists := rooms[roomID]
+	var dist SetDistribution
+	if exists {
+		dist = distributeSets(room)
+	}
+	mu.RUnlock()
+
+	if !exists {
+		writeError(w, errRoomNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dist)
+}
+
+// assignSet moves a student to another of the room's sets and broadcasts the change
+func assignSet(roomID, adminKey, userID, set string) error {
+	mu.Lock()
+	room, exists := rooms[roomID]
+	if !exists {
+		mu.Unlock()
+		return errRoomNotFound
+	}
+	actor, ok := room.adminLabel(adminKey)
+	if !ok {
+		mu.Unlock()
+		return errUnauthorized
+	}
+	if _, ok := room.Sets[set]; !ok {
+		mu.Unlock()
+		return errUnknownSet
+	}
+
+	found := false
+	for i, s := range room.Students {
+		if s.UserID == userID {
+			room.Students[i].SelectedSet = set
+			room.audit(actor, "assign_set", userID+" -> "+set)
+			broadcastStudent(roomID, room.Students[i])
+			found = true
+			break
+		}
+	}
+	mu.Unlock()
+
+	if !found {
+		return errUserNotFound
+	}
+
+	requestSave(roomID)
+	return nil
+}
+
+// AdminAssignSetHandler lets the admin move a student to a different set
+func AdminAssignSetHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		UserID   string `json:"user_id"`
+		Set      string `json:"set"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := assignSet(req.RoomID, req.AdminKey, req.UserID, req.Set); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Set assigned successfully",
+	})
+}
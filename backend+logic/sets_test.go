@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// assignTestSets gives the room the sets A and B and assigns students by user_id
+func assignTestSets(roomID string, assign map[string]string) {
+	mu.Lock()
+	defer mu.Unlock()
+	room := rooms[roomID]
+	room.Sets = map[string]string{"A": "https://example.com/a", "B": "https://example.com/b"}
+	for i, s := range room.Students {
+		room.Students[i].SelectedSet = assign[s.UserID]
+	}
+}
+
+func TestSetDistribution(t *testing.T) {
+	roomID := createTestRoom(t, "set-key")
+	for _, id := range []string{"amy", "ben", "cat", "dan", "eve"} {
+		joinTestRoom(t, roomID, id, "")
+	}
+	assignTestSets(roomID, map[string]string{"amy": "A", "ben": "B", "cat": "A", "dan": "Z"})
+
+	rr := httptest.NewRecorder()
+	SetDistributionHandler(rr, httptest.NewRequest("GET", "/set-distribution?room_id="+roomID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var dist SetDistribution
+	if err := json.NewDecoder(rr.Body).Decode(&dist); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	ids := func(members []SetMember) []string {
+		out := []string{}
+		for _, m := range members {
+			out = append(out, m.UserID)
+		}
+		return out
+	}
+	if got := ids(dist.Sets["A"]); len(got) != 2 || got[0] != "amy" || got[1] != "cat" {
+		t.Errorf("Set A: expected [amy cat], got %v", got)
+	}
+	if got := ids(dist.Sets["B"]); len(got) != 1 || got[0] != "ben" {
+		t.Errorf("Set B: expected [ben], got %v", got)
+	}
+	// An unknown set counts as unassigned
+	if got := ids(dist.Unassigned); len(got) != 2 || got[0] != "dan" || got[1] != "eve" {
+		t.Errorf("Unassigned: expected [dan eve], got %v", got)
+	}
+
+	rr = httptest.NewRecorder()
+	SetDistributionHandler(rr, httptest.NewRequest("GET", "/set-distribution?room_id=missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing room, got %d", rr.Code)
+	}
+}
+
+func TestAdminAssignSet(t *testing.T) {
+	roomID := createTestRoom(t, "assign-key")
+	joinTestRoom(t, roomID, "fay", "")
+	assignTestSets(roomID, map[string]string{"fay": "A"})
+
+	assign := func(adminKey, set string) *httptest.ResponseRecorder {
+		body := []byte(`{"room_id": "` + roomID + `", "admin_key": "` + adminKey + `", "user_id": "fay", "set": "` + set + `"}`)
+		rr := httptest.NewRecorder()
+		AdminAssignSetHandler(rr, httptest.NewRequest("POST", "/admin/assign-set", bytes.NewBuffer(body)))
+		return rr
+	}
+	selected := func() string {
+		mu.RLock()
+		defer mu.RUnlock()
+		return rooms[roomID].Students[0].SelectedSet
+	}
+
+	if rr := assign("assign-key", "B"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := selected(); got != "B" {
+		t.Fatalf("Expected set B, got %q", got)
+	}
+
+	if rr := assign("assign-key", "Z"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown set, got %d", rr.Code)
+	}
+	if rr := assign("wrong", "A"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong key, got %d", rr.Code)
+	}
+	if got := selected(); got != "B" {
+		t.Fatalf("Rejected requests changed the set to %q", got)
+	}
+}
+
+func TestQuestionURLOnJoin(t *testing.T) {
+	roomID := createTestRoom(t, "url-key")
+	assignTestSets(roomID, nil)
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Active
+	mu.Unlock()
+
+	join := func(body string) map[string]interface{} {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		JoinRoomHandler(rr, httptest.NewRequest("POST", "/join-room", bytes.NewBufferString(`{"room_id": "`+roomID+`", `+body+`}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("JoinRoom returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if bytes.Contains(rr.Body.Bytes(), []byte("https://example.com/a")) {
+			t.Fatalf("Join response leaked set A's URL: %s", rr.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := join(`"user_id": "bea", "selected_set": "B"`)
+	if resp["question_url"] != "https://example.com/b" || resp["selected_set"] != "B" {
+		t.Fatalf("Expected set B's URL on join, got %v", resp)
+	}
+	if resp := join(`"user_id": "bea", "session_token": "` + resp["session_token"].(string) + `"`); resp["question_url"] != "https://example.com/b" {
+		t.Fatalf("Expected set B's URL on reconnect, got %v", resp)
+	}
+
+	// Without a set there is no URL until one is assigned
+	resp = join(`"user_id": "cal"`)
+	if _, ok := resp["question_url"]; ok {
+		t.Fatalf("Expected no question_url without a set, got %v", resp)
+	}
+	sessionID, token := resp["user_session_id"].(string), resp["session_token"].(string)
+
+	getRoom := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID+query, nil))
+		return rr
+	}
+	var room TimedRoom
+	json.Unmarshal(getRoom("&user_session_id="+sessionID+"&session_token="+token).Body.Bytes(), &room)
+	if room.QuestionURL != "" {
+		t.Fatalf("Expected no question_url before assignment, got %q", room.QuestionURL)
+	}
+
+	if err := assignSet(roomID, "url-key", "cal", "A"); err != nil {
+		t.Fatalf("assignSet failed: %v", err)
+	}
+	room = TimedRoom{}
+	json.Unmarshal(getRoom("&user_session_id="+sessionID+"&session_token="+token).Body.Bytes(), &room)
+	if room.QuestionURL != "https://example.com/a" {
+		t.Fatalf("Expected set A's URL once assigned, got %q", room.QuestionURL)
+	}
+
+	if rr := getRoom("&user_session_id=" + sessionID + "&session_token=bad"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a bad session token, got %d", rr.Code)
+	}
+	room = TimedRoom{}
+	json.Unmarshal(getRoom("").Body.Bytes(), &room)
+	if room.QuestionURL != "" {
+		t.Fatalf("Expected no question_url without a session, got %q", room.QuestionURL)
+	}
+}
+
+func TestSetsWithheldUntilStart(t *testing.T) {
+	roomID := createTestRoom(t, "withhold-key")
+	sessionID, token := joinTestRoom(t, roomID, "amy", "")
+	assignTestSets(roomID, map[string]string{"amy": "A"})
+
+	getRoom := func(query string) TimedRoom {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GetRoom returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var room TimedRoom
+		json.Unmarshal(rr.Body.Bytes(), &room)
+		return room
+	}
+	student := "&user_session_id=" + sessionID + "&session_token=" + token
+
+	// While the room is Waiting, neither anonymous pollers nor the student see a URL
+	for _, query := range []string{"", student} {
+		if room := getRoom(query); len(room.Sets) != 0 || room.QuestionURL != "" {
+			t.Fatalf("Expected no set URLs while Waiting, got %v %q", room.Sets, room.QuestionURL)
+		}
+	}
+	var list RoomPage
+	rr := httptest.NewRecorder()
+	GetAllRoomsHandler(rr, httptest.NewRequest("GET", "/get-all-rooms", nil))
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	for _, r := range list.Rooms {
+		if r.ID == roomID && len(r.Sets) != 0 {
+			t.Fatalf("Expected no set URLs in the room list, got %v", r.Sets)
+		}
+	}
+
+	// The admin sees every set
+	if room := getRoom("&admin_key=withhold-key"); len(room.Sets) != 2 {
+		t.Fatalf("Expected the admin to see both sets, got %v", room.Sets)
+	}
+	rr = httptest.NewRecorder()
+	GetRoomHandler(rr, httptest.NewRequest("GET", "/get-room?room_id="+roomID+"&admin_key=wrong", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong admin key, got %d", rr.Code)
+	}
+
+	// Once the exam starts the student sees their own set only
+	mu.Lock()
+	rooms[roomID].ActiveStatus = Active
+	mu.Unlock()
+	room := getRoom(student)
+	if len(room.Sets) != 1 || room.Sets["A"] != "https://example.com/a" || room.QuestionURL != "https://example.com/a" {
+		t.Fatalf("Expected only set A once Active, got %v %q", room.Sets, room.QuestionURL)
+	}
+	if room := getRoom(""); len(room.Sets) != 0 {
+		t.Fatalf("Expected no set URLs without a session, got %v", room.Sets)
+	}
+}
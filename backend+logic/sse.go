@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+func serveSSEHandler(w http.ResponseWriter, r *http.Request) {
+	serveSSE(wsHub, w, r)
+}
+
+// serveSSE relays hub broadcasts as Server-Sent Events, for exam browsers
+// that block websockets. The stream is one-way: ?target= is fixed for the
+// life of the connection ("all" by default, or a room ID) and commands still
+// go through the HTTP endpoints.
+func serveSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Streaming unsupported")
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = "all"
+	}
+	if target != "all" {
+		mu.RLock()
+		_, exists := rooms[target]
+		mu.RUnlock()
+		if !exists {
+			writeError(w, errRoomNotFound)
+			return
+		}
+	}
+
+	// An SSE client has no connection of its own; the hub only sees its send channel
+	client := &Client{hub: hub, send: make(chan []byte, hub.config.SendBufferSize), subs: map[string]bool{target: true}}
+	select {
+	case hub.register <- client:
+	case <-hub.done:
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "Server shutting down")
+		return
+	}
+	defer func() {
+		select {
+		case hub.unregister <- client:
+		case <-hub.done:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Deltas need a base to apply to, as with subscribe_room
+	if target != "all" {
+		client.sendSnapshot(target)
+	}
+
+	// Comment lines keep proxies from closing an idle stream
+	ticker := time.NewTicker(hub.config.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub dropped the client or is shutting down
+				return
+			}
+			if _, err := w.Write(append(append([]byte("data: "), message...), '\n', '\n')); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
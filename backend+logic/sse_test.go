@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEStreamRelaysRoomUpdates(t *testing.T) {
+	roomID := createTestRoom(t, "sse-key")
+	hub, _ := startTestHub(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(hub, w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events/stream?target=missing")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a missing room, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events/stream?target="+roomID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() Message {
+		t.Helper()
+		for lines.Scan() {
+			data, ok := strings.CutPrefix(lines.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var msg Message
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatalf("Bad data frame %q: %v", data, err)
+			}
+			return msg
+		}
+		t.Fatalf("Stream ended: %v", lines.Err())
+		return Message{}
+	}
+
+	if msg := next(); msg.Type != "ROOM_UPDATE" || msg.Target != roomID {
+		t.Fatalf("Expected a ROOM_UPDATE snapshot first, got %+v", msg)
+	}
+
+	joinTestRoom(t, roomID, "streamed", "REG1250")
+	if msg := next(); msg.Type != "ROOM_DELTA" || msg.Target != roomID {
+		t.Fatalf("Expected the join as a ROOM_DELTA, got %+v", msg)
+	}
+
+	// Disconnecting releases the hub subscription
+	cancel()
+	waitFor(t, "the SSE client to be forgotten", func() bool { return hub.observerCounts()[roomID] == 0 })
+}
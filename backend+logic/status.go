@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StatusEnum defines the current state of the Exam Room
+type StatusEnum int
+
+const (
+	Waiting StatusEnum = iota
+	Active
+	NetworkLoss
+	Paused
+	Complete
+	Aborted // Ended early by an admin; its results do not count
+)
+
+var statusNames = map[StatusEnum]string{
+	Waiting:     "Waiting",
+	Active:      "Active",
+	NetworkLoss: "NetworkLoss",
+	Paused:      "Paused",
+	Complete:    "Complete",
+	Aborted:     "Aborted",
+}
+
+// Valid reports whether s is one of the defined room states
+func (s StatusEnum) Valid() bool {
+	_, ok := statusNames[s]
+	return ok
+}
+
+func (s StatusEnum) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("StatusEnum(%d)", int(s))
+}
+
+// Ended reports whether the room is over, whether it ran its course or was aborted
+func (s StatusEnum) Ended() bool {
+	return s == Complete || s == Aborted
+}
+
+// InProgress reports whether the exam has started and not yet ended
+func (s StatusEnum) InProgress() bool {
+	return s == Active || s == NetworkLoss || s == Paused
+}
+
+// roomTransitions lists the states a room may move to from each state.
+// Complete and Aborted are final.
+var roomTransitions = map[StatusEnum][]StatusEnum{
+	Waiting:     {Active, Complete, Aborted},
+	Active:      {Paused, NetworkLoss, Complete, Aborted},
+	Paused:      {Active, Complete, Aborted},
+	NetworkLoss: {Active, Paused, Complete, Aborted},
+}
+
+// canTransition reports whether a room may move from one state to another.
+// Staying in the same state is always allowed.
+func canTransition(from, to StatusEnum) bool {
+	if from == to {
+		return from.Valid()
+	}
+	for _, next := range roomTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusEnum accepts a room status by name (case-insensitive) or number
+func parseStatusEnum(raw string) (StatusEnum, bool) {
+	for status, name := range statusNames {
+		if strings.EqualFold(raw, name) || raw == strconv.Itoa(int(status)) {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
+// MarshalJSON emits the status name, e.g. "Active"
+func (s StatusEnum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts either the status name or, for older clients and
+// data files, its integer value
+func (s *StatusEnum) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalEnum(data, statusNames, reflect.TypeOf(*s))
+	if err != nil {
+		return wrongStatusKind(data, userStatusNames, "room", err)
+	}
+	*s = StatusEnum(n)
+	return nil
+}
+
+// UStatusEnum defines the state of an individual student
+type UStatusEnum int
+
+const (
+	Online UStatusEnum = iota
+	Offline
+	Submitted
+	Flagged
+)
+
+var userStatusNames = map[UStatusEnum]string{
+	Online:    "Online",
+	Offline:   "Offline",
+	Submitted: "Submitted",
+	Flagged:   "Flagged",
+}
+
+// Valid reports whether s is one of the defined student states
+func (s UStatusEnum) Valid() bool {
+	_, ok := userStatusNames[s]
+	return ok
+}
+
+func (s UStatusEnum) String() string {
+	if name, ok := userStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UStatusEnum(%d)", int(s))
+}
+
+// MarshalJSON emits the status name, e.g. "Flagged"
+func (s UStatusEnum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts either the status name or its integer value
+func (s *UStatusEnum) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalEnum(data, userStatusNames, reflect.TypeOf(*s))
+	if err != nil {
+		return wrongStatusKind(data, statusNames, "student", err)
+	}
+	*s = UStatusEnum(n)
+	return nil
+}
+
+// statusKindError is a room status name sent where a student status is
+// expected, or the other way round. Both enums are small integers on the
+// wire, so only their names can be told apart; integers valid for both are
+// still accepted for older clients and range checked by Valid.
+type statusKindError struct {
+	Value string // As sent
+	Want  string // "room" or "student"
+}
+
+func (e *statusKindError) Error() string {
+	return fmt.Sprintf("%s is not a %s status", e.Value, e.Want)
+}
+
+// wrongStatusKind turns err into a statusKindError when data names a status
+// of the other kind
+func wrongStatusKind[E ~int](data []byte, other map[E]string, want string, err error) error {
+	var raw string
+	if json.Unmarshal(data, &raw) != nil {
+		return err
+	}
+	for _, name := range other {
+		if strings.EqualFold(raw, name) {
+			return &statusKindError{Value: string(data), Want: want}
+		}
+	}
+	return err
+}
+
+// unmarshalEnum decodes a JSON integer as-is (range checks are left to
+// Valid) or a JSON string matched case-insensitively against names
+func unmarshalEnum[E ~int](data []byte, names map[E]string, typ reflect.Type) (int, error) {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		return n, nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		for value, name := range names {
+			if strings.EqualFold(raw, name) {
+				return int(value), nil
+			}
+		}
+	}
+	return 0, &json.UnmarshalTypeError{Value: string(data), Type: typ}
+}
+
+// ScanModeEnum defines how process scans are evaluated for a room
+type ScanModeEnum int
+
+const (
+	Blacklist ScanModeEnum = iota // Flag processes matching forbiddenApps
+	Whitelist                     // Flag every process not in AllowedApps
+)
+
+// Valid reports whether m is one of the defined scan modes
+func (m ScanModeEnum) Valid() bool {
+	return m == Blacklist || m == Whitelist
+}
+
+func (m ScanModeEnum) String() string {
+	if m == Whitelist {
+		return "whitelist"
+	}
+	return "blacklist"
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusEnumBounds(t *testing.T) {
+	tests := []struct {
+		status StatusEnum
+		valid  bool
+		name   string
+	}{
+		{Waiting, true, "Waiting"},
+		{Complete, true, "Complete"},
+		{Aborted, true, "Aborted"},
+		{-1, false, "StatusEnum(-1)"},
+		{Aborted + 1, false, "StatusEnum(6)"},
+		{99, false, "StatusEnum(99)"},
+	}
+	for _, tt := range tests {
+		if got := tt.status.Valid(); got != tt.valid {
+			t.Errorf("StatusEnum(%d).Valid() = %v, want %v", int(tt.status), got, tt.valid)
+		}
+		if got := tt.status.String(); got != tt.name {
+			t.Errorf("StatusEnum(%d).String() = %q, want %q", int(tt.status), got, tt.name)
+		}
+	}
+}
+
+func TestUStatusEnumBounds(t *testing.T) {
+	tests := []struct {
+		status UStatusEnum
+		valid  bool
+		name   string
+	}{
+		{Online, true, "Online"},
+		{Flagged, true, "Flagged"},
+		{-1, false, "UStatusEnum(-1)"},
+		{Flagged + 1, false, "UStatusEnum(4)"},
+	}
+	for _, tt := range tests {
+		if got := tt.status.Valid(); got != tt.valid {
+			t.Errorf("UStatusEnum(%d).Valid() = %v, want %v", int(tt.status), got, tt.valid)
+		}
+		if got := tt.status.String(); got != tt.name {
+			t.Errorf("UStatusEnum(%d).String() = %q, want %q", int(tt.status), got, tt.name)
+		}
+	}
+}
+
+func TestInvalidStatusRejected(t *testing.T) {
+	roomID := createTestRoom(t, "enum-key")
+	joinTestRoom(t, roomID, "enum-student", "REG500")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    int
+	}{
+		{"user status 99", AdminUpdateUserHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "user_id": "enum-student", "status": 99}`, http.StatusBadRequest},
+		{"user status -1", AdminUpdateUserHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "user_id": "enum-student", "status": -1}`, http.StatusBadRequest},
+		{"user status max", AdminUpdateUserHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "user_id": "enum-student", "status": 3}`, http.StatusOK},
+		{"room status 99", UpdateRoomHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "active_status": 99}`, http.StatusBadRequest},
+		{"room status 6", UpdateRoomHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "active_status": 6}`, http.StatusBadRequest},
+		{"scan mode 2", UpdateRoomHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "scan_mode": 2}`, http.StatusBadRequest},
+		{"room status Complete as user status", AdminUpdateUserHandler, `{"room_id": "` + roomID + `", "admin_key": "enum-key", "user_id": "enum-student", "status": 4}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Got %v, want %v. Body: %s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+
+	mu.RLock()
+	status := rooms[roomID].ActiveStatus
+	mu.RUnlock()
+	if status != Waiting {
+		t.Errorf("Invalid update changed room status to %v", status)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	data, _ := json.Marshal(UserSession{ActiveStatus: Flagged})
+	if !bytes.Contains(data, []byte(`"active_status":"Flagged"`)) {
+		t.Errorf("UserSession marshalled as %s", data)
+	}
+	data, _ = json.Marshal(Room{ActiveStatus: NetworkLoss})
+	if !bytes.Contains(data, []byte(`"active_status":"NetworkLoss"`)) {
+		t.Errorf("Room marshalled as %s", data)
+	}
+
+	// rooms.json written before the switch used integers; both must load
+	for _, raw := range []string{`1`, `"Active"`, `"active"`} {
+		var room Room
+		if err := json.Unmarshal([]byte(`{"active_status": `+raw+`, "students": [{"active_status": 3}]}`), &room); err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", raw, err)
+		}
+		if room.ActiveStatus != Active || room.Students[0].ActiveStatus != Flagged {
+			t.Errorf("Unmarshal(%s) = %v / %v", raw, room.ActiveStatus, room.Students[0].ActiveStatus)
+		}
+
+		// And round trip through the current format
+		data, _ := json.Marshal(room)
+		var again Room
+		json.Unmarshal(data, &again)
+		if again.ActiveStatus != Active || again.Students[0].ActiveStatus != Flagged {
+			t.Errorf("Round trip of %s lost the status: %s", raw, data)
+		}
+	}
+
+	var status UStatusEnum
+	if err := json.Unmarshal([]byte(`"Sleeping"`), &status); err == nil {
+		t.Errorf("Unknown status name was accepted")
+	}
+}
+
+func TestStatusKindsNotConfused(t *testing.T) {
+	roomID := createTestRoom(t, "kind-key")
+	joinTestRoom(t, roomID, "kind-student", "REG1360")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		message string
+	}{
+		{"room status as user status", AdminUpdateUserHandler, `{"room_id": "` + roomID + `", "admin_key": "kind-key", "user_id": "kind-student", "status": "Paused"}`, `"Paused" is not a student status`},
+		{"user status as room status", UpdateRoomHandler, `{"room_id": "` + roomID + `", "admin_key": "kind-key", "active_status": "flagged"}`, `"flagged" is not a room status`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, httptest.NewRequest("POST", "/", bytes.NewBufferString(tt.body)))
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Got %v, want 400. Body: %s", rr.Code, rr.Body.String())
+			}
+			if got := decodeAPIError(t, rr); got.Message != tt.message {
+				t.Errorf("Got message %q, want %q", got.Message, tt.message)
+			}
+		})
+	}
+
+	if status := studentStatus(roomID, "kind-student"); status != Online {
+		t.Errorf("Rejected update changed the student to %v", status)
+	}
+}
+
+func TestRoomTransitions(t *testing.T) {
+	all := []StatusEnum{Waiting, Active, NetworkLoss, Paused, Complete, Aborted}
+	// legal[from] lists the states each one may move to, besides itself
+	legal := map[StatusEnum][]StatusEnum{
+		Waiting:     {Active, Complete, Aborted},
+		Active:      {NetworkLoss, Paused, Complete, Aborted},
+		NetworkLoss: {Active, Paused, Complete, Aborted},
+		Paused:      {Active, Complete, Aborted},
+		Complete:    {},
+		Aborted:     {},
+	}
+	for _, from := range all {
+		for _, to := range all {
+			want := from == to
+			for _, next := range legal[from] {
+				want = want || next == to
+			}
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%v, %v) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if canTransition(StatusEnum(99), StatusEnum(99)) {
+		t.Error("Expected an unknown status to have no transitions")
+	}
+
+	roomID := createTestRoom(t, "transition-key")
+	update := func(status string) int {
+		rr := httptest.NewRecorder()
+		UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", strings.NewReader(`{"room_id": "`+roomID+`", "admin_key": "transition-key", "active_status": "`+status+`"}`)))
+		return rr.Code
+	}
+	for _, step := range []struct {
+		status string
+		want   int
+	}{
+		{"Paused", http.StatusBadRequest},
+		{"Active", http.StatusOK},
+		{"Waiting", http.StatusBadRequest},
+		{"Paused", http.StatusOK},
+		{"Active", http.StatusOK},
+		{"Complete", http.StatusOK},
+		{"Active", http.StatusBadRequest},
+		{"Complete", http.StatusOK},
+	} {
+		if got := update(step.status); got != step.want {
+			t.Errorf("Moving to %s returned %d, want %d", step.status, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File name for saved templates, inside dataDir
+const templatesFile = "templates.json"
+
+// Template is a reusable room configuration. It never carries students,
+// keys or anything else tied to a particular sitting. Its sets and rubric
+// are the questions and answers, so only the admin key it was saved with,
+// kept as KeyHash, or the master key may list or use it.
+type Template struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	HostID           string            `json:"host_id"`
+	KeyHash          string            `json:"key_hash,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	Sets             map[string]string `json:"sets,omitempty"` // Never listed, only copied into new rooms
+	TimeAllocated    Duration          `json:"time_allocated"`
+	ScanMode         ScanModeEnum      `json:"scan_mode"`
+	AllowedApps      []string          `json:"allowed_apps,omitempty"`
+	SystemApps       []string          `json:"system_apps,omitempty"`
+	ScanIgnore       []string          `json:"scan_ignore,omitempty"`
+	BlurThreshold    int               `json:"blur_threshold,omitempty"`
+	BlurWindow       Duration          `json:"blur_window,omitempty"`
+	OfflineGrace     Duration          `json:"offline_grace,omitempty"`
+	ScanInterval     Duration          `json:"scan_interval,omitempty"`
+	FlagPolicy       *FlagPolicy       `json:"flag_policy,omitempty"`
+	Rubric           *Rubric           `json:"rubric,omitempty"` // Never listed, only copied into new rooms
+	ShowLeaderboard  bool              `json:"show_leaderboard,omitempty"`
+	LeaderboardSize  int               `json:"leaderboard_size,omitempty"`
+	RequiredFields   []string          `json:"required_fields,omitempty"`
+	RequireJoinToken bool              `json:"require_join_token,omitempty"`
+}
+
+var (
+	templates   = make(map[string]*Template)
+	templatesMu sync.RWMutex
+)
+
+// templateFromRoom copies a room's configuration, to be used with key.
+// Caller holds mu.
+func templateFromRoom(room *Room, name, key string) (*Template, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{
+		ID:               id,
+		Name:             name,
+		HostID:           room.HostID,
+		KeyHash:          hashAdminKey(key),
+		CreatedAt:        now(),
+		Sets:             make(map[string]string, len(room.Sets)),
+		TimeAllocated:    room.TimeAllocated,
+		ScanMode:         room.ScanMode,
+		AllowedApps:      append([]string(nil), room.AllowedApps...),
+		SystemApps:       append([]string(nil), room.SystemApps...),
+		ScanIgnore:       append([]string(nil), room.ScanIgnore...),
+		BlurThreshold:    room.BlurThreshold,
+		BlurWindow:       room.BlurWindow,
+		OfflineGrace:     room.OfflineGrace,
+		ScanInterval:     room.ScanInterval,
+		FlagPolicy:       room.FlagPolicy.clone(),
+		Rubric:           room.Rubric.clone(),
+		ShowLeaderboard:  room.ShowLeaderboard,
+		LeaderboardSize:  room.LeaderboardSize,
+		RequiredFields:   append([]string(nil), room.RequiredFields...),
+		RequireJoinToken: room.RequireJoinToken,
+	}
+	for k, v := range room.Sets {
+		t.Sets[k] = v
+	}
+	return t, nil
+}
+
+// allows reports whether key may list and use the template: the key it was
+// saved with, or the master key. Templates saved before keys were recorded
+// are left to the master key.
+func (t *Template) allows(key string) bool {
+	if isMasterKey(key) {
+		return true
+	}
+	return t.KeyHash != "" && subtle.ConstantTimeCompare([]byte(hashAdminKey(key)), []byte(t.KeyHash)) == 1
+}
+
+// apply prefills a new room from the template. Caller holds templatesMu.
+func (t *Template) apply(room *Room) {
+	for k, v := range t.Sets {
+		room.Sets[k] = v
+	}
+	room.TimeAllocated = t.TimeAllocated
+	room.ScanMode = t.ScanMode
+	room.AllowedApps = append([]string(nil), t.AllowedApps...)
+	room.SystemApps = append([]string(nil), t.SystemApps...)
+	room.ScanIgnore = append([]string(nil), t.ScanIgnore...)
+	room.BlurThreshold = t.BlurThreshold
+	room.BlurWindow = t.BlurWindow
+	room.OfflineGrace = t.OfflineGrace
+	room.ScanInterval = t.ScanInterval
+	room.FlagPolicy = t.FlagPolicy.clone()
+	room.Rubric = t.Rubric.clone()
+	room.ShowLeaderboard = t.ShowLeaderboard
+	room.LeaderboardSize = t.LeaderboardSize
+	room.RequiredFields = append([]string(nil), t.RequiredFields...)
+	room.RequireJoinToken = t.RequireJoinToken
+}
+
+func loadTemplates() {
+	if inMemory {
+		return
+	}
+	file, err := os.Open(dataPath(templatesFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading templates.json:", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var loaded map[string]*Template
+	if err := json.NewDecoder(file).Decode(&loaded); err != nil {
+		log.Println("Error decoding templates.json:", err)
+		return
+	}
+
+	templatesMu.Lock()
+	templates = loaded
+	templatesMu.Unlock()
+}
+
+// saveTemplates rewrites templates.json through a temp file so a crash
+// never leaves it half written. Templates change rarely, so this is done
+// directly rather than through the room saver. Caller holds templatesMu.
+func saveTemplates() {
+	if inMemory {
+		return
+	}
+	if err := writeTemplatesFile(); err != nil {
+		log.Println("Error saving templates.json:", err)
+	}
+}
+
+func writeTemplatesFile() error {
+	data, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dataDir, templatesFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dataPath(templatesFile))
+}
+
+// SaveTemplateHandler stores a room's configuration as a named template
+func SaveTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID   string `json:"room_id"`
+		AdminKey string `json:"admin_key"`
+		Name     string `json:"name"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, "name is required")
+		return
+	}
+
+	mu.RLock()
+	room, exists := rooms[req.RoomID]
+	if !exists {
+		mu.RUnlock()
+		writeError(w, errRoomNotFound)
+		return
+	}
+	if _, ok := room.adminLabel(req.AdminKey); !ok {
+		mu.RUnlock()
+		writeError(w, errUnauthorized)
+		return
+	}
+	t, err := templateFromRoom(room, req.Name, req.AdminKey)
+	mu.RUnlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	templatesMu.Lock()
+	templates[t.ID] = t
+	saveTemplates()
+	templatesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"template_id": t.ID,
+		"message":     "Template saved successfully",
+	})
+}
+
+// ListTemplatesHandler lists the templates admin_key may use, newest first,
+// optionally for one host_id. Sets and rubrics are left out.
+func ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, hostID := q.Get("admin_key"), q.Get("host_id")
+	if key == "" {
+		writeError(w, errUnauthorized)
+		return
+	}
+	list := []Template{}
+	templatesMu.RLock()
+	for _, t := range templates {
+		if (hostID != "" && t.HostID != hostID) || !t.allows(key) {
+			continue
+		}
+		view := *t
+		view.KeyHash = ""
+		view.Sets = nil
+		view.Rubric = nil
+		list = append(list, view)
+	}
+	templatesMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
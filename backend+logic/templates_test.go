@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoomTemplates(t *testing.T) {
+	roomID := createTestRoom(t, "tpl-key")
+	joinTestRoom(t, roomID, "tpl-student", "REG1000")
+	update := []byte(`{"room_id": "` + roomID + `", "admin_key": "tpl-key", "sets": {"A": "https://example.com/a"},
+		"time_allocated": "45m", "scan_mode": 1, "allowed_apps": ["code"], "blur_threshold": 2,
+		"offline_grace": "2m", "scan_interval": "1m", "require_join_token": true, "flag_policy": {"shared_ip": true, "severities": {"shared_ip": "high"}},
+		"rubric": {"questions": {"q1": {"answer": "B", "points": 1}}}}`)
+	rr := httptest.NewRecorder()
+	UpdateRoomHandler(rr, httptest.NewRequest("POST", "/update-room", bytes.NewBuffer(update)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("UpdateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	SaveTemplateHandler(rr, httptest.NewRequest("POST", "/save-template",
+		bytes.NewBufferString(`{"room_id": "`+roomID+`", "admin_key": "tpl-key", "name": "Weekly quiz"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SaveTemplate returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var saved map[string]string
+	json.NewDecoder(rr.Body).Decode(&saved)
+	templateID := saved["template_id"]
+	if _, err := os.Stat(dataPath(templatesFile)); err != nil {
+		t.Fatalf("Expected templates to be persisted: %v", err)
+	}
+	if leftover, _ := filepath.Glob(dataPath(templatesFile + ".*.tmp")); len(leftover) > 0 {
+		t.Fatalf("Temp files left behind: %v", leftover)
+	}
+
+	// Listed to the key it was saved with, without the questions or answers
+	listed := func(query string) (int, []Template) {
+		rr := httptest.NewRecorder()
+		ListTemplatesHandler(rr, httptest.NewRequest("GET", "/templates?host_id=host1"+query, nil))
+		var list []Template
+		json.NewDecoder(rr.Body).Decode(&list)
+		return rr.Code, list
+	}
+	if code, _ := listed(""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 listing without a key, got %d", code)
+	}
+	if _, list := listed("&admin_key=someone-else"); len(list) != 0 {
+		t.Fatalf("Expected another key to see no templates, got %+v", list)
+	}
+	_, list := listed("&admin_key=tpl-key")
+	if len(list) != 1 || list[0].ID != templateID || list[0].Name != "Weekly quiz" {
+		t.Fatalf("Expected the saved template listed, got %+v", list)
+	}
+	if tpl := list[0]; tpl.Rubric != nil || len(tpl.Sets) != 0 || tpl.KeyHash != "" {
+		t.Fatalf("Listed template exposed sets, rubric or key: %+v", tpl)
+	}
+
+	prev := masterKey
+	masterKey = "tpl-master"
+	t.Cleanup(func() { masterKey = prev })
+	if _, list := listed("&admin_key=tpl-master"); len(list) != 1 {
+		t.Fatalf("Expected the master key to see the template, got %+v", list)
+	}
+
+	// Someone else holding only the ID cannot copy the questions and rubric
+	create := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		CreateRoomHandler(rr, httptest.NewRequest("POST", "/create-room", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := create(`{"host_id": "host1", "session_name": "Stolen", "admin_key": "k2", "template_id": "` + templateID + `"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 using a template with another key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = create(`{"host_id": "host1", "session_name": "Week 2", "admin_key": "k2", "template_id": "` + templateID + `", "template_key": "tpl-key"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("CreateRoom returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	mu.RLock()
+	room := *rooms[created["room_id"]]
+	mu.RUnlock()
+	if room.Sets["A"] != "https://example.com/a" || room.TimeAllocated.Std() != 45*time.Minute ||
+		room.ScanMode != Whitelist || len(room.AllowedApps) != 1 || room.BlurThreshold != 2 || room.Rubric == nil {
+		t.Fatalf("Room was not prefilled from the template: %+v", room)
+	}
+	if room.OfflineGrace.Std() != 2*time.Minute || room.ScanInterval.Std() != time.Minute || !room.RequireJoinToken ||
+		room.FlagPolicy == nil || !room.FlagPolicy.SharedIP || room.FlagPolicy.Severities["shared_ip"] != "high" {
+		t.Fatalf("Room did not get the template's policies: %+v", room)
+	}
+	if len(room.Students) != 0 || room.SessionName != "Week 2" || room.AdminKey != "k2" {
+		t.Fatalf("Template leaked sitting data into the new room: %+v", room)
+	}
+
+	if rr := create(`{"host_id": "host1", "session_name": "x", "admin_key": "k", "template_id": "missing"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown template, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// version and commit identify the build. Release builds set them with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Without ldflags the commit falls back to the VCS stamp go build records.
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// startedAt is when the process started; uptime uses the real clock, not now
+var startedAt = time.Now()
+
+// VersionInfo is the /version payload
+type VersionInfo struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"go_version"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    Duration  `json:"uptime"`
+}
+
+// buildCommit returns the injected commit, or the one go build stamped
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+func versionInfo() VersionInfo {
+	return VersionInfo{
+		Version:   version,
+		Commit:    buildCommit(),
+		GoVersion: runtime.Version(),
+		StartedAt: startedAt,
+		Uptime:    Duration(time.Since(startedAt).Round(time.Second)),
+	}
+}
+
+// String is the one-line form printed at startup
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("version %s, commit %s, %s", v.Version, v.Commit, v.GoVersion)
+}
+
+// VersionHandler reports which build is running and for how long
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionInfo())
+}
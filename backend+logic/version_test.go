@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	prevVersion, prevCommit := version, commit
+	version, commit = "1.4.0", "abc1234"
+	t.Cleanup(func() { version, commit = prevVersion, prevCommit })
+
+	rr := httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Decoding /version: %v", err)
+	}
+	for _, field := range []string{"version", "commit", "go_version", "started_at", "uptime"} {
+		if _, ok := raw[field]; !ok {
+			t.Errorf("Expected %q in %s", field, rr.Body.String())
+		}
+	}
+
+	var got VersionInfo
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Version != "1.4.0" || got.Commit != "abc1234" || got.GoVersion != runtime.Version() || got.Uptime < 0 {
+		t.Errorf("Unexpected version info %+v", got)
+	}
+}
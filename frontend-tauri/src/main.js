@@ -489,7 +489,9 @@ async function pollProcessShield() {
         if (!response.ok) return;
         const data = await response.json();
 
-        if (data.forbidden_found) {
+        if (data.scan_error) {
+            addLogEntry('alert', 'Process Shield: scan unavailable');
+        } else if (data.forbidden_found) {
             const apps = data.processes.join(', ');
             // Check if we just logged this to avoid spamming? 
             // For now, simple logging.
@@ -704,8 +706,19 @@ function getAdminWsBase() {
 // const WS_BASE = "ws://localhost:8080/ws"; // Deprecated
 let ws = null;
 let wsRetries = 0;
+let wsLastSeq = 0; // Last broadcast seen, sent as resume_from after a reconnect
 const { Command } = window.__TAURI__.shell; // Access shell plugin
 
+// Reads the message out of a backend error response: {"error": {"code", "message"}}
+async function errorMessage(res) {
+    const body = await res.text();
+    try {
+        return JSON.parse(body).error.message;
+    } catch (e) {
+        return body;
+    }
+}
+
 // Backend Management
 async function checkBackendHealth() {
     try {
@@ -844,15 +857,21 @@ async function handleJoinRoom() {
     console.log(`[DEBUG] API URL: ${getStudentApiBase()}/join-room`);
 
     try {
+        // Reconnecting needs the token from the first join
+        const tokenKey = `session_token:${roomId}:${regNo}`;
+        const body = {
+            room_id: roomId,
+            username: name,
+            regno: regNo,
+            user_id: regNo // Using RegNo as ID for simplicity
+        };
+        const savedToken = localStorage.getItem(tokenKey);
+        if (savedToken) body.session_token = savedToken;
+
         const res = await fetch(`${getStudentApiBase()}/join-room`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({
-                room_id: roomId,
-                username: name,
-                regno: regNo,
-                user_id: regNo // Using RegNo as ID for simplicity
-            })
+            body: JSON.stringify(body)
         });
 
         console.log(`[DEBUG] Response Status: ${res.status}`);
@@ -862,6 +881,7 @@ async function handleJoinRoom() {
         if (res.ok) {
             // Success!
             // console.log("Joined!", data);
+            localStorage.setItem(tokenKey, data.session_token);
 
             isStudentSessionActive = true;
 
@@ -876,7 +896,7 @@ async function handleJoinRoom() {
 
             // TODO: Update UI with session info if needed
         } else {
-            showJoinError(data.message || "Failed to join room");
+            showJoinError(data.error?.message || "Failed to join room");
         }
     } catch (e) {
         console.error("[DEBUG] Join Room Error Details:", e);
@@ -938,7 +958,10 @@ if (monitorShieldBtn) {
             const res = await fetch(`${getAdminApiBase()}/scan`);
             const data = await res.json();
 
-            if (data.forbidden_found) {
+            if (data.scan_error) {
+                shieldStatusText.innerText = "⚠️ Scan unavailable";
+                shieldStatusText.style.color = 'var(--accent-warning)';
+            } else if (data.forbidden_found) {
                 shieldStatusText.innerText = `⚠️ REMOVED: ${data.processes.join(', ')}`;
                 shieldStatusText.style.color = 'var(--accent-warning)';
             } else {
@@ -966,46 +989,55 @@ async function fetchRooms() {
 
     try {
         const res = await fetch(`${getAdminApiBase()}/get-all-rooms`);
-        const rooms = await res.json();
-
+        const { rooms } = await res.json();
+        renderRooms(rooms);
+    } catch (e) {
+        console.error("Failed to fetch rooms:", e);
         loading.style.display = 'none';
+        tbody.innerHTML = `<tr><td colspan="5" style="color: var(--accent-danger); text-align: center;">Failed to load rooms. Is backend running?</td></tr>`;
+    }
+}
 
-        if (!rooms || rooms.length === 0) {
-            empty.style.display = 'block';
-            return;
-        }
-
-        rooms.forEach(r => {
-            const tr = document.createElement('tr');
-            tr.className = 'room-row';
-            tr.dataset.id = r.id; // Store ID for click
-            tr.style.cursor = 'pointer';
-
-            // Format time
-            const startTime = r.start_time ? new Date(r.start_time).toLocaleTimeString() : '-';
+// Draws the room list from /get-all-rooms or a ROOM_LIST_UPDATE payload
+function renderRooms(rooms) {
+    const tbody = document.getElementById('rooms-list-body');
+    const loading = document.getElementById('rooms-loading');
+    const empty = document.getElementById('rooms-empty');
 
-            let statusBadge = '';
-            if (r.active_status === 0) statusBadge = '<span class="status-badge status-waiting">Waiting</span>';
-            else if (r.active_status === 1) statusBadge = '<span class="status-badge status-active">Active</span>';
-            else statusBadge = '<span class="status-badge">Finished</span>';
+    tbody.innerHTML = '';
+    loading.style.display = 'none';
+    empty.style.display = 'none';
 
-            tr.innerHTML = `
-                <td class="mono" style="font-weight: 700; color: var(--accent-primary);">${r.id}</td>
-                <td>${r.session_name}</td>
-                <td>${statusBadge}</td>
-                <td>${startTime}</td>
-                <td><button class="small-btn">View</button></td>
-            `;
-            tbody.appendChild(tr);
-        });
+    if (!rooms || rooms.length === 0) {
+        empty.style.display = 'block';
+        return;
+    }
 
-        bindRoomListEvents();
+    rooms.forEach(r => {
+        const tr = document.createElement('tr');
+        tr.className = 'room-row';
+        tr.dataset.id = r.id; // Store ID for click
+        tr.style.cursor = 'pointer';
+
+        // Format time
+        const startTime = r.start_time ? new Date(r.start_time).toLocaleTimeString() : '-';
+
+        let statusBadge = '';
+        if (r.active_status === 'Waiting') statusBadge = '<span class="status-badge status-waiting">Waiting</span>';
+        else if (r.active_status === 'Active') statusBadge = '<span class="status-badge status-active">Active</span>';
+        else statusBadge = '<span class="status-badge">Finished</span>';
+
+        tr.innerHTML = `
+            <td class="mono" style="font-weight: 700; color: var(--accent-primary);">${r.id}</td>
+            <td>${r.session_name}</td>
+            <td>${statusBadge}</td>
+            <td>${startTime}</td>
+            <td><button class="small-btn">View</button></td>
+        `;
+        tbody.appendChild(tr);
+    });
 
-    } catch (e) {
-        console.error("Failed to fetch rooms:", e);
-        loading.style.display = 'none';
-        tbody.innerHTML = `<tr><td colspan="5" style="color: var(--accent-danger); text-align: center;">Failed to load rooms. Is backend running?</td></tr>`;
-    }
+    bindRoomListEvents();
 }
 
 if (refreshRoomsBtn) {
@@ -1055,7 +1087,7 @@ if (crSubmitBtn) {
                 document.getElementById('cr-host').value = '';
                 document.getElementById('cr-key').value = '';
             } else {
-                const err = await res.text();
+                const err = await errorMessage(res);
                 alert("Failed to create room: " + err);
             }
         } catch (e) {
@@ -1066,6 +1098,7 @@ if (crSubmitBtn) {
 
 // --- Room Details Logic ---
 let currentRoomId = null;
+let currentRoomData = null; // Last full room seen over the websocket, patched by ROOM_DELTA
 let roomPollInterval = null;
 
 async function openRoomDetails(roomId) {
@@ -1098,6 +1131,7 @@ function closeRoomDetails() {
     }
 
     currentRoomId = null;
+    currentRoomData = null;
     fetchRooms(); // Refresh main list
 }
 
@@ -1122,11 +1156,19 @@ document.querySelectorAll('.tab-btn').forEach(btn => {
     });
 });
 
+// Query for /get-room; the question set URLs are only returned with the admin key
+function roomQuery() {
+    const params = new URLSearchParams({ room_id: currentRoomId });
+    const key = document.getElementById('rd-key').value;
+    if (key) params.set('admin_key', key);
+    return params.toString();
+}
+
 async function fetchRoomDetails() {
     if (!currentRoomId) return;
 
     try {
-        const res = await fetch(`${API_BASE}/get-room?room_id=${currentRoomId}`);
+        const res = await fetch(`${API_BASE}/get-room?${roomQuery()}`);
         if (!res.ok) return;
         const room = await res.json();
 
@@ -1138,12 +1180,7 @@ async function fetchRoomDetails() {
         // Update Settings Form (only if not focused)
         if (document.activeElement.tagName !== 'INPUT' && document.activeElement.tagName !== 'TEXTAREA') {
             document.getElementById('rd-name').value = room.session_name;
-            document.getElementById('rd-duration').value = room.time_allocated ? (room.time_allocated / 60000000000) : 0; // ns to min? Go Duration is ns. wait.
-            // Go JSON duration is usually represented as string "1h2m" or similar if generic json marshal, but here it might be ns number.
-            // Let's check Go struct. It treats Duration as int64 ns often in stdlib? No, standard json marshal for time.Duration is integer ns.
-            // Actually standard json marshal for duration is just number of nanoseconds.
-            // 1 min = 60 * 1000 * 1000 * 1000 = 6e10.
-            document.getElementById('rd-duration').value = Math.round(room.time_allocated / 60000000000);
+            document.getElementById('rd-duration').value = durationToMinutes(room.time_allocated);
 
             document.getElementById('rd-status-select').value = room.active_status;
 
@@ -1209,7 +1246,7 @@ document.getElementById('rd-save-btn').addEventListener('click', async () => {
     if (!currentRoomId) return;
     const name = document.getElementById('rd-name').value;
     const durationMins = parseInt(document.getElementById('rd-duration').value);
-    const status = parseInt(document.getElementById('rd-status-select').value);
+    const status = document.getElementById('rd-status-select').value;
     const key = document.getElementById('rd-key').value;
 
     // Collect Sets
@@ -1222,9 +1259,9 @@ document.getElementById('rd-save-btn').addEventListener('click', async () => {
         }
     });
 
-    if (Object.keys(sets).length === 0) {
-        // Optional warning or just null
-    }
+    // Without the admin key the form never saw the sets, so an empty list
+    // leaves them alone rather than clearing them
+    const hasSets = Object.keys(sets).length > 0;
 
     if (!key) {
         alert("Admin Key is required to save changes.");
@@ -1239,9 +1276,9 @@ document.getElementById('rd-save-btn').addEventListener('click', async () => {
                 room_id: currentRoomId,
                 admin_key: key,
                 session_name: name,
-                time_allocated: durationMins * 60000000000,
+                time_allocated: `${durationMins}m`,
                 active_status: status,
-                sets: sets
+                sets: hasSets ? sets : undefined
             })
         });
 
@@ -1249,7 +1286,7 @@ document.getElementById('rd-save-btn').addEventListener('click', async () => {
             alert("Changes saved!");
             fetchRoomDetails();
         } else {
-            alert("Failed: " + await res.text());
+            alert("Failed: " + await errorMessage(res));
         }
     } catch (e) {
         alert("Error: " + e);
@@ -1260,21 +1297,33 @@ function getStatusBadgeHTML(status) {
     // 0: Online, 1: Offline/Kick, 2: Submitted, 3: Flagged
     // Based on UStatusEnum in backend
     switch (status) {
-        case 0: return '<span class="status-badge status-active">Online</span>';
-        case 1: return '<span class="status-badge" style="color:#ef4444; border-color:#ef4444; background:rgba(239,68,68,0.1)">Offline</span>';
-        case 2: return '<span class="status-badge" style="color:#10b981; border-color:#10b981; background:rgba(16,185,129,0.1)">Submitted</span>';
-        case 3: return '<span class="status-badge" style="color:#f59e0b; border-color:#f59e0b; background:rgba(245,158,11,0.1)">Flagged</span>';
+        case 'Online': return '<span class="status-badge status-active">Online</span>';
+        case 'Offline': return '<span class="status-badge" style="color:#ef4444; border-color:#ef4444; background:rgba(239,68,68,0.1)">Offline</span>';
+        case 'Submitted': return '<span class="status-badge" style="color:#10b981; border-color:#10b981; background:rgba(16,185,129,0.1)">Submitted</span>';
+        case 'Flagged': return '<span class="status-badge" style="color:#f59e0b; border-color:#f59e0b; background:rgba(245,158,11,0.1)">Flagged</span>';
         default: return 'Unknown';
     }
 }
 
+// The backend sends durations as Go strings like "1h30m0s"
+function durationToMinutes(duration) {
+    if (!duration) return 0;
+    if (typeof duration === 'number') return Math.round(duration / 60000000000);
+    const units = { h: 60, m: 1, s: 1 / 60 };
+    let mins = 0;
+    for (const [, value, unit] of duration.matchAll(/([\d.]+)(h|m|s)/g)) {
+        mins += parseFloat(value) * units[unit];
+    }
+    return Math.round(mins);
+}
+
 function updateBadge(el, status) {
     // 0: Waiting, 1: Active, 2: NetworkLoss, 3: Paused, 4: Complete
     el.className = 'status-badge';
-    if (status === 0) { el.classList.add('status-waiting'); el.innerText = 'WAITING'; }
-    else if (status === 1) { el.classList.add('status-active'); el.innerText = 'ACTIVE'; }
-    else if (status === 3) { el.classList.add('status-waiting'); el.innerText = 'PAUSED'; el.style.color = '#f59e0b'; }
-    else if (status === 4) { el.classList.add('status-active'); el.innerText = 'COMPLETE'; el.style.color = '#3b82f6'; }
+    if (status === 'Waiting') { el.classList.add('status-waiting'); el.innerText = 'WAITING'; }
+    else if (status === 'Active') { el.classList.add('status-active'); el.innerText = 'ACTIVE'; }
+    else if (status === 'Paused') { el.classList.add('status-waiting'); el.innerText = 'PAUSED'; el.style.color = '#f59e0b'; }
+    else if (status === 'Complete') { el.classList.add('status-active'); el.innerText = 'COMPLETE'; el.style.color = '#3b82f6'; }
 }
 
 // Expose for onClick
@@ -1335,16 +1384,42 @@ function initWebSocket() {
 
         // Subscribe to All Rooms List by default if we are in admin view
         if (!adminContainer.classList.contains('fade-out')) {
-            ws.send(JSON.stringify({ action: "subscribe_all" }));
+            ws.send(JSON.stringify({ action: "subscribe_all", resume_from: wsLastSeq }));
+        }
+        // Catch up on the open room after a reconnect
+        if (currentRoomId) {
+            ws.send(JSON.stringify({ action: "subscribe_room", room_id: currentRoomId, resume_from: wsLastSeq }));
         }
     };
 
     ws.onmessage = (event) => {
         try {
             const msg = JSON.parse(event.data);
+            if (msg.seq) {
+                wsLastSeq = Math.max(wsLastSeq, msg.seq);
+            }
 
-            if (msg.type === "ROOM_LIST_UPDATE") {
+            if (msg.type === "RESYNC_REQUIRED") {
+                // Missed more than the server buffers, so refetch everything
                 fetchRooms();
+                if (currentRoomId) fetchRoomDetails();
+            } else if (msg.type === "ROOM_LIST_UPDATE") {
+                // The payload is the full room list, so no refetch is needed
+                if (Array.isArray(msg.payload)) renderRooms(msg.payload); else fetchRooms();
+            } else if (msg.type === "ROOM_DELTA") {
+                // Only the changed student is sent; patch it into the cached room
+                const { room_id, student } = msg.payload;
+                if (currentRoomId && room_id === currentRoomId) {
+                    if (currentRoomData && currentRoomData.id === room_id) {
+                        const students = currentRoomData.students || [];
+                        const idx = students.findIndex(s => s.id === student.id);
+                        if (idx >= 0) students[idx] = student; else students.push(student);
+                        currentRoomData.students = students;
+                        updateRoomDetailsUI(currentRoomData);
+                    } else {
+                        fetchRoomDetails();
+                    }
+                }
             } else if (msg.type === "ROOM_UPDATE") {
                 // If the payload is the room object, we can update UI directly?
                 // Or just re-fetch to be safe/simple.
@@ -1387,6 +1462,7 @@ function initWebSocket() {
 // Refactored UI update for reuse
 function updateRoomDetailsUI(room) {
     if (!room) return;
+    currentRoomData = room;
 
     // Update Header
     document.getElementById('rd-title').innerHTML = `${room.session_name} <span style="font-family:monospace; background:rgba(255,255,255,0.1); padding:2px 6px; border-radius:4px; font-size:0.8em; margin-left:8px;">${room.id}</span>`;
@@ -1423,14 +1499,14 @@ const originalFetchDetails = fetchRoomDetails;
 fetchRoomDetails = async () => {
     if (!currentRoomId) return;
     try {
-        const res = await fetch(`${getAdminApiBase()}/get-room?room_id=${currentRoomId}`);
+        const res = await fetch(`${getAdminApiBase()}/get-room?${roomQuery()}`);
         if (!res.ok) return;
         const room = await res.json();
 
         // Settings form population remains here because it checks focus
         if (document.activeElement.tagName !== 'INPUT' && document.activeElement.tagName !== 'TEXTAREA') {
             document.getElementById('rd-name').value = room.session_name;
-            document.getElementById('rd-duration').value = Math.round(room.time_allocated / 60000000000);
+            document.getElementById('rd-duration').value = durationToMinutes(room.time_allocated);
             document.getElementById('rd-status-select').value = room.active_status;
 
             const container = document.getElementById('sets-container');